@@ -1,12 +1,17 @@
 package mq
 
 import (
+	"errors"
 	"fmt"
 	"log"
 
 	"github.com/motain/amqp"
 )
 
+// ErrRequeue is returned (or wrapped) by a handler
+// to requeue the message instead of dropping it
+var ErrRequeue = errors.New("mq: requeue message")
+
 type (
 	// AMQPHanlder describes logic for
 	// processing an message from an amqp server
@@ -64,7 +69,7 @@ func (c *AMQPConsumer) Consume() error {
 			if err := tryCatchHandler(c.Handler, &d); err != nil {
 				c.logger.Println(err)
 
-				if err := d.Nack(false, false); err != nil {
+				if err := d.Nack(false, errors.Is(err, ErrRequeue)); err != nil {
 					c.logger.Println(err)
 				}
 
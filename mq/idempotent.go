@@ -0,0 +1,126 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"github.com/motain/amqp"
+)
+
+// defaultIdempotencyLease holds the default
+// in-progress lease of a processed message
+const defaultIdempotencyLease = time.Minute
+
+type (
+	// Deduplicator describes logic for
+	// marking processed messages,
+	// e.g. redis/idempotency.Store.
+	//
+	// Acquire takes an in-progress lease and returns its token
+	// if the message has to be processed, an empty token if the
+	// message is done or another delivery holds the lease, and
+	// an error if the lease can not be taken. Complete and Release
+	// change the key only while it holds the lease token
+	Deduplicator interface {
+		Acquire(ctx context.Context, key string, lease time.Duration) (string, error)
+		Complete(ctx context.Context, key, token string, ttl time.Duration) error
+		Release(ctx context.Context, key, token string) error
+	}
+
+	// IdempotentHandler implements AMQPHandler interface
+	// and drops redelivered messages which have
+	// already been processed.
+	//
+	// Messages are identified by MessageId property,
+	// messages without it are always handled. A message
+	// is marked done for TTL once the handler succeeds.
+	// A message leased by another delivery is dropped as well,
+	// the delivery holding the lease is redelivered by the broker
+	// if its consumer crashes. If the handler fails or panics
+	// the lease is released and its error is returned as is,
+	// so the message is processed on a later delivery; it is
+	// requeued only if the handler error wraps ErrRequeue.
+	// Deduplicator errors are returned as well, so the
+	// messages are dropped unless RequeueOnStoreError is set
+	IdempotentHandler struct {
+		Handler      AMQPHandler
+		Deduplicator Deduplicator
+		TTL          time.Duration
+		// Lease holds the in-progress lease, keep it
+		// above the handler processing time
+		Lease time.Duration
+		// RequeueOnStoreError requeues the messages received
+		// while the Deduplicator fails, they are redelivered
+		// without a delay until the Deduplicator recovers
+		RequeueOnStoreError bool
+		// Logger logs dropped duplicates and
+		// deduplication errors, the logs are discarded if nil
+		Logger *log.Logger
+	}
+)
+
+// NewIdempotentHandler inits and returns a pointer
+// to a new IdempotentHandler instance which keeps
+// processed message ids for ttl.
+// The logs are discarded if logger is nil
+func NewIdempotentHandler(h AMQPHandler, d Deduplicator, logger *log.Logger, ttl time.Duration) (*IdempotentHandler, error) {
+	if ttl < time.Millisecond {
+		return nil, errors.New("mq: idempotency ttl must be at least 1ms")
+	}
+
+	return &IdempotentHandler{
+		Handler:      h,
+		Deduplicator: d,
+		TTL:          ttl,
+		Lease:        defaultIdempotencyLease,
+		Logger:       logger,
+	}, nil
+}
+
+// HandleDelivery calls the handler
+// unless the message has already been processed
+func (h *IdempotentHandler) HandleDelivery(d *amqp.Delivery) error {
+	if d.MessageId == "" {
+		return h.Handler.HandleDelivery(d)
+	}
+
+	token, err := h.Deduplicator.Acquire(context.Background(), d.MessageId, h.Lease)
+	if err != nil {
+		if h.RequeueOnStoreError {
+			return fmt.Errorf("mq: message %s: %w: %w", d.MessageId, err, ErrRequeue)
+		}
+		return fmt.Errorf("mq: message %s: %w", d.MessageId, err)
+	}
+
+	logger := loggerOrDiscard(h.Logger)
+	if token == "" {
+		logger.Printf("mq: dropped duplicate message %s", d.MessageId)
+		return nil
+	}
+
+	if err := tryCatchHandler(h.Handler, d); err != nil {
+		if err := h.Deduplicator.Release(context.Background(), d.MessageId, token); err != nil {
+			logger.Printf("mq: message %s: deduplication: %s", d.MessageId, err)
+		}
+		return err
+	}
+
+	if err := h.Deduplicator.Complete(context.Background(), d.MessageId, token, h.TTL); err != nil {
+		logger.Printf("mq: message %s: deduplication: %s", d.MessageId, err)
+	}
+
+	return nil
+}
+
+// loggerOrDiscard returns the logger,
+// a logger discarding the logs if it is nil
+func loggerOrDiscard(logger *log.Logger) *log.Logger {
+	if logger == nil {
+		return log.New(io.Discard, "", 0)
+	}
+	return logger
+}
@@ -0,0 +1,159 @@
+package mq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/motain/amqp"
+)
+
+type IdempotentHandlerTestSuite struct{}
+
+var _ = gc.Suite(&IdempotentHandlerTestSuite{})
+
+func TestMQ(t *testing.T) { gc.TestingT(t) }
+
+// dedupStub implements Deduplicator interface
+// and records the calls it receives
+type dedupStub struct {
+	mu         sync.Mutex
+	token      string
+	acquireErr error
+	acquired   []string
+	completed  []string
+	released   []string
+	ttl        time.Duration
+}
+
+func (d *dedupStub) Acquire(_ context.Context, key string, _ time.Duration) (string, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.acquired = append(d.acquired, key)
+	return d.token, d.acquireErr
+}
+
+func (d *dedupStub) Complete(_ context.Context, key, token string, ttl time.Duration) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.completed = append(d.completed, key+"/"+token)
+	d.ttl = ttl
+	return nil
+}
+
+func (d *dedupStub) Release(_ context.Context, key, token string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.released = append(d.released, key+"/"+token)
+	return nil
+}
+
+// newIdempotentStub returns an IdempotentHandler calling h
+// and the count of the handler calls
+func newIdempotentStub(c *gc.C, d Deduplicator, h AMQPHandlerFunc) (*IdempotentHandler, *int) {
+	calls := 0
+	ih, err := NewIdempotentHandler(AMQPHandlerFunc(func(dl *amqp.Delivery) error {
+		calls++
+		return h(dl)
+	}), d, nil, time.Hour)
+	c.Assert(err, gc.IsNil)
+	return ih, &calls
+}
+
+func ok(*amqp.Delivery) error { return nil }
+
+func (s *IdempotentHandlerTestSuite) TestDuplicateDropped(c *gc.C) {
+	d := &dedupStub{}
+	h, calls := newIdempotentStub(c, d, ok)
+
+	err := h.HandleDelivery(&amqp.Delivery{MessageId: "m1"})
+	c.Check(err, gc.IsNil)
+	c.Check(*calls, gc.Equals, 0)
+	c.Check(d.acquired, gc.DeepEquals, []string{"m1"})
+	c.Check(d.completed, gc.IsNil)
+	c.Check(d.released, gc.IsNil)
+}
+
+func (s *IdempotentHandlerTestSuite) TestAcquireError(c *gc.C) {
+	expectedErr := errors.New("test error")
+	d := &dedupStub{acquireErr: expectedErr}
+	h, calls := newIdempotentStub(c, d, ok)
+
+	err := h.HandleDelivery(&amqp.Delivery{MessageId: "m1"})
+	c.Check(errors.Is(err, expectedErr), gc.Equals, true)
+	c.Check(errors.Is(err, ErrRequeue), gc.Equals, false)
+	c.Check(*calls, gc.Equals, 0)
+
+	h.RequeueOnStoreError = true
+	err = h.HandleDelivery(&amqp.Delivery{MessageId: "m1"})
+	c.Check(errors.Is(err, ErrRequeue), gc.Equals, true)
+	c.Check(*calls, gc.Equals, 0)
+}
+
+func (s *IdempotentHandlerTestSuite) TestHandlerErrorReleased(c *gc.C) {
+	expectedErr := errors.New("test error")
+	d := &dedupStub{token: "t1"}
+	h, calls := newIdempotentStub(c, d, func(*amqp.Delivery) error { return expectedErr })
+
+	err := h.HandleDelivery(&amqp.Delivery{MessageId: "m1"})
+	c.Check(err, gc.Equals, expectedErr)
+	c.Check(*calls, gc.Equals, 1)
+	c.Check(d.released, gc.DeepEquals, []string{"m1/t1"})
+	c.Check(d.completed, gc.IsNil)
+
+	// handlers requeue the messages with ErrRequeue
+	requeueErr := fmt.Errorf("test error: %w", ErrRequeue)
+	h, _ = newIdempotentStub(c, d, func(*amqp.Delivery) error { return requeueErr })
+	c.Check(h.HandleDelivery(&amqp.Delivery{MessageId: "m1"}), gc.Equals, requeueErr)
+}
+
+func (s *IdempotentHandlerTestSuite) TestHandlerPanicReleased(c *gc.C) {
+	d := &dedupStub{token: "t1"}
+	h, _ := newIdempotentStub(c, d, func(*amqp.Delivery) error { panic("test panic") })
+
+	err := h.HandleDelivery(&amqp.Delivery{MessageId: "m1"})
+	c.Check(err, gc.ErrorMatches, "panic: test panic")
+	c.Check(errors.Is(err, ErrRequeue), gc.Equals, false)
+	c.Check(d.released, gc.DeepEquals, []string{"m1/t1"})
+	c.Check(d.completed, gc.IsNil)
+}
+
+func (s *IdempotentHandlerTestSuite) TestMissingMessageIdNotDeduplicated(c *gc.C) {
+	d := &dedupStub{}
+	h, calls := newIdempotentStub(c, d, ok)
+
+	err := h.HandleDelivery(&amqp.Delivery{})
+	c.Check(err, gc.IsNil)
+	c.Check(*calls, gc.Equals, 1)
+	c.Check(d.acquired, gc.IsNil)
+	c.Check(d.completed, gc.IsNil)
+}
+
+func (s *IdempotentHandlerTestSuite) TestSuccessCompleted(c *gc.C) {
+	d := &dedupStub{token: "t1"}
+	h, calls := newIdempotentStub(c, d, ok)
+
+	err := h.HandleDelivery(&amqp.Delivery{MessageId: "m1"})
+	c.Check(err, gc.IsNil)
+	c.Check(*calls, gc.Equals, 1)
+	c.Check(d.completed, gc.DeepEquals, []string{"m1/t1"})
+	c.Check(d.ttl, gc.Equals, time.Hour)
+	c.Check(d.released, gc.IsNil)
+}
+
+func (s *IdempotentHandlerTestSuite) TestLiteralHandler(c *gc.C) {
+	d := &dedupStub{}
+	h := &IdempotentHandler{Handler: AMQPHandlerFunc(ok), Deduplicator: d, TTL: time.Hour}
+
+	// the duplicate is logged to the discarded logger
+	c.Check(h.HandleDelivery(&amqp.Delivery{MessageId: "m1"}), gc.IsNil)
+}
+
+func (s *IdempotentHandlerTestSuite) TestInvalidTTL(c *gc.C) {
+	_, err := NewIdempotentHandler(AMQPHandlerFunc(ok), &dedupStub{}, nil, 0)
+	c.Check(err, gc.NotNil)
+}
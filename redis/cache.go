@@ -0,0 +1,353 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ErrCacheMiss is returned when the key is not found in the cache
+var ErrCacheMiss = errors.New("redis: cache miss")
+
+// DefaultLoadTimeout holds the default GetOrLoad load timeout
+const DefaultLoadTimeout = 30 * time.Second
+
+type (
+	// Cache is a struct type
+	// which provides a key/value cache
+	// on top of a Connector.
+	//
+	// Values are serialized with the Codec
+	// and stored as redis strings.
+	//
+	// Concurrent GetOrLoad calls for the same key
+	// are deduplicated within the process, so only
+	// one of them calls the load func. The load func
+	// context is detached from the caller one, so a
+	// canceled caller does not fail the other ones
+	Cache struct {
+		connector Connector
+		codec     Codec
+		flights   flightGroup
+
+		// EarlyRefresh holds the expected load duration used for
+		// probabilistic early expiration (XFetch): the closer a key
+		// is to its expiry, the more likely GetOrLoad reloads it
+		// ahead of time, so popular keys do not expire for all
+		// the callers at once. Zero value disables early refresh
+		EarlyRefresh time.Duration
+		// EarlyRefreshBeta scales the early refresh probability,
+		// values greater than 1 favor earlier refreshes.
+		// 1 is used if the value is not positive
+		EarlyRefreshBeta float64
+		// LoadTimeout holds the load func context timeout,
+		// DefaultLoadTimeout is used if zero
+		LoadTimeout time.Duration
+	}
+
+	// flightGroup deduplicates concurrent
+	// loads of the same key
+	flightGroup struct {
+		mu    sync.Mutex
+		calls map[string]*flightCall
+	}
+
+	// flightCall holds an in-flight
+	// or completed load results
+	flightCall struct {
+		done chan struct{}
+		b    []byte
+		err  error
+	}
+
+	// LoadFunc is a func type which loads
+	// a value missing in the cache
+	LoadFunc func(ctx context.Context) (interface{}, error)
+)
+
+// NewCache inits and returns a pointer to Cache instance.
+// JSONCodec is used if codec is nil
+func NewCache(c Connector, codec Codec) *Cache {
+	if codec == nil {
+		codec = JSONCodec{}
+	}
+
+	return &Cache{connector: c, codec: codec}
+}
+
+// Get loads the cached key value into v.
+// ErrCacheMiss is returned if the key is not found
+func (c *Cache) Get(ctx context.Context, key string, v interface{}) error {
+	b, err := c.getBytes(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	return c.codec.Unmarshal(b, v)
+}
+
+// Set stores v under the key.
+// The key never expires if ttl is not positive,
+// ttls shorter than 1ms are rounded up to 1ms
+func (c *Cache) Set(ctx context.Context, key string, v interface{}, ttl time.Duration) error {
+	b, err := c.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	return c.setBytes(ctx, key, b, ttl)
+}
+
+// Delete removes the keys from the cache
+func (c *Cache) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	redisConn, err := ConnectContext(ctx, c.connector)
+	if err != nil {
+		return err
+	}
+	defer redisConn.Close()
+
+	_, err = redisConn.Do("DEL", redis.Args{}.AddFlat(keys)...)
+	return err
+}
+
+// GetOrLoad loads the cached key value into v.
+// On cache miss or early refresh the value is retrieved
+// with load func, stored under the key with ttl and loaded into v.
+// v is loaded even if storing the value fails,
+// the store error is returned in that case.
+// A failed early refresh falls back to the cached value
+func (c *Cache) GetOrLoad(ctx context.Context, key string, v interface{}, ttl time.Duration, load LoadFunc) error {
+	b, storeErr := c.getOrLoadBytes(ctx, key, ttl, load, nil)
+	if b == nil {
+		return storeErr
+	}
+
+	if err := c.codec.Unmarshal(b, v); err != nil {
+		return err
+	}
+
+	return storeErr
+}
+
+// getOrLoadBytes returns the raw key value loading it on cache
+// miss or early refresh. The loaded value is returned along with
+// the store error if any. stored is called once the loaded value
+// is stored unless it is nil
+func (c *Cache) getOrLoadBytes(ctx context.Context, key string, ttl time.Duration, load LoadFunc, stored func(ctx context.Context) error) ([]byte, error) {
+	cached, ttlLeft, err := c.getBytesTTL(ctx, key)
+	if err == nil && !c.refreshEarly(ttlLeft) {
+		return cached, nil
+	}
+
+	if err != nil && err != ErrCacheMiss {
+		return nil, err
+	}
+
+	b, storeErr := c.flights.do(ctx, key, func() ([]byte, error) {
+		timeout := c.LoadTimeout
+		if timeout <= 0 {
+			timeout = DefaultLoadTimeout
+		}
+
+		loadCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+		defer cancel()
+
+		b, err := c.load(loadCtx, key, ttl, load)
+		if b != nil && err == nil && stored != nil {
+			err = stored(loadCtx)
+		}
+		return b, err
+	})
+	if b == nil && cached != nil {
+		return cached, nil
+	}
+
+	return b, storeErr
+}
+
+// load calls load func and stores the serialized
+// value under the key. The serialized value is
+// returned along with the store error if any
+func (c *Cache) load(ctx context.Context, key string, ttl time.Duration, load LoadFunc) ([]byte, error) {
+	value, err := load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := c.codec.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return b, c.setBytes(ctx, key, b, ttl)
+}
+
+// getBytes returns the raw key value
+func (c *Cache) getBytes(ctx context.Context, key string) ([]byte, error) {
+	redisConn, err := ConnectContext(ctx, c.connector)
+	if err != nil {
+		return nil, err
+	}
+	defer redisConn.Close()
+
+	b, err := redis.Bytes(redisConn.Do("GET", key))
+	if err == redis.ErrNil {
+		return nil, ErrCacheMiss
+	}
+
+	return b, err
+}
+
+// getBytesTTL returns the raw key value and its
+// remaining time to live. TTL is requested in the same
+// round-trip only if early refresh is enabled
+func (c *Cache) getBytesTTL(ctx context.Context, key string) ([]byte, time.Duration, error) {
+	if c.EarlyRefresh <= 0 {
+		b, err := c.getBytes(ctx, key)
+		return b, -1, err
+	}
+
+	redisConn, err := ConnectContext(ctx, c.connector)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer redisConn.Close()
+
+	redisConn.Send("GET", key)
+	redisConn.Send("PTTL", key)
+	if err := redisConn.Flush(); err != nil {
+		return nil, 0, err
+	}
+
+	b, err := redis.Bytes(redisConn.Receive())
+	if err == redis.ErrNil {
+		// PTTL reply is still to be read
+		redisConn.Receive()
+		return nil, 0, ErrCacheMiss
+	}
+
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ms, err := redis.Int64(redisConn.Receive())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if ms < 0 {
+		return b, -1, nil
+	}
+
+	return b, time.Duration(ms) * time.Millisecond, nil
+}
+
+// refreshEarly reports whether a key with ttlLeft time to live
+// should be reloaded ahead of its expiry.
+// The probability grows exponentially as the key approaches expiry:
+// EarlyRefresh * beta * -ln(rand()) >= ttlLeft
+func (c *Cache) refreshEarly(ttlLeft time.Duration) bool {
+	if c.EarlyRefresh <= 0 || ttlLeft < 0 {
+		return false
+	}
+
+	beta := c.EarlyRefreshBeta
+	if beta <= 0 {
+		beta = 1
+	}
+
+	return float64(c.EarlyRefresh)*beta*-math.Log(rand.Float64()) >= float64(ttlLeft)
+}
+
+// setBytes stores the raw key value
+func (c *Cache) setBytes(ctx context.Context, key string, b []byte, ttl time.Duration) error {
+	redisConn, err := ConnectContext(ctx, c.connector)
+	if err != nil {
+		return err
+	}
+	defer redisConn.Close()
+
+	args := redis.Args{key, b}
+	if ttl > 0 {
+		// redis rejects PX 0, shorter ttls are rounded up to 1ms
+		px := int64(ttl / time.Millisecond)
+		if px == 0 {
+			px = 1
+		}
+		args = args.Add("PX", px)
+	}
+
+	_, err = redisConn.Do("SET", args...)
+	return err
+}
+
+// do calls fn for the key in the background unless a call
+// for the key is already in flight and awaits the call results.
+// The caller stops waiting once ctx is done, the call itself
+// is not canceled. A panic in fn is returned as an error
+func (g *flightGroup) do(ctx context.Context, key string, fn func() ([]byte, error)) ([]byte, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flightCall)
+	}
+
+	call, ok := g.calls[key]
+	if !ok {
+		call = &flightCall{done: make(chan struct{})}
+		g.calls[key] = call
+		go g.call(key, call, fn)
+	}
+	g.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.b, call.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// call calls fn and releases the call waiters
+func (g *flightGroup) call(key string, call *flightCall, fn func() ([]byte, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			call.b, call.err = nil, fmt.Errorf("redis: cache load panic: %v", r)
+		}
+
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(call.done)
+	}()
+
+	call.b, call.err = fn()
+}
+
+// GetAs returns the cached key value of type T.
+// ErrCacheMiss is returned if the key is not found
+func GetAs[T any](ctx context.Context, c *Cache, key string) (T, error) {
+	var v T
+	err := c.Get(ctx, key, &v)
+	return v, err
+}
+
+// GetOrLoadAs returns the cached key value of type T.
+// On cache miss the value is retrieved with load func
+// and stored under the key with ttl
+func GetOrLoadAs[T any](ctx context.Context, c *Cache, key string, ttl time.Duration, load func(ctx context.Context) (T, error)) (T, error) {
+	var v T
+	err := c.GetOrLoad(ctx, key, &v, ttl, func(ctx context.Context) (interface{}, error) {
+		return load(ctx)
+	})
+	return v, err
+}
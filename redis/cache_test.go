@@ -0,0 +1,271 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type CacheTestSuite struct{}
+
+var _ = gc.Suite(&CacheTestSuite{})
+
+// memStore is a minimal map backed
+// key/value storage for cache tests
+type memStore struct {
+	mu        sync.Mutex
+	data      map[string][]byte
+	ttls      map[string]time.Duration
+	published []string
+	// publish is called with the published messages if set
+	publish func(channel, message string)
+}
+
+// newMemConnector returns a connector with
+// a single pool backed by the memStore
+func newMemConnector() (*RedisConnector, *memStore) {
+	store := &memStore{data: map[string][]byte{}, ttls: map[string]time.Duration{}}
+	return newStubConnector(store.do), store
+}
+
+func (m *memStore) do(cmd string, args ...interface{}) (interface{}, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch cmd {
+	case "GET":
+		if b, ok := m.data[args[0].(string)]; ok {
+			return b, nil
+		}
+		return nil, nil
+	case "SET":
+		key := args[0].(string)
+		m.data[key] = args[1].([]byte)
+		if len(args) == 4 {
+			m.ttls[key] = time.Duration(args[3].(int64)) * time.Millisecond
+		}
+		return "OK", nil
+	case "PTTL":
+		if ttl, ok := m.ttls[args[0].(string)]; ok {
+			return int64(ttl / time.Millisecond), nil
+		}
+		return int64(-1), nil
+	case "DEL":
+		for _, key := range args {
+			delete(m.data, key.(string))
+		}
+		return int64(len(args)), nil
+	case "PUBLISH":
+		m.published = append(m.published, args[0].(string)+" "+args[1].(string))
+		if m.publish != nil {
+			m.publish(args[0].(string), args[1].(string))
+		}
+		return int64(1), nil
+	}
+	return nil, redis.Error("ERR unknown command")
+}
+
+type cachedScore struct {
+	Home int
+	Away int
+}
+
+func (s *CacheTestSuite) TestSetGetDelete(c *gc.C) {
+	ctx := context.Background()
+	connector, store := newMemConnector()
+	cache := NewCache(connector, nil)
+
+	c.Assert(cache.Set(ctx, "match:1", cachedScore{2, 1}, time.Minute), gc.IsNil)
+	c.Check(string(store.data["match:1"]), gc.Equals, `{"Home":2,"Away":1}`)
+	c.Check(store.ttls["match:1"], gc.Equals, time.Minute)
+
+	var score cachedScore
+	c.Assert(cache.Get(ctx, "match:1", &score), gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{2, 1})
+
+	c.Assert(cache.Delete(ctx, "match:1"), gc.IsNil)
+	c.Check(cache.Get(ctx, "match:1", &score), gc.Equals, ErrCacheMiss)
+}
+
+func (s *CacheTestSuite) TestSetRoundsUpShortTTL(c *gc.C) {
+	connector, store := newMemConnector()
+	cache := NewCache(connector, nil)
+
+	c.Assert(cache.Set(context.Background(), "match:1", cachedScore{2, 1}, time.Microsecond), gc.IsNil)
+	c.Check(store.ttls["match:1"], gc.Equals, time.Millisecond)
+}
+
+func (s *CacheTestSuite) TestGetOrLoad(c *gc.C) {
+	ctx := context.Background()
+	connector, _ := newMemConnector()
+	cache := NewCache(connector, GobCodec{})
+
+	loads := 0
+	load := func(context.Context) (cachedScore, error) {
+		loads++
+		return cachedScore{3, 0}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		score, err := GetOrLoadAs(ctx, cache, "match:2", time.Minute, load)
+		c.Assert(err, gc.IsNil)
+		c.Check(score, gc.Equals, cachedScore{3, 0})
+	}
+	c.Check(loads, gc.Equals, 1)
+
+	score, err := GetAs[cachedScore](ctx, cache, "match:2")
+	c.Check(err, gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{3, 0})
+}
+
+func (s *CacheTestSuite) TestGetOrLoadError(c *gc.C) {
+	expectedErr := errors.New("test error")
+	connector, store := newMemConnector()
+	cache := NewCache(connector, nil)
+
+	var score cachedScore
+	err := cache.GetOrLoad(context.Background(), "match:3", &score, time.Minute, func(context.Context) (interface{}, error) {
+		return nil, expectedErr
+	})
+	c.Check(err, gc.Equals, expectedErr)
+	c.Check(store.data, gc.HasLen, 0)
+}
+
+func (s *CacheTestSuite) TestGetOrLoadDeduplicatesConcurrentLoads(c *gc.C) {
+	connector, _ := newMemConnector()
+	cache := NewCache(connector, nil)
+
+	var loads int32
+	load := func(context.Context) (interface{}, error) {
+		atomic.AddInt32(&loads, 1)
+		time.Sleep(50 * time.Millisecond)
+		return cachedScore{1, 1}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var score cachedScore
+			c.Check(cache.GetOrLoad(context.Background(), "match:4", &score, time.Minute, load), gc.IsNil)
+			c.Check(score, gc.Equals, cachedScore{1, 1})
+		}()
+	}
+	wg.Wait()
+
+	c.Check(atomic.LoadInt32(&loads), gc.Equals, int32(1))
+}
+
+func (s *CacheTestSuite) TestGetOrLoadCanceledCaller(c *gc.C) {
+	connector, _ := newMemConnector()
+	cache := NewCache(connector, nil)
+
+	loaded := make(chan error, 1)
+	release := make(chan struct{})
+	load := func(ctx context.Context) (interface{}, error) {
+		<-release
+		loaded <- ctx.Err()
+		return cachedScore{2, 0}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		var score cachedScore
+		done <- cache.GetOrLoad(ctx, "match:5", &score, time.Minute, load)
+	}()
+
+	// wait for the first caller to start the load
+	time.Sleep(10 * time.Millisecond)
+
+	var score cachedScore
+	go func() {
+		cancel()
+		c.Check(<-done, gc.Equals, context.Canceled)
+		close(release)
+	}()
+	c.Check(cache.GetOrLoad(context.Background(), "match:5", &score, time.Minute, load), gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{2, 0})
+	c.Check(<-loaded, gc.IsNil)
+}
+
+func (s *CacheTestSuite) TestGetOrLoadPanic(c *gc.C) {
+	connector, _ := newMemConnector()
+	cache := NewCache(connector, nil)
+
+	release := make(chan struct{})
+	load := func(context.Context) (interface{}, error) {
+		<-release
+		panic("test panic")
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			var score cachedScore
+			err := cache.GetOrLoad(context.Background(), "match:6", &score, time.Minute, load)
+			c.Check(err, gc.ErrorMatches, "redis: cache load panic: test panic")
+		}()
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+}
+
+func (s *CacheTestSuite) TestGetOrLoadEarlyRefresh(c *gc.C) {
+	ctx := context.Background()
+	connector, store := newMemConnector()
+	cache := NewCache(connector, nil)
+	c.Assert(cache.Set(ctx, "match:5", cachedScore{0, 0}, time.Millisecond), gc.IsNil)
+
+	load := func(context.Context) (cachedScore, error) {
+		return cachedScore{1, 0}, nil
+	}
+
+	// early refresh disabled: the cached value is returned
+	score, err := GetOrLoadAs(ctx, cache, "match:5", time.Millisecond, load)
+	c.Check(err, gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{0, 0})
+
+	// the key is 1ms away from expiry while a load takes an hour
+	cache.EarlyRefresh = time.Hour
+	score, err = GetOrLoadAs(ctx, cache, "match:5", time.Minute, load)
+	c.Check(err, gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{1, 0})
+	c.Check(store.ttls["match:5"], gc.Equals, time.Minute)
+}
+
+func (s *CacheTestSuite) TestGetOrLoadEarlyRefreshFallback(c *gc.C) {
+	ctx := context.Background()
+	connector, _ := newMemConnector()
+	cache := NewCache(connector, nil)
+	cache.EarlyRefresh = time.Hour
+	c.Assert(cache.Set(ctx, "match:6", cachedScore{2, 2}, time.Millisecond), gc.IsNil)
+
+	var score cachedScore
+	err := cache.GetOrLoad(ctx, "match:6", &score, time.Minute, func(context.Context) (interface{}, error) {
+		return nil, errors.New("test error")
+	})
+	c.Check(err, gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{2, 2})
+}
+
+func (s *CacheTestSuite) TestRefreshEarly(c *gc.C) {
+	cache := &Cache{}
+	c.Check(cache.refreshEarly(time.Millisecond), gc.Equals, false)
+
+	cache.EarlyRefresh = time.Millisecond
+	c.Check(cache.refreshEarly(-1), gc.Equals, false)
+	c.Check(cache.refreshEarly(time.Hour), gc.Equals, false)
+}
@@ -0,0 +1,720 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// ClusterSlots holds the number of hash slots
+// a redis cluster key space is divided into
+const ClusterSlots = 16384
+
+// maxRedirects holds the max number of MOVED/ASK
+// redirects followed for a single command
+const maxRedirects = 5
+
+// ErrClusterTopology is returned when cluster slots
+// could not be discovered from any of the known nodes
+var ErrClusterTopology = errors.New("redis: cluster topology is not available")
+
+// ErrClusterTransaction is returned by cluster connections
+// for MULTI, EXEC, DISCARD, WATCH and UNWATCH commands
+// as every command may be executed on a different node
+// connection, use ConnectKey for transactions
+var ErrClusterTransaction = errors.New("redis: transactions are not supported by cluster connections, use ConnectKey")
+
+type (
+	// ClusterConnector is a struct type
+	// which implements a Connector interface
+	// for a redis cluster.
+	//
+	// ClusterConnector discovers slots distribution
+	// with CLUSTER SLOTS command, keeps a pool per cluster
+	// master node and routes commands to the node
+	// owning the command key hash slot
+	ClusterConnector struct {
+		// template holds node pools configuration,
+		// Host and Port are overwritten per node
+		template RedisConfig
+		seeds    []string
+
+		mu     sync.RWMutex
+		pools  map[string]*connPool
+		slots  [ClusterSlots]string
+		loaded bool
+
+		refreshing int32
+		closed     int32
+	}
+
+	// clusterConn is a redis.ConnWithTimeout implementation
+	// which routes every command to the node owning
+	// the command key.
+	//
+	// Pipelined commands (Send/Flush/Receive) are executed
+	// one by one on Flush, as the commands may target
+	// different nodes. Transactions are rejected
+	// with ErrClusterTransaction
+	clusterConn struct {
+		c       *ClusterConnector
+		pending [][]interface{}
+		replies []clusterReply
+	}
+
+	// clusterReply holds a buffered pipeline reply
+	clusterReply struct {
+		reply interface{}
+		err   error
+	}
+)
+
+// NewClusterConnector inits and returns a pointer to ClusterConnector instance.
+// cfgs holds the seed nodes; the first config is used as a template
+// for node pools (password, timeouts and pool sizes).
+// Cluster topology is discovered on init
+func NewClusterConnector(cfgs []RedisConfig) (*ClusterConnector, error) {
+	if len(cfgs) == 0 {
+		return nil, errors.New("invalid argument: cfgs")
+	}
+
+	c := &ClusterConnector{
+		template: cfgs[0],
+		pools:    make(map[string]*connPool, len(cfgs)),
+	}
+
+	for _, cfg := range cfgs {
+		c.seeds = append(c.seeds, cfg.Address())
+	}
+
+	if err := c.Refresh(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Connect returns a cluster connection which
+// routes commands by the key hash slot.
+// Pool connections are retrieved per command,
+// errors are returned on the connection usage
+func (c *ClusterConnector) Connect() redis.Conn {
+	return &clusterConn{c: c}
+}
+
+// ConnectContext returns a cluster connection
+// which routes commands by the key hash slot.
+// ErrClusterTopology is returned if no slots are known
+func (c *ClusterConnector) ConnectContext(ctx context.Context) (redis.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return nil, ErrConnectorClosed
+	}
+
+	c.mu.RLock()
+	loaded := c.loaded
+	c.mu.RUnlock()
+
+	if !loaded {
+		return nil, ErrClusterTopology
+	}
+
+	return c.Connect(), nil
+}
+
+// PingConnect returns a cluster connection.
+// Second arguments is an error generated by a "PING"
+// method sent to one of the cluster nodes
+func (c *ClusterConnector) PingConnect() (redis.Conn, error) {
+	clusterConn := c.Connect()
+	_, err := clusterConn.Do("PING")
+	return clusterConn, err
+}
+
+// ConnectKey returns a pool connection to the node
+// owning the key hash slot. The connection can be used
+// for pipelines and transactions on keys sharing the slot
+func (c *ClusterConnector) ConnectKey(key string) redis.Conn {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return errorConn{ErrConnectorClosed}
+	}
+
+	pool, err := c.nodePool(c.slotAddr(HashSlot(key)))
+	if err != nil {
+		return errorConn{err}
+	}
+
+	redisConn, _ := pool.borrow(context.Background())
+	return redisConn
+}
+
+// EachPool calls fn with a connection retrieved
+// from every known cluster node pool.
+// All the pools are visited, the first error is returned
+func (c *ClusterConnector) EachPool(ctx context.Context, fn func(redis.Conn) error) error {
+	return eachPool(ctx, c.nodePools(), fn)
+}
+
+// Refresh reloads the cluster slots distribution
+// with CLUSTER SLOTS command sent to known nodes and seeds
+// one by one until the first successful reply.
+// ErrConnectorClosed is returned once the connector is closed
+func (c *ClusterConnector) Refresh() error {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return ErrConnectorClosed
+	}
+
+	c.mu.RLock()
+	addrs := make([]string, 0, len(c.pools)+len(c.seeds))
+	for addr := range c.pools {
+		addrs = append(addrs, addr)
+	}
+	c.mu.RUnlock()
+	addrs = append(addrs, c.seeds...)
+
+	lastErr := ErrClusterTopology
+	for _, addr := range addrs {
+		slots, err := c.loadSlots(addr)
+		if err == ErrConnectorClosed {
+			return err
+		}
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c.mu.Lock()
+		if atomic.LoadInt32(&c.closed) == 1 {
+			c.mu.Unlock()
+			return ErrConnectorClosed
+		}
+		c.slots = slots
+		c.loaded = true
+		removed := c.removeStalePools()
+		c.mu.Unlock()
+
+		closePools(removed)
+		return nil
+	}
+
+	return lastErr
+}
+
+// loadSlots requests slots distribution from the node
+func (c *ClusterConnector) loadSlots(addr string) (slots [ClusterSlots]string, err error) {
+	pool, err := c.nodePool(addr)
+	if err != nil {
+		return slots, err
+	}
+
+	conn, err := pool.borrow(context.Background())
+	if err != nil {
+		return slots, err
+	}
+	defer conn.Close()
+
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return slots, err
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	return parseClusterSlots(ranges, host)
+}
+
+// refreshAsync starts topology refresh in the background
+// unless one is already in progress
+func (c *ClusterConnector) refreshAsync() {
+	if !atomic.CompareAndSwapInt32(&c.refreshing, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&c.refreshing, 0)
+		c.Refresh()
+	}()
+}
+
+// slotAddr returns the address of the node owning the slot;
+// any known node address is returned for unknown slots
+func (c *ClusterConnector) slotAddr(slot int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if addr := c.slots[slot]; addr != "" {
+		return addr
+	}
+
+	for addr := range c.pools {
+		return addr
+	}
+
+	return c.seeds[0]
+}
+
+// setSlotAddr updates the node owning the slot
+func (c *ClusterConnector) setSlotAddr(slot int, addr string) {
+	c.mu.Lock()
+	c.slots[slot] = addr
+	c.mu.Unlock()
+}
+
+// removeStalePools removes and returns the pools of
+// the nodes which left the cluster topology, seed node
+// pools are kept. The caller holds the write lock
+func (c *ClusterConnector) removeStalePools() []*connPool {
+	known := make(map[string]bool, len(c.seeds))
+	for _, addr := range c.seeds {
+		known[addr] = true
+	}
+	for _, addr := range c.slots {
+		known[addr] = true
+	}
+
+	var removed []*connPool
+	for addr, pool := range c.pools {
+		if !known[addr] {
+			removed = append(removed, pool)
+			delete(c.pools, addr)
+		}
+	}
+	return removed
+}
+
+// nodePool returns the pool for the node address,
+// the pool is created on first use. ErrConnectorClosed
+// is returned once the connector is closed, the closed
+// state is checked under the lock, so Close either sees
+// the created pool or no pool is created
+func (c *ClusterConnector) nodePool(addr string) (*connPool, error) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return nil, ErrConnectorClosed
+	}
+
+	c.mu.RLock()
+	pool, ok := c.pools[addr]
+	c.mu.RUnlock()
+
+	if ok {
+		return pool, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return nil, ErrConnectorClosed
+	}
+
+	if pool, ok := c.pools[addr]; ok {
+		return pool, nil
+	}
+
+	cfg := c.template
+	cfg.DB = 0
+	host, port, _ := net.SplitHostPort(addr)
+	cfg.Host = host
+	cfg.Port, _ = strconv.Atoi(port)
+
+	pool = newPool(cfg)
+	c.pools[addr] = pool
+
+	return pool, nil
+}
+
+// do sends the command to the node owning the command key
+// and follows MOVED/ASK redirects
+func (c *ClusterConnector) do(cmd string, args ...interface{}) (interface{}, error) {
+	return c.doWithTimeout(0, cmd, args...)
+}
+
+// doWithTimeout sends the command with the read timeout,
+// the node connection one is used if timeout is zero
+func (c *ClusterConnector) doWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if atomic.LoadInt32(&c.closed) == 1 {
+		return nil, ErrConnectorClosed
+	}
+
+	addr := c.slotAddr(commandSlot(cmd, args))
+	asking := false
+
+	for i := 0; ; i++ {
+		reply, err := c.doNode(addr, asking, timeout, cmd, args...)
+		if err == nil {
+			return reply, nil
+		}
+
+		redirect, ok := err.(redis.Error)
+		if !ok {
+			// network errors might be caused by a failover
+			if err != ErrConnectorClosed {
+				c.refreshAsync()
+			}
+			return reply, err
+		}
+
+		if i == maxRedirects {
+			return reply, err
+		}
+
+		kind, slot, target, ok := parseRedirect(redirect)
+		if !ok {
+			return reply, err
+		}
+
+		addr = target
+		asking = kind == "ASK"
+
+		if kind == "MOVED" {
+			c.setSlotAddr(slot, target)
+			c.refreshAsync()
+		}
+	}
+}
+
+// doNode executes the command on the node,
+// ASKING command is sent first for ASK redirects
+func (c *ClusterConnector) doNode(addr string, asking bool, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	pool, err := c.nodePool(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := pool.borrow(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if asking {
+		if _, err := conn.Do("ASKING"); err != nil {
+			return nil, err
+		}
+	}
+
+	if timeout > 0 {
+		return redis.DoWithTimeout(conn, timeout, cmd, args...)
+	}
+	return conn.Do(cmd, args...)
+}
+
+// Do executes the command on the node owning the command key
+func (cc *clusterConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	return cc.DoWithTimeout(0, cmd, args...)
+}
+
+// DoWithTimeout executes the command on the node owning the command
+// key with the read timeout, e.g. for blocking commands.
+// The node connection timeout is used if timeout is zero.
+//
+// As redigo connections, the pending pipeline replies are drained:
+// empty cmd returns them, otherwise the first pending error is
+// returned along with the command reply
+func (cc *clusterConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "" && isTransactionCmd(cmd) {
+		return nil, ErrClusterTransaction
+	}
+
+	cc.Flush()
+	pending := cc.replies
+	cc.replies = nil
+
+	if cmd == "" {
+		values := make([]interface{}, len(pending))
+		for i, r := range pending {
+			values[i] = r.reply
+			if r.err != nil {
+				values[i] = r.err
+			}
+		}
+		return values, nil
+	}
+
+	reply, err := cc.c.doWithTimeout(timeout, cmd, args...)
+	for _, r := range pending {
+		if r.err != nil {
+			return reply, r.err
+		}
+	}
+
+	return reply, err
+}
+
+// Send buffers the command until Flush is called
+func (cc *clusterConn) Send(cmd string, args ...interface{}) error {
+	if isTransactionCmd(cmd) {
+		return ErrClusterTransaction
+	}
+
+	cc.pending = append(cc.pending, append([]interface{}{cmd}, args...))
+	return nil
+}
+
+// Flush executes buffered commands and keeps
+// their replies for Receive
+func (cc *clusterConn) Flush() error {
+	for _, p := range cc.pending {
+		reply, err := cc.c.do(p[0].(string), p[1:]...)
+		cc.replies = append(cc.replies, clusterReply{reply, err})
+	}
+	cc.pending = nil
+
+	return nil
+}
+
+// Receive returns the next buffered pipeline reply
+func (cc *clusterConn) Receive() (interface{}, error) {
+	if len(cc.replies) == 0 {
+		return nil, errors.New("redis: no pending cluster replies")
+	}
+
+	r := cc.replies[0]
+	cc.replies = cc.replies[1:]
+	return r.reply, r.err
+}
+
+// ReceiveWithTimeout returns the next buffered pipeline reply,
+// the replies are read on Flush so the timeout is not used
+func (cc *clusterConn) ReceiveWithTimeout(time.Duration) (interface{}, error) {
+	return cc.Receive()
+}
+
+// Err always returns nil: node connections are
+// retrieved per command
+func (cc *clusterConn) Err() error {
+	return nil
+}
+
+// Close drops buffered commands and replies
+func (cc *clusterConn) Close() error {
+	cc.pending, cc.replies = nil, nil
+	return nil
+}
+
+// isTransactionCmd reports whether
+// the command is a transaction one
+func isTransactionCmd(cmd string) bool {
+	switch strings.ToUpper(cmd) {
+	case "MULTI", "EXEC", "DISCARD", "WATCH", "UNWATCH":
+		return true
+	}
+	return false
+}
+
+// clusterKeyless holds the commands without key
+// arguments, they are executed on the slot 0 node
+var clusterKeyless = map[string]bool{
+	"ACL": true, "AUTH": true, "BGREWRITEAOF": true, "BGSAVE": true,
+	"CLIENT": true, "CLUSTER": true, "COMMAND": true, "CONFIG": true,
+	"DBSIZE": true, "ECHO": true, "FLUSHALL": true, "FLUSHDB": true,
+	"FUNCTION": true, "HELLO": true, "INFO": true, "KEYS": true,
+	"LASTSAVE": true, "LATENCY": true, "PING": true, "PUBLISH": true,
+	"PUBSUB": true, "RANDOMKEY": true, "READONLY": true, "READWRITE": true,
+	"ROLE": true, "SAVE": true, "SCAN": true, "SCRIPT": true,
+	"SELECT": true, "SLOWLOG": true, "TIME": true, "WAIT": true,
+}
+
+// clusterKeyIndex holds the first key position funcs
+// of the commands whose first argument is not a key,
+// a negative position means the command has no keys
+var clusterKeyIndex = map[string]func(args []interface{}) int{
+	"BITOP":      keyIndexAt(1),
+	"MEMORY":     keyIndexAt(1),
+	"OBJECT":     keyIndexAt(1),
+	"XGROUP":     keyIndexAt(1),
+	"XINFO":      keyIndexAt(1),
+	"EVAL":       numKeysIndex(1),
+	"EVALSHA":    numKeysIndex(1),
+	"EVAL_RO":    numKeysIndex(1),
+	"EVALSHA_RO": numKeysIndex(1),
+	"FCALL":      numKeysIndex(1),
+	"FCALL_RO":   numKeysIndex(1),
+	"BLMPOP":     numKeysIndex(1),
+	"BZMPOP":     numKeysIndex(1),
+	"LMPOP":      numKeysIndex(0),
+	"ZMPOP":      numKeysIndex(0),
+	"SINTERCARD": numKeysIndex(0),
+	"ZDIFF":      numKeysIndex(0),
+	"ZINTER":     numKeysIndex(0),
+	"ZINTERCARD": numKeysIndex(0),
+	"ZUNION":     numKeysIndex(0),
+	"XREAD":      streamsKeyIndex,
+	"XREADGROUP": streamsKeyIndex,
+}
+
+// commandSlot returns the hash slot of the command first key.
+// Keyless commands are mapped to slot 0, the first argument
+// is used as the key of the other commands not found
+// in the clusterKeyIndex table
+func commandSlot(cmd string, args []interface{}) int {
+	name := strings.ToUpper(cmd)
+	if clusterKeyless[name] {
+		return 0
+	}
+
+	idx := 0
+	if keyIndex, ok := clusterKeyIndex[name]; ok {
+		idx = keyIndex(args)
+	}
+
+	if idx < 0 || len(args) <= idx {
+		return 0
+	}
+
+	key, err := redis.String(args[idx], nil)
+	if err != nil {
+		key = fmt.Sprint(args[idx])
+	}
+
+	return HashSlot(key)
+}
+
+// keyIndexAt returns a key position func
+// of the commands with the first key at i
+func keyIndexAt(i int) func([]interface{}) int {
+	return func([]interface{}) int {
+		return i
+	}
+}
+
+// numKeysIndex returns a key position func of the commands
+// with the number of keys at i followed by the keys
+func numKeysIndex(i int) func([]interface{}) int {
+	return func(args []interface{}) int {
+		if i >= len(args) {
+			return -1
+		}
+
+		s, err := redis.String(args[i], nil)
+		if err != nil {
+			s = fmt.Sprint(args[i])
+		}
+
+		if n, _ := strconv.Atoi(s); n <= 0 {
+			return -1
+		}
+		return i + 1
+	}
+}
+
+// streamsKeyIndex is a key position func of the
+// commands with the keys following the STREAMS option
+func streamsKeyIndex(args []interface{}) int {
+	for i, arg := range args {
+		if s, err := redis.String(arg, nil); err == nil && strings.EqualFold(s, "STREAMS") {
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// HashSlot returns the cluster hash slot of the key.
+// Only the {hash tag} part is hashed if the key has one
+func HashSlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+
+	return int(crc16(key) % ClusterSlots)
+}
+
+// crc16 implements CRC16-CCITT (XMODEM) checksum
+// used by redis cluster for keys hashing
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// parseRedirect parses MOVED/ASK error replies
+// of the "MOVED 3999 127.0.0.1:6381" format
+func parseRedirect(err redis.Error) (kind string, slot int, addr string, ok bool) {
+	parts := strings.Fields(err.Error())
+	if len(parts) != 3 || (parts[0] != "MOVED" && parts[0] != "ASK") {
+		return "", 0, "", false
+	}
+
+	slot, convErr := strconv.Atoi(parts[1])
+	if convErr != nil || slot < 0 || slot >= ClusterSlots {
+		return "", 0, "", false
+	}
+
+	return parts[0], slot, parts[2], true
+}
+
+// parseClusterSlots maps CLUSTER SLOTS reply to the slot owners
+// addresses. Empty node ip is replaced by the queried node host
+func parseClusterSlots(ranges []interface{}, defaultHost string) (slots [ClusterSlots]string, err error) {
+	for _, r := range ranges {
+		values, err := redis.Values(r, nil)
+		if err != nil {
+			return slots, err
+		}
+
+		if len(values) < 3 {
+			return slots, errors.New("redis: invalid CLUSTER SLOTS reply")
+		}
+
+		start, err := redis.Int(values[0], nil)
+		if err != nil {
+			return slots, err
+		}
+
+		end, err := redis.Int(values[1], nil)
+		if err != nil {
+			return slots, err
+		}
+
+		master, err := redis.Values(values[2], nil)
+		if err != nil || len(master) < 2 {
+			return slots, errors.New("redis: invalid CLUSTER SLOTS node")
+		}
+
+		host, err := redis.String(master[0], nil)
+		if err != nil {
+			return slots, err
+		}
+
+		if host == "" {
+			host = defaultHost
+		}
+
+		port, err := redis.Int(master[1], nil)
+		if err != nil {
+			return slots, err
+		}
+
+		if start < 0 || end >= ClusterSlots || start > end {
+			return slots, fmt.Errorf("redis: invalid slots range %d-%d", start, end)
+		}
+
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = addr
+		}
+	}
+
+	return slots, nil
+}
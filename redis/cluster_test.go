@@ -0,0 +1,242 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type ClusterTestSuite struct{}
+
+var _ = gc.Suite(&ClusterTestSuite{})
+
+func (s *ClusterTestSuite) TestHashSlot(c *gc.C) {
+	c.Check(HashSlot("123456789"), gc.Equals, 12739)
+	c.Check(HashSlot("foo"), gc.Equals, 12182)
+	c.Check(HashSlot("{user1000}.following"), gc.Equals, HashSlot("user1000"))
+	c.Check(HashSlot("{user1000}.followers"), gc.Equals, HashSlot("{user1000}.following"))
+	c.Check(HashSlot("foo{}{bar}") == HashSlot("bar"), gc.Equals, false)
+}
+
+func (s *ClusterTestSuite) TestCommandSlot(c *gc.C) {
+	c.Check(commandSlot("GET", []interface{}{"foo"}), gc.Equals, 12182)
+	c.Check(commandSlot("EVALSHA", []interface{}{"sha", 1, "foo"}), gc.Equals, 12182)
+	c.Check(commandSlot("evalsha", []interface{}{"sha", 0, "foo"}), gc.Equals, 0)
+	c.Check(commandSlot("XREADGROUP", []interface{}{"GROUP", "g", "c", "STREAMS", "foo", ">"}), gc.Equals, 12182)
+	c.Check(commandSlot("XREAD", []interface{}{"BLOCK", 10, "STREAMS", "foo", "$"}), gc.Equals, 12182)
+	c.Check(commandSlot("XGROUP", []interface{}{"CREATE", "foo", "g", "$"}), gc.Equals, 12182)
+	c.Check(commandSlot("ZUNIONSTORE", []interface{}{"foo", 1, "bar"}), gc.Equals, 12182)
+	c.Check(commandSlot("ZUNION", []interface{}{"1", "foo"}), gc.Equals, 12182)
+	c.Check(commandSlot("BLMPOP", []interface{}{0, 1, "foo", "LEFT"}), gc.Equals, 12182)
+	c.Check(commandSlot("BITOP", []interface{}{"AND", "foo", "bar"}), gc.Equals, 12182)
+	c.Check(commandSlot("PING", nil), gc.Equals, 0)
+	c.Check(commandSlot("PUBLISH", []interface{}{"foo", "msg"}), gc.Equals, 0)
+	c.Check(commandSlot("UNKNOWN", []interface{}{"foo"}), gc.Equals, 12182)
+}
+
+// clusterSlotsReply assigns the slots
+// 0-8191 to node a and 8192-16383 to node b
+func clusterSlotsReply() []interface{} {
+	return []interface{}{
+		[]interface{}{int64(0), int64(8191), []interface{}{[]byte("a"), int64(7000)}},
+		[]interface{}{int64(8192), int64(16383), []interface{}{[]byte("b"), int64(7001)}},
+	}
+}
+
+// newStubCluster returns a cluster connector
+// of stub nodes with all the slots assigned to seed
+func newStubCluster(seed string, nodes map[string]*recordStub) *ClusterConnector {
+	c := &ClusterConnector{seeds: []string{seed}, pools: make(map[string]*connPool)}
+	for addr, stub := range nodes {
+		c.pools[addr] = newStubPool(stub.do)
+	}
+	for slot := range c.slots {
+		c.slots[slot] = seed
+	}
+	c.loaded = true
+	return c
+}
+
+func (s *ClusterTestSuite) TestDoFollowsRedirects(c *gc.C) {
+	a := &recordStub{reply: func(cmd string, args ...interface{}) (interface{}, error) {
+		switch cmd {
+		case "CLUSTER":
+			return clusterSlotsReply(), nil
+		case "GET":
+			return nil, redis.Error("MOVED 12182 b:7001")
+		case "SET":
+			return nil, redis.Error("ASK 5061 b:7001")
+		}
+		return nil, redis.Error("MOVED 0 a:7000")
+	}}
+	b := &recordStub{reply: func(cmd string, args ...interface{}) (interface{}, error) {
+		if cmd == "CLUSTER" {
+			return clusterSlotsReply(), nil
+		}
+		return "OK", nil
+	}}
+	cluster := newStubCluster("a:7000", map[string]*recordStub{"a:7000": a, "b:7001": b})
+
+	reply, err := cluster.do("GET", "foo")
+	c.Assert(err, gc.IsNil)
+	c.Check(reply, gc.Equals, "OK")
+	c.Check(cluster.slotAddr(12182), gc.Equals, "b:7001")
+
+	// ASK redirects do not update the slots
+	reply, err = cluster.do("SET", "bar", "1")
+	c.Assert(err, gc.IsNil)
+	c.Check(reply, gc.Equals, "OK")
+	c.Check(cluster.slotAddr(5061), gc.Equals, "a:7000")
+
+	_, err = cluster.do("INCR", "loop")
+	c.Check(err, gc.ErrorMatches, "MOVED 0 a:7000")
+
+	// blocking commands
+	reply, err = redis.DoWithTimeout(cluster.Connect(), time.Second, "GET", "foo")
+	c.Assert(err, gc.IsNil)
+	c.Check(reply, gc.Equals, "OK")
+
+	c.Check(b.commands(""), gc.DeepEquals, []string{"GET foo", "ASKING", "SET bar 1", "GET foo"})
+	c.Check(a.commands("INCR"), gc.HasLen, maxRedirects+1)
+}
+
+func (s *ClusterTestSuite) TestRefresh(c *gc.C) {
+	failing := &recordStub{reply: func(string, ...interface{}) (interface{}, error) {
+		return nil, redis.Error("CLUSTERDOWN The cluster is down")
+	}}
+	b := &recordStub{reply: func(string, ...interface{}) (interface{}, error) {
+		return clusterSlotsReply(), nil
+	}}
+	cluster := newStubCluster("b:7001", map[string]*recordStub{"a:7000": failing, "b:7001": b})
+	cluster.loaded = false
+
+	c.Assert(cluster.Refresh(), gc.IsNil)
+	c.Check(cluster.slotAddr(0), gc.Equals, "a:7000")
+	c.Check(cluster.slotAddr(8192), gc.Equals, "b:7001")
+
+	_, err := cluster.ConnectContext(context.Background())
+	c.Check(err, gc.IsNil)
+
+	// the pools of the nodes which left the topology are closed
+	gone := newStubPool(b.do)
+	cluster.pools["c:7002"] = gone
+	c.Assert(cluster.Refresh(), gc.IsNil)
+	c.Check(cluster.pools, gc.HasLen, 2)
+	c.Check(cluster.pools["c:7002"], gc.IsNil)
+	_, err = gone.Get().Do("PING")
+	c.Check(err, gc.ErrorMatches, ".* closed pool")
+
+	cluster = newStubCluster("a:7000", map[string]*recordStub{"a:7000": failing})
+	c.Check(cluster.Refresh(), gc.ErrorMatches, "CLUSTERDOWN .*")
+}
+
+func (s *ClusterTestSuite) TestNoPoolsCreatedAfterClose(c *gc.C) {
+	var cluster *ClusterConnector
+	a := &recordStub{reply: func(cmd string, args ...interface{}) (interface{}, error) {
+		// the connector is closed while the command is in flight
+		cluster.Close()
+		if cmd == "CLUSTER" {
+			return clusterSlotsReply(), nil
+		}
+		return nil, redis.Error("MOVED 12182 c:7002")
+	}}
+	cluster = newStubCluster("a:7000", map[string]*recordStub{"a:7000": a})
+
+	// redirect racing with Close
+	_, err := cluster.do("GET", "foo")
+	c.Check(err, gc.Equals, ErrConnectorClosed)
+	c.Check(cluster.pools["c:7002"], gc.IsNil)
+
+	// refresh racing with Close keeps the topology
+	cluster = newStubCluster("a:7000", map[string]*recordStub{"a:7000": a})
+	c.Check(cluster.Refresh(), gc.Equals, ErrConnectorClosed)
+	c.Check(cluster.slotAddr(8192), gc.Equals, "a:7000")
+	c.Check(cluster.pools, gc.HasLen, 1)
+
+	// refresh and redirects after Close
+	cluster = newStubCluster("b:7001", nil)
+	c.Assert(cluster.Close(), gc.IsNil)
+	c.Check(cluster.Refresh(), gc.Equals, ErrConnectorClosed)
+	_, err = cluster.doNode("c:7002", false, 0, "GET", "foo")
+	c.Check(err, gc.Equals, ErrConnectorClosed)
+	c.Check(cluster.ConnectKey("foo").Err(), gc.Equals, ErrConnectorClosed)
+	c.Check(cluster.pools, gc.HasLen, 0)
+}
+
+func (s *ClusterTestSuite) TestRejectsTransactions(c *gc.C) {
+	redisConn := newStubCluster("a:7000", nil).Connect()
+	defer redisConn.Close()
+
+	_, err := redisConn.Do("MULTI")
+	c.Check(err, gc.Equals, ErrClusterTransaction)
+	c.Check(redisConn.Send("watch", "foo"), gc.Equals, ErrClusterTransaction)
+}
+
+func (s *ClusterTestSuite) TestDoDrainsPendingReplies(c *gc.C) {
+	a := &recordStub{reply: func(cmd string, args ...interface{}) (interface{}, error) {
+		if cmd == "INCR" {
+			return nil, redis.Error("ERR value is not an integer")
+		}
+		return "OK", nil
+	}}
+	redisConn := newStubCluster("a:7000", map[string]*recordStub{"a:7000": a}).Connect()
+	defer redisConn.Close()
+
+	redisConn.Send("INCR", "foo")
+	redisConn.Send("SET", "foo", "1")
+	c.Assert(redisConn.Flush(), gc.IsNil)
+
+	reply, err := redisConn.Do("GET", "foo")
+	c.Check(reply, gc.Equals, "OK")
+	c.Check(err, gc.Equals, redis.Error("ERR value is not an integer"))
+
+	// no stale replies are left for Receive
+	_, err = redisConn.Receive()
+	c.Check(err, gc.ErrorMatches, "redis: no pending cluster replies")
+
+	redisConn.Send("SET", "foo", "1")
+	redisConn.Send("INCR", "foo")
+	replies, err := redisConn.Do("")
+	c.Assert(err, gc.IsNil)
+	c.Check(replies, gc.DeepEquals, []interface{}{"OK", redis.Error("ERR value is not an integer")})
+}
+
+func (s *ClusterTestSuite) TestParseRedirect(c *gc.C) {
+	kind, slot, addr, ok := parseRedirect(redis.Error("MOVED 3999 127.0.0.1:6381"))
+	c.Check(ok, gc.Equals, true)
+	c.Check(kind, gc.Equals, "MOVED")
+	c.Check(slot, gc.Equals, 3999)
+	c.Check(addr, gc.Equals, "127.0.0.1:6381")
+
+	kind, _, _, ok = parseRedirect(redis.Error("ASK 3999 127.0.0.1:6381"))
+	c.Check(ok, gc.Equals, true)
+	c.Check(kind, gc.Equals, "ASK")
+
+	_, _, _, ok = parseRedirect(redis.Error("ERR unknown command"))
+	c.Check(ok, gc.Equals, false)
+}
+
+func (s *ClusterTestSuite) TestParseClusterSlots(c *gc.C) {
+	reply := []interface{}{
+		[]interface{}{int64(0), int64(8191), []interface{}{[]byte("10.0.0.1"), int64(7000), []byte("id1")}},
+		[]interface{}{int64(8192), int64(16383), []interface{}{[]byte(""), int64(7001), []byte("id2")}},
+	}
+
+	slots, err := parseClusterSlots(reply, "10.0.0.2")
+	c.Assert(err, gc.IsNil)
+	c.Check(slots[0], gc.Equals, "10.0.0.1:7000")
+	c.Check(slots[8191], gc.Equals, "10.0.0.1:7000")
+	c.Check(slots[8192], gc.Equals, "10.0.0.2:7001")
+	c.Check(slots[16383], gc.Equals, "10.0.0.2:7001")
+}
+
+func (s *ClusterTestSuite) TestParseClusterSlotsInvalidRange(c *gc.C) {
+	reply := []interface{}{
+		[]interface{}{int64(0), int64(16384), []interface{}{[]byte("10.0.0.1"), int64(7000)}},
+	}
+
+	_, err := parseClusterSlots(reply, "")
+	c.Check(err, gc.ErrorMatches, "redis: invalid slots range 0-16384")
+}
@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+)
+
+type (
+	// Codec is an interface type
+	// which describes cached values
+	// serialization logic
+	Codec interface {
+		Marshal(v interface{}) ([]byte, error)
+		Unmarshal(b []byte, v interface{}) error
+	}
+
+	// JSONCodec implements Codec interface
+	// with encoding/json package
+	JSONCodec struct{}
+
+	// GobCodec implements Codec interface
+	// with encoding/gob package
+	GobCodec struct{}
+)
+
+// Marshal returns json encoding of v
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal parses json encoded b and stores the result in v
+func (JSONCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+// Marshal returns gob encoding of v
+func (GobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal parses gob encoded b and stores the result in v
+func (GobCodec) Unmarshal(b []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
@@ -0,0 +1,166 @@
+// Package compat adapts the redis package connectors
+// to the archived github.com/garyburd/redigo/redis types
+// for the callers which are not migrated yet.
+//
+// The redis package connections implement the garyburd
+// redigo Conn interfaces as is, only the error values
+// differ: error replies are github.com/gomodule/redigo/redis.Error
+// and pool errors are the gomodule redigo ones.
+// Connections returned by Connector convert them into
+// the garyburd redigo values, so type assertions and
+// comparisons like err == redis.ErrPoolExhausted keep working:
+//
+//	connector := compat.NewConnector(redisConnector)
+//	conn := connector.Connect()
+//	defer conn.Close()
+//
+// New code should use the redis package
+// connectors and gomodule redigo directly
+package compat
+
+import (
+	"context"
+	"time"
+
+	garyburd "github.com/garyburd/redigo/redis"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/onefootball/samodelkin/redis"
+)
+
+type (
+	// Connector is an interface type
+	// which describes the redis.Connector
+	// methods with garyburd redigo types
+	Connector interface {
+		Connect() garyburd.Conn
+		PingConnect() (garyburd.Conn, error)
+	}
+
+	// ContextConnector is an interface type
+	// which describes the redis.ContextConnector
+	// methods with garyburd redigo types
+	ContextConnector interface {
+		Connector
+		ConnectContext(ctx context.Context) (garyburd.Conn, error)
+	}
+
+	// connector implements ContextConnector interface
+	// on top of a redis.Connector
+	connector struct {
+		c redis.Connector
+	}
+
+	// conn is a garyburd.ConnWithTimeout implementation
+	// which converts gomodule redigo errors
+	conn struct {
+		c redigo.Conn
+	}
+)
+
+// NewConnector returns a ContextConnector which
+// retrieves connections from c
+func NewConnector(c redis.Connector) ContextConnector {
+	return &connector{c: c}
+}
+
+// Connect returns a connection retrieved with c.Connect
+func (c *connector) Connect() garyburd.Conn {
+	return &conn{c: c.c.Connect()}
+}
+
+// ConnectContext returns a connection retrieved with redis.ConnectContext
+func (c *connector) ConnectContext(ctx context.Context) (garyburd.Conn, error) {
+	redisConn, err := redis.ConnectContext(ctx, c.c)
+	if err != nil {
+		return nil, convertErr(err)
+	}
+	return &conn{c: redisConn}, nil
+}
+
+// PingConnect returns a connection retrieved with c.PingConnect,
+// the connection is nil if no connection has been retrieved
+func (c *connector) PingConnect() (garyburd.Conn, error) {
+	redisConn, err := c.c.PingConnect()
+	if redisConn == nil {
+		return nil, convertErr(err)
+	}
+	return &conn{c: redisConn}, convertErr(err)
+}
+
+// Do calls Do of the wrapped connection
+func (cn *conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := cn.c.Do(cmd, args...)
+	return convertReply(reply), convertErr(err)
+}
+
+// DoWithTimeout calls DoWithTimeout of the wrapped connection
+func (cn *conn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	reply, err := redigo.DoWithTimeout(cn.c, timeout, cmd, args...)
+	return convertReply(reply), convertErr(err)
+}
+
+// Send calls Send of the wrapped connection
+func (cn *conn) Send(cmd string, args ...interface{}) error {
+	return convertErr(cn.c.Send(cmd, args...))
+}
+
+// Flush calls Flush of the wrapped connection
+func (cn *conn) Flush() error {
+	return convertErr(cn.c.Flush())
+}
+
+// Receive calls Receive of the wrapped connection
+func (cn *conn) Receive() (interface{}, error) {
+	reply, err := cn.c.Receive()
+	return convertReply(reply), convertErr(err)
+}
+
+// ReceiveWithTimeout calls ReceiveWithTimeout of the wrapped connection
+func (cn *conn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	reply, err := redigo.ReceiveWithTimeout(cn.c, timeout)
+	return convertReply(reply), convertErr(err)
+}
+
+// Err calls Err of the wrapped connection
+func (cn *conn) Err() error {
+	return convertErr(cn.c.Err())
+}
+
+// Close calls Close of the wrapped connection
+func (cn *conn) Close() error {
+	return convertErr(cn.c.Close())
+}
+
+// convertErr converts gomodule redigo errors
+// into the garyburd redigo ones
+func convertErr(err error) error {
+	if e, ok := err.(redigo.Error); ok {
+		return garyburd.Error(e)
+	}
+
+	switch err {
+	case redigo.ErrNil:
+		return garyburd.ErrNil
+	case redigo.ErrPoolExhausted:
+		return garyburd.ErrPoolExhausted
+	}
+
+	return err
+}
+
+// convertReply converts error replies nested
+// into array replies (e.g. EXEC reply)
+func convertReply(reply interface{}) interface{} {
+	values, ok := reply.([]interface{})
+	if !ok {
+		if e, ok := reply.(redigo.Error); ok {
+			return garyburd.Error(e)
+		}
+		return reply
+	}
+
+	for i, v := range values {
+		values[i] = convertReply(v)
+	}
+	return values
+}
@@ -0,0 +1,72 @@
+package compat
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	garyburd "github.com/garyburd/redigo/redis"
+	gc "github.com/go-check/check"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/onefootball/samodelkin/redis"
+	"github.com/onefootball/samodelkin/redis/redistest"
+)
+
+type CompatTestSuite struct{}
+
+var _ = gc.Suite(&CompatTestSuite{})
+
+func TestCompat(t *testing.T) { gc.TestingT(t) }
+
+func (s *CompatTestSuite) TestConnConvertsErrors(c *gc.C) {
+	connector := NewConnector(redistest.NewConnector())
+
+	conn, err := connector.ConnectContext(context.Background())
+	c.Assert(err, gc.IsNil)
+	defer conn.Close()
+
+	_, err = conn.Do("HSET", "team:1", "name", "Arsenal")
+	c.Assert(err, gc.IsNil)
+
+	_, err = conn.Do("GET", "team:1")
+	c.Check(err, gc.FitsTypeOf, garyburd.Error(""))
+
+	conn.Send("MULTI")
+	conn.Send("GET", "team:1")
+	values, err := garyburd.Values(conn.Do("EXEC"))
+	c.Assert(err, gc.IsNil)
+	c.Check(values[0], gc.FitsTypeOf, garyburd.Error(""))
+
+	_, err = garyburd.String(conn.Do("GET", "team:2"))
+	c.Check(err, gc.Equals, garyburd.ErrNil)
+
+	psc := garyburd.PubSubConn{Conn: connector.Connect()}
+	_, isTimeoutConn := psc.Conn.(garyburd.ConnWithTimeout)
+	c.Check(isTimeoutConn, gc.Equals, true)
+}
+
+// failingConnector is a redis.Connector
+// which fails to retrieve connections
+type failingConnector struct {
+	redis.Connector
+}
+
+func (failingConnector) PingConnect() (redigo.Conn, error) {
+	return nil, redigo.ErrPoolExhausted
+}
+
+func (s *CompatTestSuite) TestPingConnectWithoutConn(c *gc.C) {
+	conn, err := NewConnector(failingConnector{}).PingConnect()
+	c.Check(conn, gc.IsNil)
+	c.Check(err, gc.Equals, garyburd.ErrPoolExhausted)
+}
+
+func (s *CompatTestSuite) TestConvertErr(c *gc.C) {
+	otherErr := errors.New("connection reset by peer")
+
+	c.Check(convertErr(nil), gc.IsNil)
+	c.Check(convertErr(redigo.ErrNil), gc.Equals, garyburd.ErrNil)
+	c.Check(convertErr(redigo.ErrPoolExhausted), gc.Equals, garyburd.ErrPoolExhausted)
+	c.Check(convertErr(redigo.Error("READONLY")), gc.Equals, garyburd.Error("READONLY"))
+	c.Check(convertErr(otherErr), gc.Equals, otherErr)
+}
@@ -0,0 +1,258 @@
+package redis
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultPort holds the port used by ParseURL
+// if the url does not specify one
+const DefaultPort = 6379
+
+type (
+	// jsonConfig is a RedisConfig
+	// without the JSON (un)marshaling methods
+	jsonConfig RedisConfig
+
+	// duration is a time.Duration which is
+	// (un)marshaled as a time.ParseDuration string
+	duration time.Duration
+
+	// jsonDurations is a struct type
+	// which overrides RedisConfig duration fields
+	// for the JSON (un)marshaling
+	jsonDurations struct {
+		*jsonConfig
+		IdleTimeout      duration `json:"idle_timeout"`
+		ConnectTimeout   duration `json:"connect_timeout"`
+		ReadTimeout      duration `json:"read_timeout"`
+		WriteTimeout     duration `json:"write_timeout"`
+		BorrowCheckAfter duration `json:"borrow_check_after"`
+	}
+)
+
+// ParseURL parses a redis:// or rediss:// (TLS) url
+// into RedisConfig:
+//
+//	redis://[[user]:password@]host[:port][/db][?option=value&...]
+//
+// Options hold RedisConfig fields under their json names:
+// max_idle_conns, max_active_conns, idle_timeout, connect_timeout,
+// read_timeout, write_timeout, wait, borrow_check_after.
+// Durations are time.ParseDuration strings
+func ParseURL(rawurl string) (*RedisConfig, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &RedisConfig{Port: DefaultPort}
+
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		cfg.TLS = true
+	default:
+		return nil, fmt.Errorf("redis: invalid url scheme: %q", u.Scheme)
+	}
+
+	cfg.Host = u.Hostname()
+	if cfg.Host == "" {
+		return nil, errors.New("redis: url host is missing")
+	}
+
+	if port := u.Port(); port != "" {
+		if cfg.Port, err = strconv.Atoi(port); err != nil {
+			return nil, fmt.Errorf("redis: invalid url port: %q", port)
+		}
+	}
+
+	if u.User != nil {
+		cfg.User = u.User.Username()
+		cfg.Password, _ = u.User.Password()
+	}
+
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if cfg.DB, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("redis: invalid url database: %q", db)
+		}
+	}
+
+	for name, values := range u.Query() {
+		if err := cfg.setOption(name, values[len(values)-1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// ConfigFromEnv parses the url held
+// by the env var with ParseURL
+func ConfigFromEnv(name string) (*RedisConfig, error) {
+	rawurl := os.Getenv(name)
+	if rawurl == "" {
+		return nil, fmt.Errorf("redis: env var %s is not set", name)
+	}
+	return ParseURL(rawurl)
+}
+
+// URL returns a redis:// or rediss:// url
+// holding the connection and pool configurations
+func (rc *RedisConfig) URL() string {
+	u := url.URL{Scheme: "redis", Host: net.JoinHostPort(rc.Host, strconv.Itoa(rc.Port))}
+	if rc.TLS {
+		u.Scheme = "rediss"
+	}
+
+	if rc.Password != "" {
+		u.User = url.UserPassword(rc.User, rc.Password)
+	} else if rc.User != "" {
+		u.User = url.User(rc.User)
+	}
+
+	if rc.DB > 0 {
+		u.Path = "/" + strconv.Itoa(rc.DB)
+	}
+
+	q := url.Values{}
+	setInt := func(name string, v int) {
+		if v != 0 {
+			q.Set(name, strconv.Itoa(v))
+		}
+	}
+	setDuration := func(name string, d time.Duration) {
+		if d != 0 {
+			q.Set(name, d.String())
+		}
+	}
+
+	setInt("max_idle_conns", rc.MaxIdleConns)
+	setInt("max_active_conns", rc.MaxActiveConns)
+	setDuration("idle_timeout", rc.IdleTimeout)
+	setDuration("connect_timeout", rc.ConnectTimeout)
+	setDuration("read_timeout", rc.ReadTimeout)
+	setDuration("write_timeout", rc.WriteTimeout)
+	setDuration("borrow_check_after", rc.BorrowCheckAfter)
+	if rc.Wait {
+		q.Set("wait", "true")
+	}
+
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+// UnmarshalJSON loads RedisConfig from a JSON object
+// with string durations or from a JSON string holding
+// a url accepted by ParseURL
+func (rc *RedisConfig) UnmarshalJSON(b []byte) error {
+	var rawurl string
+	if err := json.Unmarshal(b, &rawurl); err == nil {
+		cfg, err := ParseURL(rawurl)
+		if err != nil {
+			return err
+		}
+
+		*rc = *cfg
+		return nil
+	}
+
+	aux := jsonDurations{
+		jsonConfig:       (*jsonConfig)(rc),
+		IdleTimeout:      duration(rc.IdleTimeout),
+		ConnectTimeout:   duration(rc.ConnectTimeout),
+		ReadTimeout:      duration(rc.ReadTimeout),
+		WriteTimeout:     duration(rc.WriteTimeout),
+		BorrowCheckAfter: duration(rc.BorrowCheckAfter),
+	}
+
+	if err := json.Unmarshal(b, &aux); err != nil {
+		return err
+	}
+
+	rc.IdleTimeout = time.Duration(aux.IdleTimeout)
+	rc.ConnectTimeout = time.Duration(aux.ConnectTimeout)
+	rc.ReadTimeout = time.Duration(aux.ReadTimeout)
+	rc.WriteTimeout = time.Duration(aux.WriteTimeout)
+	rc.BorrowCheckAfter = time.Duration(aux.BorrowCheckAfter)
+
+	return nil
+}
+
+// MarshalJSON returns RedisConfig as a JSON object
+// with string durations
+func (rc RedisConfig) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonDurations{
+		jsonConfig:       (*jsonConfig)(&rc),
+		IdleTimeout:      duration(rc.IdleTimeout),
+		ConnectTimeout:   duration(rc.ConnectTimeout),
+		ReadTimeout:      duration(rc.ReadTimeout),
+		WriteTimeout:     duration(rc.WriteTimeout),
+		BorrowCheckAfter: duration(rc.BorrowCheckAfter),
+	})
+}
+
+// setOption sets the config field by its json name
+func (rc *RedisConfig) setOption(name, value string) error {
+	var err error
+	switch name {
+	case "max_idle_conns":
+		rc.MaxIdleConns, err = strconv.Atoi(value)
+	case "max_active_conns":
+		rc.MaxActiveConns, err = strconv.Atoi(value)
+	case "idle_timeout":
+		rc.IdleTimeout, err = time.ParseDuration(value)
+	case "connect_timeout":
+		rc.ConnectTimeout, err = time.ParseDuration(value)
+	case "read_timeout":
+		rc.ReadTimeout, err = time.ParseDuration(value)
+	case "write_timeout":
+		rc.WriteTimeout, err = time.ParseDuration(value)
+	case "borrow_check_after":
+		rc.BorrowCheckAfter, err = time.ParseDuration(value)
+	case "wait":
+		rc.Wait, err = strconv.ParseBool(value)
+	default:
+		return fmt.Errorf("redis: unknown url option: %q", name)
+	}
+
+	if err != nil {
+		return fmt.Errorf("redis: invalid url option %s: %q", name, value)
+	}
+	return nil
+}
+
+// UnmarshalJSON parses a time.ParseDuration string,
+// numbers are taken as nanoseconds
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		var ns int64
+		if err := json.Unmarshal(b, &ns); err != nil {
+			return fmt.Errorf("redis: invalid duration: %s", b)
+		}
+
+		*d = duration(ns)
+		return nil
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("redis: invalid duration: %q", s)
+	}
+
+	*d = duration(v)
+	return nil
+}
+
+// MarshalJSON returns the duration as a time.Duration string
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
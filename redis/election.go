@@ -0,0 +1,188 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+type (
+	// Elector is a struct type
+	// which elects a single leader among
+	// the instances campaigning for the same key.
+	//
+	// Leadership is a Locker lock (lease) with TTL,
+	// which the leader renews every TTL/3. The leader
+	// is demoted once the lease is taken by another
+	// instance or it fails to renew the lease before
+	// it expires, so keep TTL well above the expected
+	// redis latency and the instances clock drift
+	Elector struct {
+		locker *Locker
+		logger *log.Logger
+		leader int32
+
+		// Key holds the lease key
+		Key string
+		// TTL holds the lease ttl, at least 3ms
+		TTL time.Duration
+		// RetryInterval holds the interval between
+		// the campaign attempts, TTL/3 by default
+		RetryInterval time.Duration
+
+		// OnElected is called in its own goroutine when
+		// the instance becomes the leader. ctx is cancelled
+		// when the leadership is lost or Run ctx is done,
+		// returning from OnElected does not resign
+		OnElected func(ctx context.Context)
+		// OnDemoted is called when the leadership is lost
+		// or resigned, after OnElected has returned
+		OnDemoted func()
+	}
+)
+
+// NewElector inits and returns a pointer to Elector instance
+// which campaigns for the key with ttl lease.
+// The logs are discarded if logger is nil
+func NewElector(c Connector, logger *log.Logger, key string, ttl time.Duration) *Elector {
+	return &Elector{
+		locker:        NewLocker(c),
+		logger:        LoggerOrDiscard(logger),
+		Key:           key,
+		TTL:           ttl,
+		RetryInterval: ttl / 3,
+	}
+}
+
+// Run campaigns for the leadership until ctx is done.
+// The lease is released on return if the instance
+// is the leader, so another instance takes over
+// without waiting for the lease expiration.
+// An error is returned if TTL is shorter than 3ms
+// or RetryInterval is not positive
+func (e *Elector) Run(ctx context.Context) error {
+	if e.TTL < 3*time.Millisecond {
+		return errors.New("redis elector: ttl must be at least 3ms")
+	}
+
+	if e.RetryInterval <= 0 {
+		return errors.New("redis elector: retry interval must be positive")
+	}
+
+	for {
+		// the lease is valid for TTL since the acquire was sent
+		start := time.Now()
+		lock, err := e.locker.TryLock(ctx, e.Key, e.TTL)
+		if ctx.Err() != nil {
+			if err == nil {
+				// ctx is done right after the acquire, resign with a fresh one
+				e.resign(lock)
+			}
+			return nil
+		}
+
+		if err == nil {
+			e.lead(ctx, lock, start.Add(e.TTL))
+			continue
+		}
+
+		if err != ErrLockNotAcquired {
+			e.logger.Printf("redis elector: %s: %s", e.Key, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(e.RetryInterval):
+		}
+	}
+}
+
+// IsLeader returns true if the instance is the leader
+func (e *Elector) IsLeader() bool {
+	return atomic.LoadInt32(&e.leader) == 1
+}
+
+// lead calls OnElected and renews the lease valid until
+// validUntil until the leadership is lost or ctx is done
+func (e *Elector) lead(ctx context.Context, lock *Lock, validUntil time.Time) {
+	atomic.StoreInt32(&e.leader, 1)
+
+	leaderCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if e.OnElected == nil {
+			return
+		}
+
+		if err := tryCatch(func() error {
+			e.OnElected(leaderCtx)
+			return nil
+		}); err != nil {
+			e.logger.Printf("redis elector: %s: %s", e.Key, err)
+		}
+	}()
+
+	e.renew(leaderCtx, lock, validUntil)
+	cancel()
+	<-done
+
+	atomic.StoreInt32(&e.leader, 0)
+
+	if ctx.Err() != nil {
+		// ctx is done, resign with a fresh one
+		e.resign(lock)
+	}
+
+	if e.OnDemoted != nil {
+		e.OnDemoted()
+	}
+}
+
+// resign releases the lock with a fresh context,
+// as the Run ctx is already done
+func (e *Elector) resign(lock *Lock) {
+	if err := lock.Unlock(context.Background()); err != nil && err != ErrLockNotHeld {
+		e.logger.Printf("redis elector: %s: resign: %s", e.Key, err)
+	}
+}
+
+// renew extends the lease every TTL/3 until ctx is done,
+// the lease is taken by another instance or has expired
+func (e *Elector) renew(ctx context.Context, lock *Lock, validUntil time.Time) {
+	interval := e.TTL / 3
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		err := lock.Extend(ctx, e.TTL)
+		switch {
+		case err == nil:
+			validUntil = start.Add(e.TTL)
+		case ctx.Err() != nil:
+			return
+		case err == ErrLockNotHeld:
+			e.logger.Printf("redis elector: %s: leadership lost", e.Key)
+			return
+		default:
+			e.logger.Printf("redis elector: %s: renew: %s", e.Key, err)
+
+			// the lease may expire before the next attempt
+			if time.Now().Add(interval).After(validUntil) {
+				e.logger.Printf("redis elector: %s: leadership lost", e.Key)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	gc "github.com/go-check/check"
+)
+
+type ElectionTestSuite struct{}
+
+var _ = gc.Suite(&ElectionTestSuite{})
+
+// electionEvents records the elector callbacks
+type electionEvents struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (ev *electionEvents) add(event string) {
+	ev.mu.Lock()
+	ev.events = append(ev.events, event)
+	ev.mu.Unlock()
+}
+
+func (ev *electionEvents) get() []string {
+	ev.mu.Lock()
+	defer ev.mu.Unlock()
+	return append([]string(nil), ev.events...)
+}
+
+// newTestElector returns an elector which records
+// its callbacks as "<name> elected", "<name> cancelled"
+// and "<name> demoted" events
+func newTestElector(c Connector, logs *bytes.Buffer, ev *electionEvents, name string) *Elector {
+	e := NewElector(c, log.New(logs, "", 0), "jobs:leader", 60*time.Millisecond)
+	e.OnElected = func(ctx context.Context) {
+		ev.add(name + " elected")
+		<-ctx.Done()
+		ev.add(name + " cancelled")
+	}
+	e.OnDemoted = func() {
+		ev.add(name + " demoted")
+	}
+	return e
+}
+
+func (s *ElectionTestSuite) TestSingleLeader(c *gc.C) {
+	stub := &lockStub{}
+	connector := newStubConnector(stub.do)
+	ev := &electionEvents{}
+	var logs bytes.Buffer
+
+	first := newTestElector(connector, &logs, ev, "first")
+	ctx, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan error)
+	go func() { firstDone <- first.Run(ctx) }()
+
+	time.Sleep(50 * time.Millisecond)
+	c.Assert(first.IsLeader(), gc.Equals, true)
+
+	second := newTestElector(connector, &logs, ev, "second")
+	secondCtx, secondCancel := context.WithTimeout(context.Background(), 150*time.Millisecond)
+	defer secondCancel()
+
+	// the lease is renewed, so the second instance is never elected
+	c.Check(second.Run(secondCtx), gc.IsNil)
+	c.Check(second.IsLeader(), gc.Equals, false)
+
+	cancel()
+	c.Check(<-firstDone, gc.IsNil)
+	c.Check(first.IsLeader(), gc.Equals, false)
+	c.Check(ev.get(), gc.DeepEquals, []string{"first elected", "first cancelled", "first demoted"})
+	c.Check(stub.owner, gc.Equals, "")
+	c.Check(logs.String(), gc.Equals, "")
+}
+
+func (s *ElectionTestSuite) TestLeadershipLost(c *gc.C) {
+	stub := &lockStub{}
+	ev := &electionEvents{}
+	var logs bytes.Buffer
+
+	e := newTestElector(newStubConnector(stub.do), &logs, ev, "worker")
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- e.Run(ctx) }()
+
+	time.Sleep(30 * time.Millisecond)
+	c.Check(ev.get(), gc.DeepEquals, []string{"worker elected"})
+
+	// another instance takes over the lease
+	stub.mu.Lock()
+	stub.owner = "other"
+	stub.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	c.Check(e.IsLeader(), gc.Equals, false)
+	c.Check(ev.get(), gc.DeepEquals, []string{"worker elected", "worker cancelled", "worker demoted"})
+
+	// the other instance resigns
+	stub.mu.Lock()
+	stub.owner = ""
+	stub.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	c.Check(e.IsLeader(), gc.Equals, true)
+
+	cancel()
+	c.Check(<-done, gc.IsNil)
+	c.Check(ev.get(), gc.DeepEquals, []string{
+		"worker elected", "worker cancelled", "worker demoted",
+		"worker elected", "worker cancelled", "worker demoted",
+	})
+	c.Check(logs.String(), gc.Equals, "redis elector: jobs:leader: leadership lost\n")
+}
+
+func (s *ElectionTestSuite) TestCancelledAfterAcquireResigns(c *gc.C) {
+	stub := &lockStub{}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// ctx is cancelled while the acquire reply is in flight
+	connector := newStubConnector(func(cmd string, args ...interface{}) (interface{}, error) {
+		reply, err := stub.do(cmd, args...)
+		if args[0] == acquireScript.Hash() {
+			cancel()
+		}
+		return reply, err
+	})
+
+	ev := &electionEvents{}
+	var logs bytes.Buffer
+	e := newTestElector(connector, &logs, ev, "first")
+
+	c.Check(e.Run(ctx), gc.IsNil)
+	c.Check(e.IsLeader(), gc.Equals, false)
+	c.Check(ev.get(), gc.HasLen, 0)
+	c.Check(stub.owner, gc.Equals, "")
+	c.Check(logs.String(), gc.Equals, "")
+}
+
+func (s *ElectionTestSuite) TestInvalidConfig(c *gc.C) {
+	connector := newStubConnector((&lockStub{}).do)
+
+	e := NewElector(connector, nil, "jobs:leader", 2*time.Millisecond)
+	c.Check(e.Run(context.Background()), gc.ErrorMatches, "redis elector: ttl must be at least 3ms")
+
+	e = NewElector(connector, nil, "jobs:leader", time.Second)
+	e.RetryInterval = 0
+	c.Check(e.Run(context.Background()), gc.ErrorMatches, "redis elector: retry interval must be positive")
+}
@@ -0,0 +1,208 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+type (
+	// CommandInfo is a struct type
+	// which holds an executed command details
+	CommandInfo struct {
+		// Name holds the command name
+		Name string
+		// Args holds the command arguments
+		Args []interface{}
+		// Address holds the pool redis server address
+		Address string
+		// Start holds the command start time, pipelined
+		// commands start when the pipeline is flushed
+		Start time.Time
+		// Duration holds the time spent waiting for the reply
+		Duration time.Duration
+		// Err holds the connection error or the error reply
+		Err error
+	}
+
+	// CommandHook is a func type which is called
+	// after every command executed on a pool connection.
+	// ctx is the one the connection was retrieved with,
+	// context.Background() for Connect and PingConnect
+	CommandHook func(ctx context.Context, info CommandInfo)
+
+	// hookConn is a redis.ConnWithTimeout wrapper
+	// which reports executed commands to the hooks
+	hookConn struct {
+		redis.Conn
+		ctx   context.Context
+		addr  string
+		hooks []CommandHook
+
+		// pending holds the sent commands awaiting replies,
+		// Send and Receive may be called concurrently
+		// (pub/sub connections)
+		mu      sync.Mutex
+		pending []CommandInfo
+		flushed time.Time
+
+		// subscribed is set once a subscribe command is sent,
+		// the replies of the subscribed connection are pushed
+		// messages interleaved with the command replies, so
+		// the commands sent from then on are not reported
+		subscribed bool
+	}
+)
+
+// SlowLog returns a CommandHook which logs the commands
+// which took longer than threshold. Command arguments
+// are not logged as they may hold sensitive data.
+// The logs are discarded if logger is nil
+func SlowLog(logger *log.Logger, threshold time.Duration) CommandHook {
+	logger = LoggerOrDiscard(logger)
+	return func(_ context.Context, info CommandInfo) {
+		if info.Duration < threshold {
+			return
+		}
+
+		if info.Err != nil {
+			logger.Printf("redis: slow command %s on %s took %s: %s", info.Name, info.Address, info.Duration, info.Err)
+			return
+		}
+
+		logger.Printf("redis: slow command %s on %s took %s", info.Name, info.Address, info.Duration)
+	}
+}
+
+// Do executes the command and reports it to the hooks
+// along with the sent commands it reads the replies of
+func (c *hookConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	start, pending := time.Now(), c.takePending()
+	reply, err := c.Conn.Do(cmd, args...)
+	c.observeDone(start, pending, cmd, args, err)
+
+	return reply, err
+}
+
+// DoWithTimeout executes the command with the read timeout
+// and reports it to the hooks along with the sent commands
+// it reads the replies of
+func (c *hookConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	start, pending := time.Now(), c.takePending()
+	reply, err := redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+	c.observeDone(start, pending, cmd, args, err)
+
+	return reply, err
+}
+
+// Send buffers the command, it is reported on Receive
+// unless the connection is in the subscribe mode
+func (c *hookConn) Send(cmd string, args ...interface{}) error {
+	c.mu.Lock()
+	if !c.subscribed {
+		c.pending = append(c.pending, CommandInfo{Name: cmd, Args: args})
+		c.subscribed = isSubscribeCmd(cmd)
+	}
+	c.mu.Unlock()
+
+	return c.Conn.Send(cmd, args...)
+}
+
+// Flush sends the buffered commands to the server
+func (c *hookConn) Flush() error {
+	c.mu.Lock()
+	c.flushed = time.Now()
+	c.mu.Unlock()
+
+	return c.Conn.Flush()
+}
+
+// Receive reads a reply and reports
+// the oldest sent command to the hooks
+func (c *hookConn) Receive() (interface{}, error) {
+	reply, err := c.Conn.Receive()
+	c.observeReceived(err)
+	return reply, err
+}
+
+// ReceiveWithTimeout reads a reply with the read timeout
+// and reports the oldest sent command to the hooks
+func (c *hookConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	reply, err := redis.ReceiveWithTimeout(c.Conn, timeout)
+	c.observeReceived(err)
+	return reply, err
+}
+
+// observeReceived reports the oldest sent command if any,
+// the replies of the subscribed connection past the first
+// subscription confirmation are not reported
+func (c *hookConn) observeReceived(err error) {
+	c.mu.Lock()
+	if len(c.pending) == 0 {
+		c.mu.Unlock()
+		return
+	}
+
+	info := c.pending[0]
+	c.pending = c.pending[1:]
+	info.Start = c.flushed
+	c.mu.Unlock()
+
+	c.observe(info, err)
+}
+
+// takePending returns and forgets the sent commands,
+// Do reads the replies of all of them
+func (c *hookConn) takePending() []CommandInfo {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	pending := c.pending
+	c.pending = nil
+	return pending
+}
+
+// observeDone reports the pending commands and the Do command,
+// pooled connections call Do with an empty command on close.
+// The pending commands are reported with the Do start and
+// the Do connection error, their error replies are not known
+func (c *hookConn) observeDone(start time.Time, pending []CommandInfo, cmd string, args []interface{}, err error) {
+	connErr := err
+	if _, ok := err.(redis.Error); ok {
+		connErr = nil
+	}
+
+	for _, info := range pending {
+		info.Start = start
+		c.observe(info, connErr)
+	}
+
+	if cmd != "" {
+		c.observe(CommandInfo{Name: cmd, Args: args, Start: start}, err)
+	}
+}
+
+// observe completes the command info and calls the hooks
+func (c *hookConn) observe(info CommandInfo, err error) {
+	info.Address = c.addr
+	info.Duration = time.Since(info.Start)
+	info.Err = err
+
+	for _, hook := range c.hooks {
+		hook(c.ctx, info)
+	}
+}
+
+// isSubscribeCmd reports whether the command
+// switches the connection to the subscribe mode
+func isSubscribeCmd(cmd string) bool {
+	switch strings.ToUpper(cmd) {
+	case "SUBSCRIBE", "PSUBSCRIBE", "SSUBSCRIBE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,112 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type HookTestSuite struct{}
+
+var _ = gc.Suite(&HookTestSuite{})
+
+type hookCtxKey struct{}
+
+func (s *HookTestSuite) TestHooksReceiveCommands(c *gc.C) {
+	var infos []CommandInfo
+	var ctxValues []interface{}
+
+	pool := newStubPool(func(cmd string, args ...interface{}) (interface{}, error) {
+		if cmd == "GET" {
+			return nil, redis.Error("WRONGTYPE")
+		}
+		return "OK", nil
+	})
+	pool.addr = "redis.local:6379"
+	pool.hooks = []CommandHook{func(ctx context.Context, info CommandInfo) {
+		infos = append(infos, info)
+		ctxValues = append(ctxValues, ctx.Value(hookCtxKey{}))
+	}}
+	connector := &RedisConnector{pools: []*connPool{pool}}
+
+	ctx := context.WithValue(context.Background(), hookCtxKey{}, "request-1")
+	conn, err := connector.ConnectContext(ctx)
+	c.Assert(err, gc.IsNil)
+
+	conn.Do("SET", "match:1", "2:1")
+	conn.Do("GET", "match:1")
+
+	p := NewPipeline(conn)
+	p.Send("EXPIRE", "match:1", 60)
+	p.Send("PING")
+	p.Exec()
+
+	// the queued commands are reported on EXEC
+	conn.Send("MULTI")
+	conn.Send("INCR", "goals")
+	conn.Do("EXEC")
+	conn.Do("PING")
+	conn.Close()
+
+	c.Assert(infos, gc.HasLen, 8)
+	for i, name := range []string{"SET", "GET", "EXPIRE", "PING", "MULTI", "INCR", "EXEC", "PING"} {
+		c.Check(infos[i].Name, gc.Equals, name)
+		c.Check(infos[i].Address, gc.Equals, "redis.local:6379")
+		c.Check(infos[i].Start.IsZero(), gc.Equals, false)
+		c.Check(ctxValues[i], gc.Equals, "request-1")
+	}
+
+	c.Check(infos[0].Args, gc.DeepEquals, []interface{}{"match:1", "2:1"})
+	c.Check(infos[0].Err, gc.IsNil)
+	c.Check(infos[1].Err, gc.Equals, redis.Error("WRONGTYPE"))
+
+	_, isTimeoutConn := connector.Connect().(redis.ConnWithTimeout)
+	c.Check(isTimeoutConn, gc.Equals, true)
+}
+
+func (s *HookTestSuite) TestSubscribedRepliesAreNotReported(c *gc.C) {
+	var names []string
+
+	pool := newStubPool(func(cmd string, args ...interface{}) (interface{}, error) {
+		return "OK", nil
+	})
+	pool.hooks = []CommandHook{func(_ context.Context, info CommandInfo) {
+		names = append(names, info.Name)
+	}}
+	connector := &RedisConnector{pools: []*connPool{pool}}
+
+	conn := connector.Connect()
+	defer conn.Close()
+
+	conn.Send("CLIENT", "SETNAME", "scores")
+	psc := redis.PubSubConn{Conn: conn}
+	psc.Subscribe("invalidate", "scores")
+	psc.Ping("")
+
+	for i := 0; i < 4; i++ {
+		psc.Conn.Receive()
+	}
+
+	c.Check(names, gc.DeepEquals, []string{"CLIENT", "SUBSCRIBE"})
+}
+
+func (s *HookTestSuite) TestSlowLog(c *gc.C) {
+	var logs bytes.Buffer
+	hook := SlowLog(log.New(&logs, "", 0), 100*time.Millisecond)
+
+	hook(context.Background(), CommandInfo{Name: "GET", Address: "redis.local:6379", Duration: time.Millisecond})
+	hook(context.Background(), CommandInfo{Name: "KEYS", Address: "redis.local:6379", Duration: 150 * time.Millisecond})
+	hook(context.Background(), CommandInfo{Name: "SMEMBERS", Address: "redis.local:6379", Duration: time.Second, Err: redis.Error("READONLY")})
+
+	c.Check(logs.String(), gc.Equals, "redis: slow command KEYS on redis.local:6379 took 150ms\n"+
+		"redis: slow command SMEMBERS on redis.local:6379 took 1s: READONLY\n")
+}
+
+func (s *HookTestSuite) TestSlowLogNilLogger(c *gc.C) {
+	hook := SlowLog(nil, 0)
+	hook(context.Background(), CommandInfo{Name: "KEYS", Address: "redis.local:6379", Duration: time.Second})
+}
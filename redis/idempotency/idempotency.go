@@ -0,0 +1,286 @@
+// Package idempotency provides redis backed
+// idempotency keys for deduplication of
+// redelivered messages and retried HTTP requests.
+//
+// A key goes through two states: Acquire takes a short
+// in-progress lease before the operation is processed,
+// Complete (or SaveResponse) replaces it with a long-lived
+// done marker once the operation succeeds. Release the key
+// if the operation fails to let the next delivery or retry
+// process it again. If the process crashes in between,
+// the lease expires and the operation is processed again,
+// so keep the lease above the operation processing time.
+//
+// Every lease holds a random token returned by Acquire,
+// Complete and Release change the key only while it holds
+// the token, so an attempt which outlived its lease can not
+// override the attempt which took the key over
+package idempotency
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/onefootball/samodelkin/redis"
+)
+
+const (
+	// DefaultPrefix holds the default idempotency keys prefix
+	DefaultPrefix = "idempotency:"
+
+	// DefaultLease holds the default in-progress lease
+	DefaultLease = 30 * time.Second
+
+	// DefaultMaxBodySize holds the default size limit
+	// of the request bodies read by Middleware
+	DefaultMaxBodySize = 1 << 20
+
+	// leasePrefix prefixes the token held by the in-progress
+	// keys, doneValue is held by the done keys without a response
+	leasePrefix = "lease:"
+	doneValue   = "1"
+)
+
+var (
+	// ErrInProgress is returned by Acquire and Response
+	// if the operation holds an unexpired in-progress lease
+	ErrInProgress = errors.New("idempotency: operation in progress")
+
+	// ErrNotMarked is returned by Response
+	// if the key is not marked or has expired
+	ErrNotMarked = errors.New("idempotency: key not marked")
+
+	// ErrNoResponse is returned by Response if the
+	// operation is done without a stored response
+	ErrNoResponse = errors.New("idempotency: no stored response")
+
+	// ErrInvalidTTL is returned if a ttl or lease
+	// is shorter than a millisecond
+	ErrInvalidTTL = errors.New("idempotency: ttl must be at least 1ms")
+
+	// ErrLeaseLost is returned by Complete, SaveResponse and Release
+	// if the lease has expired or has been taken by another attempt
+	ErrLeaseLost = errors.New("idempotency: lease lost")
+)
+
+var (
+	// completeScript replaces the lease with the done value
+	// only if the key holds the lease token
+	completeScript = redis.DefaultScripts.Register(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+	return 1
+end
+return 0
+`)
+
+	// releaseScript deletes the key
+	// only if it holds the lease token
+	releaseScript = redis.DefaultScripts.Register(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+)
+
+type (
+	// Store is a struct type
+	// which marks processed operations
+	// on top of a redis.Connector
+	Store struct {
+		connector redis.Connector
+
+		// Prefix holds the idempotency keys prefix
+		Prefix string
+	}
+
+	// Response is a struct type
+	// which holds a stored HTTP response
+	Response struct {
+		Status int         `json:"status"`
+		Header http.Header `json:"header"`
+		Body   []byte      `json:"body"`
+		// Fingerprint holds the request fingerprint,
+		// see Middleware
+		Fingerprint string `json:"fingerprint"`
+	}
+)
+
+// NewStore inits and returns a pointer to Store instance
+func NewStore(c redis.Connector) *Store {
+	return &Store{connector: c, Prefix: DefaultPrefix}
+}
+
+// MarkIfAbsent marks the key done for ttl and returns true
+// if the key has already been marked. The key is marked
+// before the operation is processed, use Acquire and Complete
+// if the operation must be retried after a crash
+func (s *Store) MarkIfAbsent(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	if ttl < time.Millisecond {
+		return false, ErrInvalidTTL
+	}
+
+	redisConn, err := redis.ConnectContext(ctx, s.connector)
+	if err != nil {
+		return false, err
+	}
+	defer redisConn.Close()
+
+	_, err = redigo.String(redisConn.Do("SET", s.Prefix+key, doneValue, "NX", "PX", millis(ttl)))
+	if err == redigo.ErrNil {
+		return true, nil
+	}
+
+	return false, err
+}
+
+// Acquire takes the in-progress lease of the key and returns
+// the lease token if the operation has to be processed.
+// An empty token is returned if the operation is done
+// or another attempt holds an unexpired lease
+func (s *Store) Acquire(ctx context.Context, key string, lease time.Duration) (string, error) {
+	if lease < time.Millisecond {
+		return "", ErrInvalidTTL
+	}
+
+	token, err := leaseToken()
+	if err != nil {
+		return "", err
+	}
+
+	redisConn, err := redis.ConnectContext(ctx, s.connector)
+	if err != nil {
+		return "", err
+	}
+	defer redisConn.Close()
+
+	_, err = redigo.String(redisConn.Do("SET", s.Prefix+key, leasePrefix+token, "NX", "PX", millis(lease)))
+	if err == redigo.ErrNil {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// Complete marks the key done for ttl if it holds
+// the lease token, ErrLeaseLost is returned otherwise
+func (s *Store) Complete(ctx context.Context, key, token string, ttl time.Duration) error {
+	return s.complete(ctx, key, token, doneValue, ttl)
+}
+
+// Release removes the key if it holds the lease token,
+// so the operation can be processed again.
+// ErrLeaseLost is returned if the key does not hold the token
+func (s *Store) Release(ctx context.Context, key, token string) error {
+	released, err := redigo.Bool(releaseScript.Run(ctx, s.connector, s.Prefix+key, leasePrefix+token))
+	if err != nil {
+		return err
+	}
+
+	if !released {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+// Unmark removes the key marked with MarkIfAbsent
+func (s *Store) Unmark(ctx context.Context, key string) error {
+	redisConn, err := redis.ConnectContext(ctx, s.connector)
+	if err != nil {
+		return err
+	}
+	defer redisConn.Close()
+
+	_, err = redisConn.Do("DEL", s.Prefix+key)
+	return err
+}
+
+// SaveResponse marks the key done for ttl storing the operation
+// response if it holds the lease token, ErrLeaseLost is returned otherwise
+func (s *Store) SaveResponse(ctx context.Context, key, token string, res *Response, ttl time.Duration) error {
+	b, err := json.Marshal(res)
+	if err != nil {
+		return err
+	}
+
+	return s.complete(ctx, key, token, b, ttl)
+}
+
+// Response returns the response stored for the key.
+// ErrInProgress is returned if the key holds a lease,
+// ErrNoResponse if the key is done without a response
+func (s *Store) Response(ctx context.Context, key string) (*Response, error) {
+	redisConn, err := redis.ConnectContext(ctx, s.connector)
+	if err != nil {
+		return nil, err
+	}
+	defer redisConn.Close()
+
+	b, err := redigo.Bytes(redisConn.Do("GET", s.Prefix+key))
+	if err == redigo.ErrNil {
+		return nil, ErrNotMarked
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(string(b), leasePrefix) {
+		return nil, ErrInProgress
+	}
+
+	if string(b) == doneValue {
+		return nil, ErrNoResponse
+	}
+
+	res := &Response{}
+	if err := json.Unmarshal(b, res); err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// complete replaces the lease with the value for ttl
+// if the key holds the lease token
+func (s *Store) complete(ctx context.Context, key, token string, value interface{}, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidTTL
+	}
+
+	completed, err := redigo.Bool(completeScript.Run(ctx, s.connector, s.Prefix+key, leasePrefix+token, value, millis(ttl)))
+	if err != nil {
+		return err
+	}
+
+	if !completed {
+		return ErrLeaseLost
+	}
+
+	return nil
+}
+
+// leaseToken returns a random lease token
+func leaseToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// millis returns d in milliseconds
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
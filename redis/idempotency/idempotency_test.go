@@ -0,0 +1,330 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	gc "github.com/go-check/check"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/onefootball/samodelkin/redis/redistest"
+)
+
+type IdempotencyTestSuite struct{}
+
+var _ = gc.Suite(&IdempotencyTestSuite{})
+
+func TestIdempotency(t *testing.T) { gc.TestingT(t) }
+
+// scriptConnector is a redistest.Connector wrapper
+// which emulates the store scripts with plain commands
+type scriptConnector struct {
+	*redistest.Connector
+}
+
+// scriptConn emulates EVALSHA of the store scripts
+type scriptConn struct {
+	redigo.Conn
+}
+
+func newScriptConnector() *scriptConnector {
+	return &scriptConnector{redistest.NewConnector()}
+}
+
+func (c *scriptConnector) Connect() redigo.Conn {
+	return &scriptConn{c.Connector.Connect()}
+}
+
+func (c *scriptConnector) ConnectContext(ctx context.Context) (redigo.Conn, error) {
+	redisConn, err := c.Connector.ConnectContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &scriptConn{redisConn}, nil
+}
+
+func (c *scriptConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "EVALSHA" {
+		return c.Conn.Do(cmd, args...)
+	}
+
+	// args: hash, numkeys, key, token, argv...
+	key, token := args[2], args[3].(string)
+	value, err := redigo.String(c.Conn.Do("GET", key))
+	if err != nil && err != redigo.ErrNil {
+		return nil, err
+	}
+	if value != token {
+		return int64(0), nil
+	}
+
+	switch args[0] {
+	case completeScript.Hash():
+		_, err = c.Conn.Do("SET", key, args[4], "PX", args[5])
+	case releaseScript.Hash():
+		_, err = c.Conn.Do("DEL", key)
+	}
+	return int64(1), err
+}
+
+// newMiddleware returns a Middleware storing the responses for an hour
+func newMiddleware(s *Store, next http.Handler) *Middleware {
+	m, err := NewMiddleware(s, nil, time.Hour, nil, next)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func (s *IdempotencyTestSuite) TestMarkIfAbsent(c *gc.C) {
+	connector := redistest.NewConnector()
+	store := NewStore(connector)
+	ctx := context.Background()
+
+	processed, err := store.MarkIfAbsent(ctx, "msg-1", time.Minute)
+	c.Assert(err, gc.IsNil)
+	c.Check(processed, gc.Equals, false)
+
+	processed, err = store.MarkIfAbsent(ctx, "msg-1", time.Minute)
+	c.Assert(err, gc.IsNil)
+	c.Check(processed, gc.Equals, true)
+
+	c.Assert(store.Unmark(ctx, "msg-1"), gc.IsNil)
+	processed, err = store.MarkIfAbsent(ctx, "msg-1", time.Minute)
+	c.Assert(err, gc.IsNil)
+	c.Check(processed, gc.Equals, false)
+
+	connector.FastForward(time.Minute)
+	processed, err = store.MarkIfAbsent(ctx, "msg-1", time.Minute)
+	c.Assert(err, gc.IsNil)
+	c.Check(processed, gc.Equals, false)
+
+	_, err = store.MarkIfAbsent(ctx, "msg-2", 0)
+	c.Check(err, gc.Equals, ErrInvalidTTL)
+}
+
+func (s *IdempotencyTestSuite) TestAcquireComplete(c *gc.C) {
+	connector := newScriptConnector()
+	store := NewStore(connector)
+	ctx := context.Background()
+
+	token, err := store.Acquire(ctx, "msg-1", time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(token, gc.Not(gc.Equals), "")
+
+	// redelivery while the lease is held
+	redelivered, err := store.Acquire(ctx, "msg-1", time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(redelivered, gc.Equals, "")
+
+	// the consumer outlives its lease, the redelivery takes the key over
+	connector.FastForward(time.Second)
+	taken, err := store.Acquire(ctx, "msg-1", time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(taken, gc.Not(gc.Equals), "")
+	c.Check(store.Release(ctx, "msg-1", token), gc.Equals, ErrLeaseLost)
+	c.Check(store.Complete(ctx, "msg-1", token, time.Hour), gc.Equals, ErrLeaseLost)
+
+	c.Assert(store.Complete(ctx, "msg-1", taken, time.Hour), gc.IsNil)
+	connector.FastForward(time.Minute)
+	done, err := store.Acquire(ctx, "msg-1", time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(done, gc.Equals, "")
+	c.Check(store.Release(ctx, "msg-1", taken), gc.Equals, ErrLeaseLost)
+
+	// failed attempts release the lease
+	token, _ = store.Acquire(ctx, "msg-2", time.Second)
+	c.Assert(store.Release(ctx, "msg-2", token), gc.IsNil)
+	token, err = store.Acquire(ctx, "msg-2", time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(token, gc.Not(gc.Equals), "")
+
+	_, err = store.Acquire(ctx, "msg-3", 0)
+	c.Check(err, gc.Equals, ErrInvalidTTL)
+	c.Check(store.Complete(ctx, "msg-2", token, 0), gc.Equals, ErrInvalidTTL)
+}
+
+func (s *IdempotencyTestSuite) TestResponse(c *gc.C) {
+	store := NewStore(newScriptConnector())
+	ctx := context.Background()
+	res := &Response{Status: http.StatusCreated, Header: http.Header{"Location": {"/orders/1"}}, Body: []byte("created")}
+
+	_, err := store.Response(ctx, "order")
+	c.Check(err, gc.Equals, ErrNotMarked)
+
+	token, _ := store.Acquire(ctx, "order", time.Minute)
+	_, err = store.Response(ctx, "order")
+	c.Check(err, gc.Equals, ErrInProgress)
+
+	store.MarkIfAbsent(ctx, "done", time.Minute)
+	_, err = store.Response(ctx, "done")
+	c.Check(err, gc.Equals, ErrNoResponse)
+
+	c.Assert(store.SaveResponse(ctx, "order", token, res, time.Minute), gc.IsNil)
+	obtained, err := store.Response(ctx, "order")
+	c.Assert(err, gc.IsNil)
+	c.Check(obtained, gc.DeepEquals, res)
+}
+
+// serve sends a POST /orders request with the idempotency key
+func serve(h http.Handler, key string) *httptest.ResponseRecorder {
+	return serveRequest(h, key, "", "{}")
+}
+
+// serveRequest sends a POST /orders request with the
+// idempotency key, authorization header and body
+func serveRequest(h http.Handler, key, auth, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", "/orders", strings.NewReader(body))
+	if key != "" {
+		r.Header.Set(HeaderName, key)
+	}
+	if auth != "" {
+		r.Header.Set("Authorization", auth)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func (s *IdempotencyTestSuite) TestMiddlewareReplaysResponse(c *gc.C) {
+	calls := 0
+	m := newMiddleware(NewStore(newScriptConnector()), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Location", "/orders/1")
+		w.Header().Set("Set-Cookie", "session=secret")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+
+	first := serve(m, "abc")
+	c.Check(first.Code, gc.Equals, http.StatusCreated)
+
+	replayed := serve(m, "abc")
+	c.Check(calls, gc.Equals, 1)
+	c.Check(replayed.Code, gc.Equals, http.StatusCreated)
+	c.Check(replayed.Body.String(), gc.Equals, "created")
+	c.Check(replayed.Header().Get("Location"), gc.Equals, "/orders/1")
+	c.Check(replayed.Header().Get("Idempotent-Replayed"), gc.Equals, "true")
+	c.Check(replayed.Header().Get("Set-Cookie"), gc.Equals, "")
+
+	serve(m, "def")
+	serve(m, "")
+	serve(m, "")
+	c.Check(calls, gc.Equals, 4)
+}
+
+func (s *IdempotencyTestSuite) TestMiddlewareRetriesFailures(c *gc.C) {
+	calls := 0
+	m := newMiddleware(NewStore(newScriptConnector()), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		case 2:
+			panic("test panic")
+		}
+	}))
+
+	c.Check(serve(m, "abc").Code, gc.Equals, http.StatusServiceUnavailable)
+	c.Check(func() { serve(m, "abc") }, gc.PanicMatches, "test panic")
+	c.Check(serve(m, "abc").Code, gc.Equals, http.StatusOK)
+	c.Check(serve(m, "abc").Header().Get("Idempotent-Replayed"), gc.Equals, "true")
+	c.Check(calls, gc.Equals, 3)
+}
+
+func (s *IdempotencyTestSuite) TestMiddlewareRejectsInProgress(c *gc.C) {
+	connector := newScriptConnector()
+	store := NewStore(connector)
+	m := newMiddleware(store, http.NotFoundHandler())
+	m.Lease = time.Second
+
+	r := httptest.NewRequest("POST", "/orders", nil)
+	r.Header.Set(HeaderName, "abc")
+	store.Acquire(context.Background(), KeyByHeader(r), time.Second)
+
+	c.Check(serve(m, "abc").Code, gc.Equals, http.StatusConflict)
+
+	// the lease of a crashed handler expires
+	connector.FastForward(time.Second)
+	c.Check(serve(m, "abc").Code, gc.Equals, http.StatusNotFound)
+}
+
+func (s *IdempotencyTestSuite) TestMiddlewareScopesKeys(c *gc.C) {
+	calls := 0
+	m := newMiddleware(NewStore(newScriptConnector()), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Write([]byte("order of " + r.Header.Get("Authorization")))
+	}))
+
+	c.Check(serveRequest(m, "abc", "alice", "{}").Body.String(), gc.Equals, "order of alice")
+	c.Check(serveRequest(m, "abc", "bob", "{}").Body.String(), gc.Equals, "order of bob")
+	c.Check(calls, gc.Equals, 2)
+
+	// same key with a different body
+	c.Check(serveRequest(m, "abc", "alice", `{"items": 2}`).Code, gc.Equals, http.StatusUnprocessableEntity)
+	c.Check(calls, gc.Equals, 2)
+}
+
+func (s *IdempotencyTestSuite) TestMiddlewareLogsStoreErrors(c *gc.C) {
+	var logs bytes.Buffer
+	store := NewStore(newScriptConnector())
+	m, err := NewMiddleware(store, log.New(&logs, "", 0), time.Hour, nil, http.NotFoundHandler())
+	c.Assert(err, gc.IsNil)
+
+	// the response can not be stored, the key is released
+	m.TTL = 0
+	c.Check(serve(m, "abc").Code, gc.Equals, http.StatusNotFound)
+	c.Check(logs.String(), gc.Matches, "idempotency: save response .*: idempotency: ttl must be at least 1ms\n")
+	c.Check(serve(m, "abc").Code, gc.Equals, http.StatusNotFound)
+
+	_, err = NewMiddleware(store, nil, 0, nil, http.NotFoundHandler())
+	c.Check(err, gc.Equals, ErrInvalidTTL)
+}
+
+func (s *IdempotencyTestSuite) TestMiddlewareLimitsBodySize(c *gc.C) {
+	calls := 0
+	m := newMiddleware(NewStore(newScriptConnector()), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	m.MaxBodySize = 8
+
+	c.Check(serveRequest(m, "abc", "", `{"id":1}`).Body.String(), gc.Equals, `{"id":1}`)
+	c.Check(serveRequest(m, "def", "", `{"id":100}`).Code, gc.Equals, http.StatusRequestEntityTooLarge)
+	c.Check(calls, gc.Equals, 1)
+
+	// requests without a key are not read
+	c.Check(serveRequest(m, "", "", `{"id":100}`).Body.String(), gc.Equals, `{"id":100}`)
+}
+
+func (s *IdempotencyTestSuite) TestMiddlewareFailsClosedOnDuplicates(c *gc.C) {
+	connector := newScriptConnector()
+	redisConn := connector.Connect()
+	defer redisConn.Close()
+	_, err := redisConn.Do("SET", DefaultPrefix+"order", "{")
+	c.Assert(err, gc.IsNil)
+
+	var logs bytes.Buffer
+	m := &Middleware{
+		Store:   NewStore(connector),
+		TTL:     time.Hour,
+		KeyFunc: func(*http.Request) string { return "order" },
+		Next:    http.NotFoundHandler(),
+		Logger:  log.New(&logs, "", 0),
+	}
+
+	c.Check(serve(m, "abc").Code, gc.Equals, http.StatusInternalServerError)
+	c.Check(logs.String(), gc.Matches, "idempotency: response order: .*\n")
+
+	// the logs are discarded without a Logger
+	m.Logger = nil
+	c.Check(serve(m, "abc").Code, gc.Equals, http.StatusInternalServerError)
+}
@@ -0,0 +1,262 @@
+package idempotency
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/onefootball/samodelkin/redis"
+)
+
+// HeaderName holds the request header
+// used by KeyByHeader
+const HeaderName = "Idempotency-Key"
+
+type (
+	// KeyFunc is a func type which returns
+	// the idempotency key of the request,
+	// an empty key disables the deduplication
+	KeyFunc func(r *http.Request) string
+
+	// Middleware is a struct type
+	// which implements http.Handler interface
+	// and replays the stored response of a request
+	// with an already processed idempotency key.
+	//
+	// Responses with 5xx status are not stored,
+	// so the request can be retried. Duplicates of
+	// a request which holds an in-progress Lease are
+	// rejected with 409 Conflict status. A stored response
+	// is replayed only to a request with the same fingerprint
+	// (method, path and body), 422 Unprocessable Entity
+	// status is returned otherwise. Replayed responses have
+	// Idempotent-Replayed: true header, Set-Cookie headers
+	// are never stored nor replayed.
+	// Requests are passed through if the key can not be acquired,
+	// a response which can not be stored is logged and its key
+	// is released. Duplicates fail closed: a stored response
+	// which can not be read is logged and the request is
+	// rejected with 500 Internal Server Error status.
+	// Requests with a key and a body above MaxBodySize are
+	// rejected with 413 Request Entity Too Large status
+	Middleware struct {
+		Store *Store
+		TTL   time.Duration
+		// Lease holds the in-progress lease,
+		// DefaultLease is used if zero
+		Lease time.Duration
+		// MaxBodySize holds the size limit of the
+		// fingerprinted request bodies,
+		// DefaultMaxBodySize is used if zero
+		MaxBodySize int64
+		KeyFunc     KeyFunc
+		Next        http.Handler
+		// Logger logs the store errors,
+		// the logs are discarded if nil
+		Logger *log.Logger
+	}
+
+	// responseRecorder is a http.ResponseWriter wrapper
+	// which keeps a copy of the written response
+	responseRecorder struct {
+		http.ResponseWriter
+		status int
+		body   bytes.Buffer
+	}
+)
+
+// NewMiddleware inits and returns a pointer to Middleware instance
+// which stores the responses for ttl. Requests are deduplicated
+// by KeyByHeader if keyFunc is nil, the logs are discarded
+// if logger is nil. ErrInvalidTTL is returned if ttl is shorter than 1ms
+func NewMiddleware(s *Store, logger *log.Logger, ttl time.Duration, keyFunc KeyFunc, next http.Handler) (*Middleware, error) {
+	if ttl < time.Millisecond {
+		return nil, ErrInvalidTTL
+	}
+
+	if keyFunc == nil {
+		keyFunc = KeyByHeader
+	}
+
+	return &Middleware{
+		Store:       s,
+		TTL:         ttl,
+		Lease:       DefaultLease,
+		MaxBodySize: DefaultMaxBodySize,
+		KeyFunc:     keyFunc,
+		Next:        next,
+		Logger:      logger,
+	}, nil
+}
+
+// ServeHTTP replays the stored response of a duplicate request
+// or calls the next handler and stores its response
+func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := m.KeyFunc(r)
+	if key == "" {
+		m.Next.ServeHTTP(w, r)
+		return
+	}
+
+	maxBodySize := m.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+
+	fingerprint, err := requestFingerprint(w, r, maxBodySize)
+	if err != nil {
+		status := http.StatusBadRequest
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	lease := m.Lease
+	if lease <= 0 {
+		lease = DefaultLease
+	}
+
+	token, err := m.Store.Acquire(r.Context(), key, lease)
+	if err != nil {
+		redis.LoggerOrDiscard(m.Logger).Printf("idempotency: acquire %s: %s", key, err)
+		m.Next.ServeHTTP(w, r)
+		return
+	}
+
+	if token == "" {
+		m.replay(w, r, key, fingerprint)
+		return
+	}
+
+	// the response is stored with a background context
+	// as the request one is done once the client disconnects,
+	// the key is released if the next handler panics
+	rec := &responseRecorder{ResponseWriter: w}
+	completed := false
+	defer func() {
+		if completed && rec.status < http.StatusInternalServerError {
+			header := w.Header().Clone()
+			header.Del("Set-Cookie")
+
+			err := m.Store.SaveResponse(context.Background(), key, token, &Response{
+				Status:      rec.status,
+				Header:      header,
+				Body:        rec.body.Bytes(),
+				Fingerprint: fingerprint,
+			}, m.TTL)
+			if err == nil {
+				return
+			}
+			redis.LoggerOrDiscard(m.Logger).Printf("idempotency: save response %s: %s", key, err)
+			if err == ErrLeaseLost {
+				return
+			}
+		}
+
+		if err := m.Store.Release(context.Background(), key, token); err != nil {
+			redis.LoggerOrDiscard(m.Logger).Printf("idempotency: release %s: %s", key, err)
+		}
+	}()
+
+	m.Next.ServeHTTP(rec, r)
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	completed = true
+}
+
+// KeyByHeader returns Idempotency-Key header value
+// prefixed with the request method, path and
+// Authorization header hash, so the keys of different
+// principals do not collide. Services authenticating
+// with cookies should scope the key by the principal
+// with their own KeyFunc
+func KeyByHeader(r *http.Request) string {
+	key := r.Header.Get(HeaderName)
+	if key == "" {
+		return ""
+	}
+
+	principal := sha256.Sum256([]byte(r.Header.Get("Authorization")))
+	return r.Method + " " + r.URL.Path + ":" + hex.EncodeToString(principal[:8]) + ":" + key
+}
+
+// replay writes the stored response of the key
+// if the request fingerprint matches
+func (m *Middleware) replay(w http.ResponseWriter, r *http.Request, key, fingerprint string) {
+	res, err := m.Store.Response(r.Context(), key)
+	switch err {
+	case nil:
+	case ErrInProgress, ErrNotMarked, ErrNoResponse:
+		http.Error(w, http.StatusText(http.StatusConflict), http.StatusConflict)
+		return
+	default:
+		redis.LoggerOrDiscard(m.Logger).Printf("idempotency: response %s: %s", key, err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if res.Fingerprint != fingerprint {
+		http.Error(w, http.StatusText(http.StatusUnprocessableEntity), http.StatusUnprocessableEntity)
+		return
+	}
+
+	h := w.Header()
+	for name, values := range res.Header {
+		if http.CanonicalHeaderKey(name) != "Set-Cookie" {
+			h[name] = values
+		}
+	}
+	h.Set("Idempotent-Replayed", "true")
+
+	w.WriteHeader(res.Status)
+	w.Write(res.Body)
+}
+
+// requestFingerprint returns the hash of the request
+// method, path and body, the body is restored for
+// the next handler. *http.MaxBytesError is returned
+// if the body is larger than maxBodySize
+func requestFingerprint(w http.ResponseWriter, r *http.Request, maxBodySize int64) (string, error) {
+	h := sha256.New()
+	io.WriteString(h, r.Method+" "+r.URL.Path+"\n")
+
+	if r.Body != nil {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodySize))
+		r.Body.Close()
+		if err != nil {
+			return "", err
+		}
+
+		h.Write(body)
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// WriteHeader records the status and writes it
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+// Write records and writes the body
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	rec.body.Write(b)
+	return rec.ResponseWriter.Write(b)
+}
@@ -0,0 +1,446 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// defaultJobVisibilityTimeout holds the default time
+	// a claimed job stays invisible to other workers
+	defaultJobVisibilityTimeout = time.Minute
+
+	// defaultJobPollInterval holds the default interval
+	// between claim attempts of an idle worker
+	defaultJobPollInterval = time.Second
+
+	// defaultJobCount holds the default number
+	// of jobs claimed with a single script call
+	defaultJobCount = 10
+)
+
+// ErrInvalidVisibilityTimeout is returned by Consume if the
+// job consumer visibility timeout is negative or shorter than 1ms
+var ErrInvalidVisibilityTimeout = errors.New("redis: job visibility timeout must be at least 1ms")
+
+var (
+	// scheduleJobScript stores the job payload
+	// and schedules it for the due time
+	scheduleJobScript = DefaultScripts.Register(3, `
+redis.call("HSET", KEYS[2], ARGV[1], ARGV[2])
+redis.call("HDEL", KEYS[3], ARGV[1])
+return redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+`)
+
+	// cancelJobScript removes the job
+	cancelJobScript = DefaultScripts.Register(3, `
+redis.call("HDEL", KEYS[2], ARGV[1])
+redis.call("HDEL", KEYS[3], ARGV[1])
+return redis.call("ZREM", KEYS[1], ARGV[1])
+`)
+
+	// claimJobsScript moves the due jobs to the visibility
+	// timeout and returns [id, payload, attempts] of every job
+	claimJobsScript = DefaultScripts.Register(3, `
+local ids = redis.call("ZRANGEBYSCORE", KEYS[1], "-inf", ARGV[1], "LIMIT", 0, ARGV[3])
+local jobs = {}
+for _, id in ipairs(ids) do
+	redis.call("ZADD", KEYS[1], ARGV[2], id)
+	local attempts = redis.call("HINCRBY", KEYS[3], id, 1)
+	table.insert(jobs, {id, redis.call("HGET", KEYS[2], id), attempts})
+end
+return jobs
+`)
+
+	// ackJobScript removes the job only if it
+	// has not been reclaimed or rescheduled
+	ackJobScript = DefaultScripts.Register(3, `
+if tonumber(redis.call("ZSCORE", KEYS[1], ARGV[1])) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("HDEL", KEYS[2], ARGV[1])
+redis.call("HDEL", KEYS[3], ARGV[1])
+return redis.call("ZREM", KEYS[1], ARGV[1])
+`)
+
+	// retryJobScript reschedules the job only if it
+	// has not been reclaimed or rescheduled, it also
+	// extends the visibility timeout of a claimed job
+	retryJobScript = DefaultScripts.Register(1, `
+if tonumber(redis.call("ZSCORE", KEYS[1], ARGV[1])) ~= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call("ZADD", KEYS[1], ARGV[3], ARGV[1])
+return 1
+`)
+)
+
+type (
+	// Job is a struct type
+	// which holds a scheduled job
+	Job struct {
+		// ID holds the job id, a random one
+		// is assigned by Schedule if empty
+		ID string
+		// Payload holds the job data
+		Payload []byte
+		// RunAt holds the job due time, the job is due
+		// immediately if zero. It is not set on the claimed jobs
+		RunAt time.Time
+		// Attempts holds the number of times
+		// the job has been claimed, the current one included
+		Attempts int64
+
+		// claimedUntil holds the visibility timeout
+		// score the job has been claimed with
+		claimedUntil int64
+	}
+
+	// JobHandler describes logic for
+	// processing a scheduled job
+	JobHandler interface {
+		HandleJob(*Job) error
+	}
+
+	// JobHandlerFunc implements JobHandler interface
+	JobHandlerFunc func(*Job) error
+
+	// JobQueue is a struct type
+	// which schedules jobs on top of a Connector.
+	//
+	// Job ids are kept in a sorted set under the Name key
+	// scored by the due time in milliseconds, payloads
+	// and attempt counters are kept in "<Name>:jobs" and
+	// "<Name>:attempts" hashes. With ClusterConnector
+	// use a hash tag in the queue name
+	JobQueue struct {
+		connector Connector
+
+		// Name holds the queue name
+		Name string
+	}
+
+	// JobConsumer is a struct type
+	// which processes the due jobs of a queue.
+	//
+	// A claimed job is invisible to other workers for
+	// VisibilityTimeout and is removed once the handler
+	// succeeds. Failed jobs are retried after RetryDelay,
+	// jobs of crashed workers are retried once their
+	// visibility timeout expires. Jobs claimed more than
+	// MaxAttempts times are logged and dropped
+	JobConsumer struct {
+		queue  *JobQueue
+		logger *log.Logger
+
+		Handler JobHandler
+
+		// Workers holds the number of
+		// concurrent workers, 1 is used if zero
+		Workers int
+		// Count holds the number of jobs claimed
+		// with a single script call, 10 is used if zero
+		Count int
+		// PollInterval holds the interval between claim
+		// attempts of an idle worker, 1s is used if zero
+		PollInterval time.Duration
+		// VisibilityTimeout holds the time a claimed job
+		// stays invisible to other workers, 1m is used if zero.
+		// A batch of Count jobs is claimed at once, the timeout
+		// of every job is extended right before its handler is
+		// called, so keep it above a single job processing time.
+		// Consume fails if it is negative or shorter than 1ms
+		VisibilityTimeout time.Duration
+		// RetryDelay holds the delay of a failed job retry,
+		// the job is retried after VisibilityTimeout if zero
+		RetryDelay time.Duration
+		// MaxAttempts holds the maximum number of job
+		// attempts, jobs are never dropped if zero
+		MaxAttempts int64
+	}
+
+	// JobConsumerConfig describes a job queue consumer config
+	JobConsumerConfig struct {
+		ID      string `json:"id"`
+		Queue   string `json:"queue"`
+		Workers int    `json:"workers"`
+	}
+)
+
+// HandleJob calls f(j)
+func (f JobHandlerFunc) HandleJob(j *Job) error {
+	return f(j)
+}
+
+// NewJobQueue inits and returns a pointer to JobQueue instance
+func NewJobQueue(c Connector, name string) *JobQueue {
+	return &JobQueue{connector: c, Name: name}
+}
+
+// Schedule schedules the job for RunAt time, for now if RunAt
+// is zero. A job with the same id is replaced and its attempts are reset
+func (q *JobQueue) Schedule(ctx context.Context, j *Job) error {
+	if j.ID == "" {
+		id, err := lockValue()
+		if err != nil {
+			return err
+		}
+		j.ID = id
+	}
+
+	runAt := j.RunAt
+	if runAt.IsZero() {
+		runAt = time.Now()
+	}
+
+	_, err := scheduleJobScript.Run(ctx, q.connector, q.keys(j.ID, j.Payload, millis(runAt))...)
+	return err
+}
+
+// Cancel removes the job, ok is false
+// if the job has not been scheduled
+func (q *JobQueue) Cancel(ctx context.Context, id string) (ok bool, err error) {
+	return redis.Bool(cancelJobScript.Run(ctx, q.connector, q.keys(id)...))
+}
+
+// keys returns the queue keys followed by args
+func (q *JobQueue) keys(args ...interface{}) []interface{} {
+	return append([]interface{}{q.Name, q.Name + ":jobs", q.Name + ":attempts"}, args...)
+}
+
+// NewJobConsumer inits and returns a pointer
+// to a new JobConsumer instance.
+// The logs are discarded if logger is nil
+func NewJobConsumer(c Connector, h JobHandler, logger *log.Logger, queue string) *JobConsumer {
+	return &JobConsumer{
+		queue:   NewJobQueue(c, queue),
+		logger:  LoggerOrDiscard(logger),
+		Handler: h,
+	}
+}
+
+// ConsumeJobs creates a JobConsumer for every config
+// with the handler matching the config id and processes
+// the jobs until ctx is done. The first consumer error is returned
+func ConsumeJobs(ctx context.Context, c Connector, logger *log.Logger, cfgs []JobConsumerConfig, handlers map[string]JobHandler) error {
+	consumers := make([]*JobConsumer, len(cfgs))
+	for i, cfg := range cfgs {
+		h, ok := handlers[cfg.ID]
+		if !ok {
+			return fmt.Errorf("redis: handler for id: %s not found", cfg.ID)
+		}
+
+		consumers[i] = NewJobConsumer(c, h, logger, cfg.Queue)
+		consumers[i].Workers = cfg.Workers
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(consumers))
+	for i, jc := range consumers {
+		wg.Add(1)
+		go func(i int, jc *JobConsumer) {
+			defer wg.Done()
+			errs[i] = jc.Consume(ctx)
+		}(i, jc)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Consume processes the due jobs with the workers
+// until ctx is done. Errors are logged and followed by a retry,
+// ErrInvalidVisibilityTimeout is returned if VisibilityTimeout
+// is negative or shorter than 1ms
+func (jc *JobConsumer) Consume(ctx context.Context) error {
+	if jc.VisibilityTimeout < 0 || jc.VisibilityTimeout > 0 && jc.VisibilityTimeout < time.Millisecond {
+		return ErrInvalidVisibilityTimeout
+	}
+
+	workers := jc.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jc.work(ctx)
+		}()
+	}
+
+	jc.logger.Printf("consuming from job queue: %s", jc.queue.Name)
+	wg.Wait()
+
+	return nil
+}
+
+// work claims and processes the due jobs until ctx is done,
+// idle workers wait for PollInterval between claims
+func (jc *JobConsumer) work(ctx context.Context) {
+	count := jc.Count
+	if count <= 0 {
+		count = defaultJobCount
+	}
+
+	interval := jc.PollInterval
+	if interval <= 0 {
+		interval = defaultJobPollInterval
+	}
+
+	for ctx.Err() == nil {
+		jobs, err := jc.claim(ctx, count)
+		if err != nil && ctx.Err() == nil {
+			jc.logger.Printf("redis job consumer: queue %s: %s", jc.queue.Name, err)
+		}
+
+		for _, j := range jobs {
+			jc.process(ctx, j)
+		}
+
+		if err == nil && len(jobs) == count {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(interval):
+		}
+	}
+}
+
+// visibilityTimeout returns the claimed jobs visibility timeout
+func (jc *JobConsumer) visibilityTimeout() time.Duration {
+	if jc.VisibilityTimeout <= 0 {
+		return defaultJobVisibilityTimeout
+	}
+	return jc.VisibilityTimeout
+}
+
+// claim claims up to count due jobs
+func (jc *JobConsumer) claim(ctx context.Context, count int) ([]*Job, error) {
+	now := time.Now()
+	claimedUntil := millis(now.Add(jc.visibilityTimeout()))
+
+	values, err := redis.Values(claimJobsScript.Run(ctx, jc.queue.connector, jc.queue.keys(millis(now), claimedUntil, count)...))
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]*Job, 0, len(values))
+	for _, v := range values {
+		job, err := redis.Values(v, nil)
+		if err != nil || len(job) != 3 {
+			return nil, fmt.Errorf("redis: unexpected claimed job: %v", v)
+		}
+
+		j := &Job{claimedUntil: claimedUntil}
+		if j.ID, err = redis.String(job[0], nil); err != nil {
+			return nil, err
+		}
+
+		// payload is missing if the job has been
+		// cancelled while claimed, it is kept nil
+		if j.Payload, err = redis.Bytes(job[1], nil); err != nil && err != redis.ErrNil {
+			return nil, err
+		}
+
+		if j.Attempts, err = redis.Int64(job[2], nil); err != nil {
+			return nil, err
+		}
+
+		jobs = append(jobs, j)
+	}
+
+	return jobs, nil
+}
+
+// process calls the handler and removes the job
+// on success, failed jobs are rescheduled for a retry.
+// Jobs reclaimed or rescheduled while waiting
+// in the claimed batch are skipped
+func (jc *JobConsumer) process(ctx context.Context, j *Job) {
+	if j.Payload == nil {
+		jc.ack(ctx, j)
+		return
+	}
+
+	if jc.MaxAttempts > 0 && j.Attempts > jc.MaxAttempts {
+		jc.logger.Printf("redis job consumer: queue %s job %s: exceeded %d attempts", jc.queue.Name, j.ID, jc.MaxAttempts)
+		jc.ack(ctx, j)
+		return
+	}
+
+	if !jc.extend(ctx, j) {
+		return
+	}
+
+	if err := tryCatch(func() error { return jc.Handler.HandleJob(j) }); err != nil {
+		jc.logger.Printf("redis job consumer: queue %s job %s: %s", jc.queue.Name, j.ID, err)
+		jc.retry(ctx, j)
+		return
+	}
+
+	jc.ack(ctx, j)
+}
+
+// extend restarts the job visibility timeout and reports
+// whether the job is still claimed by the consumer
+func (jc *JobConsumer) extend(ctx context.Context, j *Job) bool {
+	claimedUntil := millis(time.Now().Add(jc.visibilityTimeout()))
+	if claimedUntil == j.claimedUntil {
+		return true
+	}
+
+	extended, err := redis.Bool(retryJobScript.Run(ctx, jc.queue.connector, jc.queue.Name, j.ID, j.claimedUntil, claimedUntil))
+	if err != nil {
+		jc.logger.Printf("redis job consumer: queue %s job %s: extend: %s", jc.queue.Name, j.ID, err)
+		return false
+	}
+
+	if extended {
+		j.claimedUntil = claimedUntil
+	}
+	return extended
+}
+
+// ack removes the job unless it has been
+// reclaimed or rescheduled in the meantime
+func (jc *JobConsumer) ack(ctx context.Context, j *Job) {
+	if _, err := ackJobScript.Run(ctx, jc.queue.connector, jc.queue.keys(j.ID, j.claimedUntil)...); err != nil {
+		jc.logger.Printf("redis job consumer: queue %s job %s: ack: %s", jc.queue.Name, j.ID, err)
+	}
+}
+
+// retry reschedules the job after RetryDelay,
+// the job stays invisible until the visibility
+// timeout expires if RetryDelay is zero
+func (jc *JobConsumer) retry(ctx context.Context, j *Job) {
+	if jc.RetryDelay <= 0 {
+		return
+	}
+
+	retryAt := millis(time.Now().Add(jc.RetryDelay))
+	if _, err := retryJobScript.Run(ctx, jc.queue.connector, jc.queue.Name, j.ID, j.claimedUntil, retryAt); err != nil {
+		jc.logger.Printf("redis job consumer: queue %s job %s: retry: %s", jc.queue.Name, j.ID, err)
+	}
+}
+
+// millis returns t as unix milliseconds
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
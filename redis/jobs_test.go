@@ -0,0 +1,279 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type JobsTestSuite struct{}
+
+var _ = gc.Suite(&JobsTestSuite{})
+
+// jobStub emulates job scripts replies
+// for a single queue
+type jobStub struct {
+	mu       sync.Mutex
+	due      map[string]int64
+	payloads map[string][]byte
+	attempts map[string]int64
+}
+
+func newJobStub() *jobStub {
+	return &jobStub{
+		due:      make(map[string]int64),
+		payloads: make(map[string][]byte),
+		attempts: make(map[string]int64),
+	}
+}
+
+func (s *jobStub) do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd != "EVALSHA" {
+		return nil, redis.Error("ERR unexpected command")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// args: hash, numkeys, keys..., argv...
+	argv := args[2+args[1].(int):]
+	id, _ := argv[0].(string)
+
+	switch args[0] {
+	case scheduleJobScript.Hash():
+		s.payloads[id] = argv[1].([]byte)
+		delete(s.attempts, id)
+		s.due[id] = argv[2].(int64)
+		return int64(1), nil
+	case cancelJobScript.Hash():
+		_, ok := s.due[id]
+		delete(s.due, id)
+		delete(s.payloads, id)
+		delete(s.attempts, id)
+		if !ok {
+			return int64(0), nil
+		}
+		return int64(1), nil
+	case claimJobsScript.Hash():
+		now, claimedUntil, count := argv[0].(int64), argv[1].(int64), argv[2].(int)
+
+		var ids []string
+		for id, score := range s.due {
+			if score <= now {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		if len(ids) > count {
+			ids = ids[:count]
+		}
+
+		jobs := make([]interface{}, len(ids))
+		for i, id := range ids {
+			s.due[id] = claimedUntil
+			s.attempts[id]++
+
+			var payload interface{}
+			if p, ok := s.payloads[id]; ok {
+				payload = p
+			}
+			jobs[i] = []interface{}{[]byte(id), payload, s.attempts[id]}
+		}
+		return jobs, nil
+	case ackJobScript.Hash(), retryJobScript.Hash():
+		if score, ok := s.due[id]; !ok || score != argv[1].(int64) {
+			return int64(0), nil
+		}
+
+		if args[0] == retryJobScript.Hash() {
+			s.due[id] = argv[2].(int64)
+			return int64(1), nil
+		}
+
+		delete(s.due, id)
+		delete(s.payloads, id)
+		delete(s.attempts, id)
+		return int64(1), nil
+	}
+
+	return nil, redis.Error("NOSCRIPT No matching script")
+}
+
+func (s *jobStub) scheduled() map[string]int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := make(map[string]int64, len(s.due))
+	for id, score := range s.due {
+		due[id] = score
+	}
+	return due
+}
+
+func (s *JobsTestSuite) TestScheduleAndConsume(c *gc.C) {
+	stub := newJobStub()
+	connector := newStubConnector(stub.do)
+	ctx := context.Background()
+
+	queue := NewJobQueue(connector, "notifications")
+	kickoff := time.Now().Add(time.Hour)
+	c.Assert(queue.Schedule(ctx, &Job{ID: "match:1", Payload: []byte("lineups"), RunAt: time.Now().Add(-time.Second)}), gc.IsNil)
+	c.Assert(queue.Schedule(ctx, &Job{ID: "match:2", Payload: []byte("kickoff"), RunAt: kickoff}), gc.IsNil)
+
+	generated := &Job{Payload: []byte("reminder"), RunAt: time.Now()}
+	c.Assert(queue.Schedule(ctx, generated), gc.IsNil)
+	c.Check(generated.ID, gc.HasLen, 32)
+
+	var mu sync.Mutex
+	var handled []string
+	consumer := NewJobConsumer(connector, JobHandlerFunc(func(j *Job) error {
+		mu.Lock()
+		handled = append(handled, j.ID+":"+string(j.Payload)+":"+strconv.FormatInt(j.Attempts, 10))
+		mu.Unlock()
+		return nil
+	}), nil, "notifications")
+	consumer.Workers = 2
+	consumer.PollInterval = 10 * time.Millisecond
+
+	consumeCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	c.Check(consumer.Consume(consumeCtx), gc.IsNil)
+
+	sort.Strings(handled)
+	c.Check(handled, gc.DeepEquals, []string{generated.ID + ":reminder:1", "match:1:lineups:1"})
+	c.Check(stub.scheduled(), gc.DeepEquals, map[string]int64{"match:2": millis(kickoff)})
+
+	ok, err := queue.Cancel(ctx, "match:2")
+	c.Assert(err, gc.IsNil)
+	c.Check(ok, gc.Equals, true)
+	c.Check(stub.scheduled(), gc.HasLen, 0)
+}
+
+func (s *JobsTestSuite) TestRetryAndMaxAttempts(c *gc.C) {
+	stub := newJobStub()
+	connector := newStubConnector(stub.do)
+
+	queue := NewJobQueue(connector, "notifications")
+	c.Assert(queue.Schedule(context.Background(), &Job{ID: "match:1", Payload: []byte("kickoff")}), gc.IsNil)
+
+	var logs bytes.Buffer
+	var attempts []int64
+	consumer := NewJobConsumer(connector, JobHandlerFunc(func(j *Job) error {
+		attempts = append(attempts, j.Attempts)
+		if j.Attempts == 1 {
+			panic("test panic")
+		}
+		return errors.New("test error")
+	}), log.New(&logs, "", 0), "notifications")
+	consumer.PollInterval = 5 * time.Millisecond
+	consumer.RetryDelay = time.Millisecond
+	consumer.MaxAttempts = 2
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	c.Check(consumer.Consume(ctx), gc.IsNil)
+
+	c.Check(attempts, gc.DeepEquals, []int64{1, 2})
+	c.Check(stub.scheduled(), gc.HasLen, 0)
+	c.Check(logs.String(), gc.Equals, "consuming from job queue: notifications\n"+
+		"redis job consumer: queue notifications job match:1: panic: test panic\n"+
+		"redis job consumer: queue notifications job match:1: test error\n"+
+		"redis job consumer: queue notifications job match:1: exceeded 2 attempts\n")
+}
+
+func (s *JobsTestSuite) TestRescheduledJobIsKept(c *gc.C) {
+	stub := newJobStub()
+	connector := newStubConnector(stub.do)
+	ctx := context.Background()
+
+	queue := NewJobQueue(connector, "notifications")
+	c.Assert(queue.Schedule(ctx, &Job{ID: "match:1", Payload: []byte("kickoff")}), gc.IsNil)
+
+	consumer := NewJobConsumer(connector, JobHandlerFunc(func(j *Job) error {
+		// kickoff has been postponed while processing
+		return queue.Schedule(ctx, &Job{ID: j.ID, Payload: j.Payload, RunAt: time.Unix(1700000000, 0)})
+	}), log.New(&bytes.Buffer{}, "", 0), "notifications")
+
+	jobs, err := consumer.claim(ctx, 10)
+	c.Assert(err, gc.IsNil)
+	c.Assert(jobs, gc.HasLen, 1)
+
+	consumer.process(ctx, jobs[0])
+	c.Check(stub.scheduled(), gc.DeepEquals, map[string]int64{"match:1": 1700000000000})
+}
+
+func (s *JobsTestSuite) TestConsumeJobsHandlerNotFound(c *gc.C) {
+	err := ConsumeJobs(context.Background(), newStubConnector(newJobStub().do), nil, []JobConsumerConfig{
+		{ID: "notifications", Queue: "notifications", Workers: 2},
+	}, map[string]JobHandler{})
+	c.Check(err, gc.ErrorMatches, "redis: handler for id: notifications not found")
+}
+
+func (s *JobsTestSuite) TestScheduleZeroRunAtIsDueNow(c *gc.C) {
+	stub := newJobStub()
+	queue := NewJobQueue(newStubConnector(stub.do), "notifications")
+
+	before := millis(time.Now())
+	c.Assert(queue.Schedule(context.Background(), &Job{ID: "match:1"}), gc.IsNil)
+
+	due := stub.scheduled()["match:1"]
+	c.Check(due >= before && due <= millis(time.Now()), gc.Equals, true, gc.Commentf("due at %d", due))
+}
+
+func (s *JobsTestSuite) TestInvalidVisibilityTimeout(c *gc.C) {
+	consumer := NewJobConsumer(newStubConnector(newJobStub().do), JobHandlerFunc(func(*Job) error {
+		return nil
+	}), nil, "notifications")
+
+	for _, timeout := range []time.Duration{-time.Second, time.Microsecond} {
+		consumer.VisibilityTimeout = timeout
+		c.Check(consumer.Consume(context.Background()), gc.Equals, ErrInvalidVisibilityTimeout)
+	}
+}
+
+func (s *JobsTestSuite) TestBatchedJobsVisibilityIsExtended(c *gc.C) {
+	stub := newJobStub()
+	connector := newStubConnector(stub.do)
+	ctx := context.Background()
+
+	queue := NewJobQueue(connector, "notifications")
+	for _, id := range []string{"match:1", "match:2", "match:3"} {
+		c.Assert(queue.Schedule(ctx, &Job{ID: id, Payload: []byte("kickoff"), RunAt: time.Now().Add(-time.Second)}), gc.IsNil)
+	}
+
+	var handled []string
+	consumer := NewJobConsumer(connector, JobHandlerFunc(func(j *Job) error {
+		handled = append(handled, j.ID)
+		return errors.New("test error")
+	}), nil, "notifications")
+	consumer.VisibilityTimeout = time.Hour
+
+	jobs, err := consumer.claim(ctx, 10)
+	c.Assert(err, gc.IsNil)
+	c.Assert(jobs, gc.HasLen, 3)
+
+	// match:2 timed out in the batch and has been reclaimed by another worker
+	stub.mu.Lock()
+	stub.due["match:2"]++
+	stub.mu.Unlock()
+
+	time.Sleep(2 * time.Millisecond)
+	for _, j := range jobs {
+		consumer.process(ctx, j)
+	}
+
+	c.Check(handled, gc.DeepEquals, []string{"match:1", "match:3"})
+
+	// the failed jobs stay invisible for the timeout from their handling
+	due := stub.scheduled()
+	c.Check(due["match:1"] > due["match:2"], gc.Equals, true)
+	c.Check(jobs[0].claimedUntil, gc.Equals, due["match:1"])
+}
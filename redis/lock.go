@@ -0,0 +1,206 @@
+package redis
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	mrand "math/rand"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var (
+	// ErrLockNotAcquired is returned when the lock
+	// is held by another owner
+	ErrLockNotAcquired = errors.New("redis: lock not acquired")
+
+	// ErrLockNotHeld is returned on release or extension
+	// of a lock which has expired or is held by another owner
+	ErrLockNotHeld = errors.New("redis: lock not held")
+
+	// ErrInvalidLockTTL is returned if the lock ttl
+	// is shorter than a millisecond
+	ErrInvalidLockTTL = errors.New("redis: lock ttl must be at least 1ms")
+)
+
+// defaultLockRetryInterval holds the default
+// interval between blocking lock acquire attempts
+const defaultLockRetryInterval = 50 * time.Millisecond
+
+var (
+	// acquireScript sets the lock key if it does not exist
+	// and increments the fencing token counter
+	acquireScript = DefaultScripts.Register(2, `
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return false
+`)
+
+	// releaseScript deletes the lock key
+	// only if it is held by the owner
+	releaseScript = DefaultScripts.Register(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+	// extendScript updates the lock key ttl
+	// only if it is held by the owner
+	extendScript = DefaultScripts.Register(1, `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0
+`)
+)
+
+type (
+	// Locker is a struct type
+	// which provides distributed locks
+	// on top of a Connector.
+	//
+	// Every acquired lock gets a fencing token: a number
+	// which is incremented on every acquisition of the key.
+	// Token is meant to be passed to the protected resource,
+	// which rejects operations with a token lower than
+	// the last seen one.
+	//
+	// Fencing token counter is kept under the "{<key>}:fence" key,
+	// which shares the lock key hash slot on redis cluster.
+	// The counter of a key with a hash tag is kept under
+	// "<key>:fence", a key holding '{' without a hash tag
+	// can not be locked with redis cluster
+	Locker struct {
+		connector Connector
+
+		// RetryInterval holds the interval between attempts
+		// of a blocking Lock, 50ms is used if zero.
+		// Up to a half of the interval is added as a jitter
+		RetryInterval time.Duration
+	}
+
+	// Lock is a struct type
+	// which holds an acquired lock
+	Lock struct {
+		locker *Locker
+		key    string
+		value  string
+
+		// Token holds the lock fencing token
+		Token int64
+	}
+)
+
+// NewLocker inits and returns a pointer to Locker instance
+func NewLocker(c Connector) *Locker {
+	return &Locker{connector: c}
+}
+
+// TryLock acquires the lock for the key with ttl.
+// ErrLockNotAcquired is returned if the lock is held,
+// ErrInvalidLockTTL if ttl is shorter than 1ms
+func (l *Locker) TryLock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	if ttl < time.Millisecond {
+		return nil, ErrInvalidLockTTL
+	}
+
+	value, err := lockValue()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := redis.Int64(acquireScript.Run(ctx, l.connector, key, fenceKey(key), value, int64(ttl/time.Millisecond)))
+	if err == redis.ErrNil {
+		return nil, ErrLockNotAcquired
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Lock{locker: l, key: key, value: value, Token: token}, nil
+}
+
+// Lock acquires the lock for the key with ttl
+// retrying until the lock is acquired or ctx is done
+func (l *Locker) Lock(ctx context.Context, key string, ttl time.Duration) (*Lock, error) {
+	interval := l.RetryInterval
+	if interval <= 0 {
+		interval = defaultLockRetryInterval
+	}
+
+	for {
+		lock, err := l.TryLock(ctx, key, ttl)
+		if err != ErrLockNotAcquired {
+			return lock, err
+		}
+
+		jitter := time.Duration(mrand.Int63n(int64(interval)/2 + 1))
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval + jitter):
+		}
+	}
+}
+
+// Key returns the lock key
+func (lk *Lock) Key() string {
+	return lk.key
+}
+
+// Unlock releases the lock.
+// ErrLockNotHeld is returned if the lock has expired
+// or has been acquired by another owner
+func (lk *Lock) Unlock(ctx context.Context) error {
+	return lk.run(ctx, releaseScript, lk.key, lk.value)
+}
+
+// Extend sets the lock ttl to a new value.
+// ErrLockNotHeld is returned if the lock has expired
+// or has been acquired by another owner
+func (lk *Lock) Extend(ctx context.Context, ttl time.Duration) error {
+	if ttl < time.Millisecond {
+		return ErrInvalidLockTTL
+	}
+
+	return lk.run(ctx, extendScript, lk.key, lk.value, int64(ttl/time.Millisecond))
+}
+
+// run executes an owner checking script,
+// zero reply is converted into ErrLockNotHeld
+func (lk *Lock) run(ctx context.Context, script *Script, keysAndArgs ...interface{}) error {
+	n, err := redis.Int(script.Run(ctx, lk.locker.connector, keysAndArgs...))
+	if err != nil {
+		return err
+	}
+
+	if n == 0 {
+		return ErrLockNotHeld
+	}
+
+	return nil
+}
+
+// fenceKey returns the fencing token counter key
+// of the lock key, both hash to the same cluster slot
+func fenceKey(key string) string {
+	if strings.Contains(key, "{") {
+		return key + ":fence"
+	}
+	return "{" + key + "}:fence"
+}
+
+// lockValue returns a random lock owner value
+func lockValue() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type LockTestSuite struct{}
+
+var _ = gc.Suite(&LockTestSuite{})
+
+// lockStub emulates lock scripts replies
+// for a single lock key
+type lockStub struct {
+	mu    sync.Mutex
+	owner string
+	token int64
+}
+
+func (l *lockStub) do(cmd string, args ...interface{}) (interface{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if cmd != "EVALSHA" {
+		return nil, redis.Error("ERR unexpected command")
+	}
+
+	switch args[0] {
+	case acquireScript.Hash():
+		if l.owner != "" {
+			return nil, nil
+		}
+		l.owner = args[4].(string)
+		l.token++
+		return l.token, nil
+	case releaseScript.Hash(), extendScript.Hash():
+		if l.owner != args[3] {
+			return int64(0), nil
+		}
+		if args[0] == releaseScript.Hash() {
+			l.owner = ""
+		}
+		return int64(1), nil
+	}
+
+	return nil, redis.Error("NOSCRIPT No matching script")
+}
+
+func (s *LockTestSuite) TestTryLockUnlock(c *gc.C) {
+	ctx := context.Background()
+	locker := NewLocker(newStubConnector((&lockStub{}).do))
+
+	lock, err := locker.TryLock(ctx, "match:1", time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(lock.Key(), gc.Equals, "match:1")
+	c.Check(lock.Token, gc.Equals, int64(1))
+
+	_, err = locker.TryLock(ctx, "match:1", time.Second)
+	c.Check(err, gc.Equals, ErrLockNotAcquired)
+
+	c.Check(lock.Extend(ctx, time.Second), gc.IsNil)
+	c.Check(lock.Unlock(ctx), gc.IsNil)
+	c.Check(lock.Unlock(ctx), gc.Equals, ErrLockNotHeld)
+
+	lock, err = locker.TryLock(ctx, "match:1", time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(lock.Token, gc.Equals, int64(2))
+}
+
+func (s *LockTestSuite) TestLockContextDeadline(c *gc.C) {
+	locker := NewLocker(newStubConnector((&lockStub{}).do))
+	locker.RetryInterval = 5 * time.Millisecond
+
+	_, err := locker.TryLock(context.Background(), "match:2", time.Second)
+	c.Assert(err, gc.IsNil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err = locker.Lock(ctx, "match:2", time.Second)
+	c.Check(err, gc.Equals, context.DeadlineExceeded)
+}
+
+func (s *LockTestSuite) TestInvalidTTL(c *gc.C) {
+	ctx := context.Background()
+	locker := NewLocker(newStubConnector((&lockStub{}).do))
+
+	_, err := locker.Lock(ctx, "match:1", time.Microsecond)
+	c.Check(err, gc.Equals, ErrInvalidLockTTL)
+
+	lock, err := locker.TryLock(ctx, "match:1", time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(lock.Extend(ctx, 0), gc.Equals, ErrInvalidLockTTL)
+}
+
+func (s *LockTestSuite) TestFenceKeySharesSlot(c *gc.C) {
+	for _, key := range []string{"job", "lock:match:1", "{job}:lock", "lock:{match}:1"} {
+		c.Check(HashSlot(fenceKey(key)), gc.Equals, HashSlot(key), gc.Commentf("key %s", key))
+	}
+	c.Check(fenceKey("job"), gc.Equals, "{job}:fence")
+	c.Check(fenceKey("{job}:lock"), gc.Equals, "{job}:lock:fence")
+}
@@ -0,0 +1,21 @@
+// Package msgpack provides a MessagePack
+// codec for the redis package cache
+package msgpack
+
+import (
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec implements redis.Codec interface
+// with github.com/vmihailenco/msgpack package
+type Codec struct{}
+
+// Marshal returns MessagePack encoding of v
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal parses MessagePack encoded b and stores the result in v
+func (Codec) Unmarshal(b []byte, v interface{}) error {
+	return msgpack.Unmarshal(b, v)
+}
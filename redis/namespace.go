@@ -0,0 +1,707 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+type (
+	// NamespaceConnector is a struct type
+	// which implements a Connector interface
+	// and prefixes the command keys with Namespace,
+	// so multiple services can share a redis server.
+	//
+	// Keys are prefixed for the commands of namespaceCommands
+	// table: string, key, hash, list, set, sorted set,
+	// hyperloglog, geo and stream commands, multi-key
+	// commands and scripts KEYS. KEYS and SCAN patterns are
+	// limited to the namespace and the namespace is stripped
+	// from the keys of KEYS, SCAN, blocking pop, XREAD and
+	// XREADGROUP replies. Keyless commands (PING, INFO,
+	// pub/sub, SCRIPT, ...) are sent as is, the other commands
+	// fail with ErrNamespaceCommand without being sent.
+	// Scripts must not build key names on their own
+	NamespaceConnector struct {
+		connector Connector
+
+		// Namespace holds the keys prefix, e.g. "scores:"
+		Namespace string
+	}
+
+	// namespaceConn is a redis.ConnWithTimeout wrapper
+	// which prefixes the command keys
+	namespaceConn struct {
+		redis.Conn
+		prefix string
+
+		// pending holds the reply funcs of the sent commands,
+		// queued holds the ones of the commands queued
+		// within a MULTI transaction
+		pending []replyFunc
+		queued  []replyFunc
+		multi   bool
+
+		// aborted holds the error of a command
+		// rejected within a MULTI transaction
+		aborted error
+	}
+
+	// keysFunc is a func type which returns
+	// the positions of the command keys and an
+	// error if they can not be determined
+	keysFunc func(args []interface{}) ([]int, error)
+
+	// replyFunc is a func type which
+	// strips the namespace from a reply
+	replyFunc func(prefix string, reply interface{}) interface{}
+
+	// namespaceCommand is a struct type
+	// which describes the command keys and reply
+	namespaceCommand struct {
+		keys  keysFunc
+		reply replyFunc
+	}
+)
+
+// ErrNamespaceCommand is returned by the namespaced connections
+// for the commands with unknown keys positions
+var ErrNamespaceCommand = errors.New("redis: command is not supported by namespace")
+
+var (
+	// singleKey is used by the commands
+	// with the key as the first argument
+	singleKey = namespaceCommand{keys: keyAt(0)}
+
+	// keyless is used by the commands without keys
+	keyless = namespaceCommand{}
+
+	// namespaceCommands maps the command names
+	// to their keys positions and reply funcs,
+	// cluster connections route the commands
+	// by the first key position
+	namespaceCommands = map[string]namespaceCommand{
+		"DEL":    {keys: allKeys},
+		"UNLINK": {keys: allKeys},
+		"EXISTS": {keys: allKeys},
+		"TOUCH":  {keys: allKeys},
+		"WATCH":  {keys: allKeys},
+		"MGET":   {keys: allKeys},
+		"MSET":   {keys: pairKeys},
+		"MSETNX": {keys: pairKeys},
+
+		"RENAME":   {keys: keyAt(0, 1)},
+		"RENAMENX": {keys: keyAt(0, 1)},
+		"COPY":     {keys: keyAt(0, 1)},
+		"LCS":      {keys: keyAt(0, 1)},
+		"OBJECT":   {keys: keyAt(1)},
+		"MEMORY":   {keys: keyAt(1)},
+		"BITOP":    {keys: keysFrom(1)},
+		"SORT":     {keys: sortKeys},
+		"SORT_RO":  {keys: sortKeys},
+
+		"SMOVE":       {keys: keyAt(0, 1)},
+		"SDIFF":       {keys: allKeys},
+		"SDIFFSTORE":  {keys: allKeys},
+		"SINTER":      {keys: allKeys},
+		"SINTERSTORE": {keys: allKeys},
+		"SUNION":      {keys: allKeys},
+		"SUNIONSTORE": {keys: allKeys},
+		"SINTERCARD":  {keys: numKeys(0)},
+
+		"RPOPLPUSH":  {keys: keyAt(0, 1)},
+		"LMOVE":      {keys: keyAt(0, 1)},
+		"BLMOVE":     {keys: keyAt(0, 1)},
+		"BRPOPLPUSH": {keys: keyAt(0, 1)},
+		"BLPOP":      {keys: allButLastKeys, reply: stripFirst},
+		"BRPOP":      {keys: allButLastKeys, reply: stripFirst},
+		"LMPOP":      {keys: numKeys(0), reply: stripFirst},
+		"BLMPOP":     {keys: numKeys(1), reply: stripFirst},
+
+		"ZUNION":      {keys: numKeys(0)},
+		"ZINTER":      {keys: numKeys(0)},
+		"ZDIFF":       {keys: numKeys(0)},
+		"ZINTERCARD":  {keys: numKeys(0)},
+		"ZUNIONSTORE": {keys: numKeys(1, 0)},
+		"ZINTERSTORE": {keys: numKeys(1, 0)},
+		"ZDIFFSTORE":  {keys: numKeys(1, 0)},
+		"ZRANGESTORE": {keys: keyAt(0, 1)},
+		"BZPOPMIN":    {keys: allButLastKeys, reply: stripFirst},
+		"BZPOPMAX":    {keys: allButLastKeys, reply: stripFirst},
+		"ZMPOP":       {keys: numKeys(0), reply: stripFirst},
+		"BZMPOP":      {keys: numKeys(1), reply: stripFirst},
+
+		"PFCOUNT":              {keys: allKeys},
+		"PFMERGE":              {keys: allKeys},
+		"GEOSEARCHSTORE":       {keys: keyAt(0, 1)},
+		"GEORADIUS":            {keys: optionKeys("STORE", "STOREDIST")},
+		"GEORADIUSBYMEMBER":    {keys: optionKeys("STORE", "STOREDIST")},
+		"GEORADIUS_RO":         singleKey,
+		"GEORADIUSBYMEMBER_RO": singleKey,
+
+		"XREAD":      {keys: streamKeys, reply: stripStreams},
+		"XREADGROUP": {keys: streamKeys, reply: stripStreams},
+		"XGROUP":     {keys: keyAt(1)},
+		"XINFO":      {keys: keyAt(1)},
+
+		"EVAL":       {keys: numKeys(1)},
+		"EVALSHA":    {keys: numKeys(1)},
+		"EVAL_RO":    {keys: numKeys(1)},
+		"EVALSHA_RO": {keys: numKeys(1)},
+		"FCALL":      {keys: numKeys(1)},
+		"FCALL_RO":   {keys: numKeys(1)},
+	}
+)
+
+func init() {
+	for _, cmd := range []string{
+		// strings and keys
+		"APPEND", "BITCOUNT", "BITFIELD", "BITFIELD_RO", "BITPOS", "DECR", "DECRBY",
+		"DUMP", "EXPIRE", "EXPIREAT", "EXPIRETIME", "GET", "GETBIT", "GETDEL", "GETEX",
+		"GETRANGE", "GETSET", "INCR", "INCRBY", "INCRBYFLOAT", "PERSIST", "PEXPIRE",
+		"PEXPIREAT", "PEXPIRETIME", "PSETEX", "PTTL", "RESTORE", "SET", "SETBIT",
+		"SETEX", "SETNX", "SETRANGE", "STRLEN", "SUBSTR", "TTL", "TYPE",
+		// hashes
+		"HDEL", "HEXISTS", "HGET", "HGETALL", "HINCRBY", "HINCRBYFLOAT", "HKEYS",
+		"HLEN", "HMGET", "HMSET", "HRANDFIELD", "HSCAN", "HSET", "HSETNX", "HSTRLEN", "HVALS",
+		// lists
+		"LINDEX", "LINSERT", "LLEN", "LPOP", "LPOS", "LPUSH", "LPUSHX", "LRANGE",
+		"LREM", "LSET", "LTRIM", "RPOP", "RPUSH", "RPUSHX",
+		// sets
+		"SADD", "SCARD", "SISMEMBER", "SMEMBERS", "SMISMEMBER", "SPOP",
+		"SRANDMEMBER", "SREM", "SSCAN",
+		// sorted sets
+		"ZADD", "ZCARD", "ZCOUNT", "ZINCRBY", "ZLEXCOUNT", "ZMSCORE", "ZPOPMAX",
+		"ZPOPMIN", "ZRANDMEMBER", "ZRANGE", "ZRANGEBYLEX", "ZRANGEBYSCORE", "ZRANK",
+		"ZREM", "ZREMRANGEBYLEX", "ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZREVRANGE",
+		"ZREVRANGEBYLEX", "ZREVRANGEBYSCORE", "ZREVRANK", "ZSCAN", "ZSCORE",
+		// hyperloglogs and geo
+		"PFADD", "GEOADD", "GEODIST", "GEOHASH", "GEOPOS", "GEOSEARCH",
+		// streams
+		"XACK", "XADD", "XAUTOCLAIM", "XCLAIM", "XDEL", "XLEN", "XPENDING",
+		"XRANGE", "XREVRANGE", "XSETID", "XTRIM",
+	} {
+		namespaceCommands[cmd] = singleKey
+	}
+
+	for _, cmd := range []string{
+		// connection and server, an empty command
+		// flushes and receives the pending replies
+		"", "AUTH", "CLIENT", "COMMAND", "DBSIZE", "ECHO", "HELLO", "INFO",
+		"LASTSAVE", "PING", "QUIT", "READONLY", "READWRITE", "RESET", "ROLE",
+		"SELECT", "TIME", "UNWATCH", "WAIT",
+		// pub/sub channels are not namespaced
+		"PSUBSCRIBE", "PUBLISH", "PUBSUB", "PUNSUBSCRIBE", "SPUBLISH",
+		"SSUBSCRIBE", "SUBSCRIBE", "SUNSUBSCRIBE", "UNSUBSCRIBE",
+		// scripts and functions
+		"FUNCTION", "SCRIPT",
+	} {
+		namespaceCommands[cmd] = keyless
+	}
+}
+
+// NewNamespaceConnector inits and returns a pointer
+// to NamespaceConnector instance which prefixes
+// the keys of c connections with namespace
+func NewNamespaceConnector(c Connector, namespace string) *NamespaceConnector {
+	return &NamespaceConnector{connector: c, Namespace: namespace}
+}
+
+// Connect returns a namespaced connection retrieved with Connect
+func (nc *NamespaceConnector) Connect() redis.Conn {
+	return nc.wrap(nc.connector.Connect())
+}
+
+// ConnectContext returns a namespaced connection
+// retrieved with ConnectContext
+func (nc *NamespaceConnector) ConnectContext(ctx context.Context) (redis.Conn, error) {
+	redisConn, err := ConnectContext(ctx, nc.connector)
+	if err != nil {
+		return nil, err
+	}
+	return nc.wrap(redisConn), nil
+}
+
+// PingConnect returns a namespaced connection
+// retrieved with PingConnect along with the ping error
+func (nc *NamespaceConnector) PingConnect() (redis.Conn, error) {
+	redisConn, err := nc.connector.PingConnect()
+	if redisConn == nil {
+		return nil, err
+	}
+	return nc.wrap(redisConn), err
+}
+
+// EachPool calls fn with a namespaced connection of every
+// pool if the wrapped connector implements PoolConnector
+// interface, with a single connection otherwise
+func (nc *NamespaceConnector) EachPool(ctx context.Context, fn func(redis.Conn) error) error {
+	if pc, ok := nc.connector.(PoolConnector); ok {
+		return pc.EachPool(ctx, func(redisConn redis.Conn) error {
+			return fn(nc.wrap(redisConn))
+		})
+	}
+
+	redisConn, err := nc.ConnectContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer redisConn.Close()
+
+	return fn(redisConn)
+}
+
+// wrap returns a namespaced connection
+func (nc *NamespaceConnector) wrap(c redis.Conn) redis.Conn {
+	return &namespaceConn{Conn: c, prefix: nc.Namespace}
+}
+
+// Do executes the command with the namespaced keys
+func (c *namespaceConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	cmd, args, reply, err := c.prepare(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// Do receives all the pending replies
+	c.pending = nil
+
+	r, err := c.Conn.Do(cmd, args...)
+	return c.strip(reply, r, err)
+}
+
+// DoWithTimeout executes the command with
+// the namespaced keys and the read timeout
+func (c *namespaceConn) DoWithTimeout(timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	cmd, args, reply, err := c.prepare(cmd, args)
+	if err != nil {
+		return nil, err
+	}
+	c.pending = nil
+
+	r, err := redis.DoWithTimeout(c.Conn, timeout, cmd, args...)
+	return c.strip(reply, r, err)
+}
+
+// Send buffers the command with the namespaced keys
+func (c *namespaceConn) Send(cmd string, args ...interface{}) error {
+	cmd, args, reply, err := c.prepare(cmd, args)
+	if err != nil {
+		return err
+	}
+	c.pending = append(c.pending, reply)
+
+	return c.Conn.Send(cmd, args...)
+}
+
+// Receive reads the next reply
+// stripping the namespace from it
+func (c *namespaceConn) Receive() (interface{}, error) {
+	r, err := c.Conn.Receive()
+	return c.strip(c.next(), r, err)
+}
+
+// ReceiveWithTimeout reads the next reply with the read
+// timeout stripping the namespace from it
+func (c *namespaceConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	r, err := redis.ReceiveWithTimeout(c.Conn, timeout)
+	return c.strip(c.next(), r, err)
+}
+
+// prepare returns the command with the namespaced keys
+// and its reply func, the reply funcs of the commands
+// within MULTI are applied to EXEC reply. Unknown commands
+// are rejected, a transaction with a rejected command
+// is discarded instead of EXEC
+func (c *namespaceConn) prepare(cmd string, args []interface{}) (string, []interface{}, replyFunc, error) {
+	name := strings.ToUpper(cmd)
+	switch name {
+	case "MULTI":
+		c.multi, c.queued, c.aborted = true, nil, nil
+		return cmd, args, nil, nil
+	case "DISCARD":
+		c.multi, c.queued, c.aborted = false, nil, nil
+		return cmd, args, nil, nil
+	case "EXEC":
+		queued, aborted := c.queued, c.aborted
+		c.multi, c.queued, c.aborted = false, nil, nil
+		if aborted != nil {
+			return "DISCARD", nil, abortReply(aborted), nil
+		}
+		return cmd, args, execReply(queued), nil
+	}
+
+	var reply replyFunc
+	switch name {
+	case "KEYS":
+		args, reply = c.prefixPattern(args, 0), stripAll
+	case "SCAN":
+		args, reply = c.scanArgs(args), stripScan
+	default:
+		nsCmd, ok := namespaceCommands[name]
+		if !ok {
+			err := fmt.Errorf("%w: %s", ErrNamespaceCommand, cmd)
+			if c.multi {
+				c.aborted = err
+			}
+			return cmd, args, nil, err
+		}
+
+		if nsCmd.keys != nil {
+			idx, err := nsCmd.keys(args)
+			if err != nil {
+				err = fmt.Errorf("%w: %s: %s", ErrNamespaceCommand, cmd, err)
+				if c.multi {
+					c.aborted = err
+				}
+				return cmd, args, nil, err
+			}
+			args = c.prefixKeys(args, idx)
+		}
+		reply = nsCmd.reply
+	}
+
+	if c.multi {
+		c.queued = append(c.queued, reply)
+		return cmd, args, nil, nil
+	}
+
+	return cmd, args, reply, nil
+}
+
+// next pops the reply func of the oldest sent command,
+// pushed messages of pub/sub connections have none
+func (c *namespaceConn) next() replyFunc {
+	if len(c.pending) == 0 {
+		return nil
+	}
+
+	reply := c.pending[0]
+	c.pending = c.pending[1:]
+	return reply
+}
+
+// strip applies the reply func if any,
+// a redis.Error returned by the reply func
+// is returned as the error
+func (c *namespaceConn) strip(reply replyFunc, r interface{}, err error) (interface{}, error) {
+	if reply == nil || r == nil || err != nil {
+		return r, err
+	}
+
+	r = reply(c.prefix, r)
+	if e, ok := r.(redis.Error); ok {
+		return nil, e
+	}
+	return r, nil
+}
+
+// prefixKeys returns a copy of args
+// with the keys at idx positions prefixed
+func (c *namespaceConn) prefixKeys(args []interface{}, idx []int) []interface{} {
+	if len(idx) == 0 {
+		return args
+	}
+
+	prefixed := append([]interface{}(nil), args...)
+	for _, i := range idx {
+		if i < len(prefixed) {
+			prefixed[i] = c.prefixKey(prefixed[i])
+		}
+	}
+	return prefixed
+}
+
+// prefixKey returns the key with the namespace
+func (c *namespaceConn) prefixKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case string:
+		return c.prefix + k
+	case []byte:
+		return append([]byte(c.prefix), k...)
+	}
+	return c.prefix + fmt.Sprint(key)
+}
+
+// prefixPattern returns a copy of args with the pattern
+// at position i limited to the namespace
+func (c *namespaceConn) prefixPattern(args []interface{}, i int) []interface{} {
+	if i >= len(args) {
+		return args
+	}
+
+	pattern := fmt.Sprint(args[i])
+	if b, ok := args[i].([]byte); ok {
+		pattern = string(b)
+	}
+
+	prefixed := append([]interface{}(nil), args...)
+	prefixed[i] = escapePattern(c.prefix) + pattern
+	return prefixed
+}
+
+// scanArgs returns SCAN args with MATCH pattern limited
+// to the namespace, MATCH is added if missing
+func (c *namespaceConn) scanArgs(args []interface{}) []interface{} {
+	for i := 1; i < len(args)-1; i++ {
+		if argString(args[i]) == "MATCH" {
+			return c.prefixPattern(args, i+1)
+		}
+	}
+
+	return append(append([]interface{}(nil), args...), "MATCH", escapePattern(c.prefix)+"*")
+}
+
+// keyAt returns a keysFunc with the fixed key positions
+func keyAt(positions ...int) keysFunc {
+	return func([]interface{}) ([]int, error) {
+		return positions, nil
+	}
+}
+
+// keysFrom returns a keysFunc with all
+// the args starting from i being keys
+func keysFrom(i int) keysFunc {
+	return func(args []interface{}) ([]int, error) {
+		return positions(i, len(args), 1), nil
+	}
+}
+
+// numKeys returns a keysFunc of the commands with
+// the number of keys at position i followed by the keys,
+// fixed holds the positions of the other keys (e.g. destination).
+// The command is rejected if the number of keys can not be parsed
+func numKeys(i int, fixed ...int) keysFunc {
+	return func(args []interface{}) ([]int, error) {
+		if i >= len(args) {
+			return fixed, nil
+		}
+
+		n, err := parseNumKeys(args[i])
+		if err != nil {
+			return nil, err
+		}
+
+		end := i + 1 + n
+		if end > len(args) {
+			end = len(args)
+		}
+		return append(append([]int(nil), fixed...), positions(i+1, end, 1)...), nil
+	}
+}
+
+// parseNumKeys parses the number of keys
+// given as an integer, a string or []byte
+func parseNumKeys(arg interface{}) (int, error) {
+	var s string
+	switch v := arg.(type) {
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		s = fmt.Sprint(v)
+	default:
+		var err error
+		if s, err = redis.String(arg, nil); err != nil {
+			return 0, fmt.Errorf("invalid number of keys %v", arg)
+		}
+	}
+
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid number of keys %q", s)
+	}
+	return n, nil
+}
+
+// allKeys is a keysFunc of the commands
+// with all the args being keys
+func allKeys(args []interface{}) ([]int, error) {
+	return positions(0, len(args), 1), nil
+}
+
+// allButLastKeys is a keysFunc of the blocking commands
+// with the keys followed by a timeout
+func allButLastKeys(args []interface{}) ([]int, error) {
+	return positions(0, len(args)-1, 1), nil
+}
+
+// pairKeys is a keysFunc of the commands
+// with key value pairs args
+func pairKeys(args []interface{}) ([]int, error) {
+	return positions(0, len(args), 2), nil
+}
+
+// streamKeys is a keysFunc of XREAD and XREADGROUP,
+// STREAMS option is followed by the keys and the ids
+func streamKeys(args []interface{}) ([]int, error) {
+	for i, arg := range args {
+		if argString(arg) == "STREAMS" {
+			n := (len(args) - i - 1) / 2
+			return positions(i+1, i+1+n, 1), nil
+		}
+	}
+	return nil, nil
+}
+
+// optionKeys returns a keysFunc of the commands with
+// the key as the first argument followed by the options
+// taking a key (e.g. STORE destination)
+func optionKeys(options ...string) keysFunc {
+	return func(args []interface{}) ([]int, error) {
+		keys := []int{0}
+		for i := 1; i < len(args)-1; i++ {
+			if s := argString(args[i]); s != "" && containsFold(options, s) {
+				keys = append(keys, i+1)
+			}
+		}
+		return keys, nil
+	}
+}
+
+// sortKeys is a keysFunc of SORT and SORT_RO, BY and GET
+// patterns are prefixed as they refer to the keys,
+// except for nosort and # ones
+func sortKeys(args []interface{}) ([]int, error) {
+	keys := []int{0}
+	for i := 1; i < len(args)-1; i++ {
+		switch argString(args[i]) {
+		case "STORE":
+			keys = append(keys, i+1)
+		case "BY", "GET":
+			if p := argString(args[i+1]); p != "#" && p != "NOSORT" {
+				keys = append(keys, i+1)
+			}
+		}
+	}
+	return keys, nil
+}
+
+// containsFold reports whether s is
+// within values ignoring the case
+func containsFold(values []string, s string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// positions returns the positions from start
+// to end (exclusive) with the step
+func positions(start, end, step int) []int {
+	var p []int
+	for i := start; i < end; i += step {
+		p = append(p, i)
+	}
+	return p
+}
+
+// stripKey returns the key without the namespace
+func stripKey(prefix string, key interface{}) interface{} {
+	switch k := key.(type) {
+	case []byte:
+		return bytes.TrimPrefix(k, []byte(prefix))
+	case string:
+		return strings.TrimPrefix(k, prefix)
+	}
+	return key
+}
+
+// stripAll is a replyFunc of the commands
+// replying with a list of keys
+func stripAll(prefix string, reply interface{}) interface{} {
+	keys, ok := reply.([]interface{})
+	if !ok {
+		return reply
+	}
+
+	stripped := make([]interface{}, len(keys))
+	for i, key := range keys {
+		stripped[i] = stripKey(prefix, key)
+	}
+	return stripped
+}
+
+// stripFirst is a replyFunc of the pop commands
+// replying with the key followed by the elements
+func stripFirst(prefix string, reply interface{}) interface{} {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) == 0 {
+		return reply
+	}
+
+	stripped := append([]interface{}(nil), values...)
+	stripped[0] = stripKey(prefix, values[0])
+	return stripped
+}
+
+// stripStreams is a replyFunc of XREAD and XREADGROUP
+// replying with a list of stream key and entries pairs
+func stripStreams(prefix string, reply interface{}) interface{} {
+	streams, ok := reply.([]interface{})
+	if !ok {
+		return reply
+	}
+
+	stripped := make([]interface{}, len(streams))
+	for i, s := range streams {
+		stripped[i] = stripFirst(prefix, s)
+	}
+	return stripped
+}
+
+// stripScan is a replyFunc of SCAN
+// replying with the cursor and a list of keys
+func stripScan(prefix string, reply interface{}) interface{} {
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 2 {
+		return reply
+	}
+	return []interface{}{values[0], stripAll(prefix, values[1])}
+}
+
+// execReply returns a replyFunc of EXEC which
+// applies the queued commands reply funcs
+func execReply(queued []replyFunc) replyFunc {
+	return func(prefix string, reply interface{}) interface{} {
+		values, ok := reply.([]interface{})
+		if !ok || len(values) != len(queued) {
+			return reply
+		}
+
+		stripped := make([]interface{}, len(values))
+		for i, v := range values {
+			stripped[i] = v
+			if queued[i] != nil && v != nil {
+				stripped[i] = queued[i](prefix, v)
+			}
+		}
+		return stripped
+	}
+}
+
+// abortReply returns a replyFunc of a discarded
+// transaction which replies with err
+func abortReply(err error) replyFunc {
+	return func(string, interface{}) interface{} {
+		return redis.Error(err.Error())
+	}
+}
+
+// escapePattern escapes the glob-style
+// pattern special characters
+func escapePattern(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(`*?[]\`, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
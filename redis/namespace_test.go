@@ -0,0 +1,195 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type NamespaceTestSuite struct{}
+
+var _ = gc.Suite(&NamespaceTestSuite{})
+
+// namespaceReply replies with namespaced keys
+// to the commands returning keys
+func namespaceReply(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "KEYS":
+		return []interface{}{[]byte("scores:match:1"), []byte("scores:match:2")}, nil
+	case "SCAN":
+		return []interface{}{[]byte("0"), []interface{}{[]byte("scores:match:1")}}, nil
+	case "BLPOP":
+		return []interface{}{[]byte("scores:events"), []byte("goal")}, nil
+	case "EXEC":
+		return []interface{}{[]interface{}{[]byte("scores:match:1")}, []byte("OK")}, nil
+	case "XREADGROUP":
+		return []interface{}{[]interface{}{[]byte("scores:events"), []interface{}{}}}, nil
+	}
+	return "OK", nil
+}
+
+func (s *NamespaceTestSuite) TestPrefixesKeys(c *gc.C) {
+	stub := &recordStub{reply: namespaceReply}
+	connector := NewNamespaceConnector(newStubConnector(stub.do), "scores:")
+
+	redisConn := connector.Connect()
+	defer redisConn.Close()
+
+	for _, cmd := range [][]interface{}{
+		{"GET", "match:1"},
+		{"set", []byte("match:1"), "live", "EX", 60},
+		{"MSET", "match:1", "live", "match:2", "finished"},
+		{"DEL", "match:1", "match:2"},
+		{"RENAME", "match:1", "match:3"},
+		{"ZUNIONSTORE", "top", 2, "league:1", "league:2", "WEIGHTS", 1, 2},
+		{"EVALSHA", "sha", 2, "match:1", "match:1:fence", "owner"},
+		{"EVAL", "return 1", "2", "match:1", []byte("match:2"), "owner"},
+		{"ZINTERSTORE", "top", int64(1), "league:1"},
+		{"XREADGROUP", "GROUP", "scores", "worker", "COUNT", 10, "STREAMS", "events", "goals", ">", ">"},
+		{"BLPOP", "events", "goals", 5},
+		{"BITOP", "AND", "dest", "a", "b"},
+		{"SORT", "ids", "BY", "weight_*", "GET", "#", "GET", "name_*->first", "STORE", "sorted"},
+		{"SORT", "ids", "BY", "nosort"},
+		{"GEORADIUS", "venues", 13.4, 52.5, 10, "km", "STORE", "nearby"},
+		{"LCS", "match:1", "match:2"},
+		{"KEYS", "match:*"},
+		{"SCAN", 0, "COUNT", 10},
+		{"SCAN", 0, "MATCH", "match:*"},
+		{"PING"},
+		{"PUBLISH", "events", "goal"},
+		{"XREAD", "COUNT", 1, []byte("streams"), "events", "0"},
+		{"SORT", "ids", []byte("BY"), "weight_*", []byte("STORE"), "sorted"},
+		{"GEORADIUS", "venues", 13.4, 52.5, 10, "km", []byte("STOREDIST"), "nearby"},
+		{"SCAN", 0, []byte("MATCH"), "match:*"},
+	} {
+		_, err := redisConn.Do(cmd[0].(string), cmd[1:]...)
+		c.Assert(err, gc.IsNil)
+	}
+
+	c.Check(stub.commands(""), gc.DeepEquals, []string{
+		"GET scores:match:1",
+		"set [115 99 111 114 101 115 58 109 97 116 99 104 58 49] live EX 60",
+		"MSET scores:match:1 live scores:match:2 finished",
+		"DEL scores:match:1 scores:match:2",
+		"RENAME scores:match:1 scores:match:3",
+		"ZUNIONSTORE scores:top 2 scores:league:1 scores:league:2 WEIGHTS 1 2",
+		"EVALSHA sha 2 scores:match:1 scores:match:1:fence owner",
+		fmt.Sprintf("EVAL return 1 2 scores:match:1 %v owner", []byte("scores:match:2")),
+		"ZINTERSTORE scores:top 1 scores:league:1",
+		"XREADGROUP GROUP scores worker COUNT 10 STREAMS scores:events scores:goals > >",
+		"BLPOP scores:events scores:goals 5",
+		"BITOP AND scores:dest scores:a scores:b",
+		"SORT scores:ids BY scores:weight_* GET # GET scores:name_*->first STORE scores:sorted",
+		"SORT scores:ids BY nosort",
+		"GEORADIUS scores:venues 13.4 52.5 10 km STORE scores:nearby",
+		"LCS scores:match:1 scores:match:2",
+		"KEYS scores:match:*",
+		"SCAN 0 COUNT 10 MATCH scores:*",
+		"SCAN 0 MATCH scores:match:*",
+		"PING",
+		"PUBLISH events goal",
+		fmt.Sprintf("XREAD COUNT 1 %v scores:events 0", []byte("streams")),
+		fmt.Sprintf("SORT scores:ids %v scores:weight_* %v scores:sorted", []byte("BY"), []byte("STORE")),
+		fmt.Sprintf("GEORADIUS scores:venues 13.4 52.5 10 km %v scores:nearby", []byte("STOREDIST")),
+		fmt.Sprintf("SCAN 0 %v scores:match:*", []byte("MATCH")),
+	})
+}
+
+func (s *NamespaceTestSuite) TestStripsReplies(c *gc.C) {
+	connector := NewNamespaceConnector(newStubConnector((&recordStub{reply: namespaceReply}).do), "scores:")
+
+	redisConn, err := connector.ConnectContext(context.Background())
+	c.Assert(err, gc.IsNil)
+	defer redisConn.Close()
+
+	keys, err := redis.Strings(redisConn.Do("KEYS", "*"))
+	c.Assert(err, gc.IsNil)
+	c.Check(keys, gc.DeepEquals, []string{"match:1", "match:2"})
+
+	popped, err := redis.Strings(redis.DoWithTimeout(redisConn, time.Second, "BLPOP", "events", 1))
+	c.Assert(err, gc.IsNil)
+	c.Check(popped, gc.DeepEquals, []string{"events", "goal"})
+
+	// pipelined commands
+	redisConn.Send("SCAN", 0)
+	redisConn.Send("GET", "match:1")
+	redisConn.Flush()
+
+	scan, err := redis.Values(redisConn.Receive())
+	c.Assert(err, gc.IsNil)
+	scanned, err := redis.Strings(scan[1], nil)
+	c.Assert(err, gc.IsNil)
+	c.Check(scanned, gc.DeepEquals, []string{"match:1"})
+
+	reply, err := redisConn.Receive()
+	c.Check(reply, gc.Equals, "OK")
+
+	// transaction replies
+	redisConn.Send("MULTI")
+	redisConn.Send("KEYS", "*")
+	redisConn.Send("SET", "match:1", "live")
+	replies, err := redis.Values(redisConn.Do("EXEC"))
+	c.Assert(err, gc.IsNil)
+
+	keys, err = redis.Strings(replies[0], nil)
+	c.Assert(err, gc.IsNil)
+	c.Check(keys, gc.DeepEquals, []string{"match:1"})
+
+	// stream names
+	streams, err := redis.Values(redisConn.Do("XREADGROUP", "GROUP", "g", "c", "STREAMS", "events", ">"))
+	c.Assert(err, gc.IsNil)
+	stream, err := redis.Values(streams[0], nil)
+	c.Assert(err, gc.IsNil)
+	c.Check(stream[0], gc.DeepEquals, []byte("events"))
+}
+
+func (s *NamespaceTestSuite) TestRejectsUnknownCommands(c *gc.C) {
+	stub := &recordStub{reply: namespaceReply}
+	connector := NewNamespaceConnector(newStubConnector(stub.do), "scores:")
+
+	redisConn := connector.Connect()
+	defer redisConn.Close()
+
+	_, err := redisConn.Do("MIGRATE", "host", 6379, "", 0, 1000, "KEYS", "match:1")
+	c.Check(errors.Is(err, ErrNamespaceCommand), gc.Equals, true)
+	c.Check(redisConn.Send("flushall"), gc.ErrorMatches, ".*: flushall")
+
+	// a transaction with a rejected command is discarded
+	redisConn.Do("MULTI")
+	redisConn.Do("SET", "match:1", "live")
+	c.Check(redisConn.Send("RANDOMKEY"), gc.NotNil)
+	_, err = redisConn.Do("EXEC")
+	c.Check(err, gc.ErrorMatches, ".*: RANDOMKEY")
+
+	// commands with an invalid number of keys
+	for _, numKeys := range []interface{}{"two", []byte("-1"), 1.5, nil} {
+		_, err = redisConn.Do("EVAL", "return 1", numKeys, "match:1")
+		c.Check(errors.Is(err, ErrNamespaceCommand), gc.Equals, true)
+	}
+
+	c.Check(stub.commands(""), gc.DeepEquals, []string{
+		"MULTI",
+		"SET scores:match:1 live",
+		"DISCARD",
+	})
+}
+
+func (s *NamespaceTestSuite) TestCache(c *gc.C) {
+	connector, store := newMemConnector()
+	cache := NewCache(NewNamespaceConnector(connector, "scores:"), nil)
+
+	c.Assert(cache.Set(context.Background(), "match:1", "live", time.Minute), gc.IsNil)
+	c.Check(string(store.data["scores:match:1"]), gc.Matches, ".*live.*")
+
+	var status string
+	c.Assert(cache.Get(context.Background(), "match:1", &status), gc.IsNil)
+	c.Check(status, gc.Equals, "live")
+}
+
+func (s *NamespaceTestSuite) TestEscapePattern(c *gc.C) {
+	c.Check(escapePattern(`svc*[1]?\:`), gc.Equals, `svc\*\[1\]\?\\:`)
+}
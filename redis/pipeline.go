@@ -0,0 +1,208 @@
+package redis
+
+import (
+	"fmt"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// DefaultChunkSize holds the default number of keys
+// sent within a single MGET/MSET command
+const DefaultChunkSize = 500
+
+type (
+	// Pipeline is a struct type
+	// which buffers commands and sends them
+	// to the server in a single round-trip
+	Pipeline struct {
+		conn redis.Conn
+		cmds []*PipelineCmd
+	}
+
+	// PipelineCmd is a struct type
+	// which holds a pipelined command
+	// and its reply once the pipeline is executed
+	PipelineCmd struct {
+		Name  string
+		Args  []interface{}
+		Reply interface{}
+		Err   error
+	}
+
+	// PipelineError is a struct type
+	// which holds failed pipeline commands
+	PipelineError struct {
+		Failed []*PipelineCmd
+		Total  int
+	}
+)
+
+// NewPipeline inits and returns a pointer to Pipeline instance
+// which sends commands over conn
+func NewPipeline(conn redis.Conn) *Pipeline {
+	return &Pipeline{conn: conn}
+}
+
+// Send buffers the command, the returned PipelineCmd
+// holds the command reply once Exec is called
+func (p *Pipeline) Send(cmd string, args ...interface{}) *PipelineCmd {
+	pc := &PipelineCmd{Name: cmd, Args: args}
+	p.cmds = append(p.cmds, pc)
+	return pc
+}
+
+// Len returns the number of buffered commands
+func (p *Pipeline) Len() int {
+	return len(p.cmds)
+}
+
+// Exec sends the buffered commands, reads all the replies
+// and resets the pipeline.
+// Connection errors are returned as is and set for every
+// command, *PipelineError is returned if some of the commands
+// got error replies
+func (p *Pipeline) Exec() error {
+	cmds := p.cmds
+	p.cmds = nil
+
+	if len(cmds) == 0 {
+		return nil
+	}
+
+	for _, pc := range cmds {
+		if err := p.conn.Send(pc.Name, pc.Args...); err != nil {
+			return failAll(cmds, err)
+		}
+	}
+
+	if err := p.conn.Flush(); err != nil {
+		return failAll(cmds, err)
+	}
+
+	var failed []*PipelineCmd
+	for i, pc := range cmds {
+		pc.Reply, pc.Err = p.conn.Receive()
+
+		if _, ok := pc.Err.(redis.Error); ok {
+			failed = append(failed, pc)
+			continue
+		}
+
+		if pc.Err != nil {
+			return failAll(cmds[i:], pc.Err)
+		}
+	}
+
+	if len(failed) > 0 {
+		return &PipelineError{Failed: failed, Total: len(cmds)}
+	}
+
+	return nil
+}
+
+// String returns the command reply converted to a string
+func (pc *PipelineCmd) String() (string, error) {
+	return redis.String(pc.Reply, pc.Err)
+}
+
+// Bytes returns the command reply converted to a byte slice
+func (pc *PipelineCmd) Bytes() ([]byte, error) {
+	return redis.Bytes(pc.Reply, pc.Err)
+}
+
+// Int64 returns the command reply converted to an int64
+func (pc *PipelineCmd) Int64() (int64, error) {
+	return redis.Int64(pc.Reply, pc.Err)
+}
+
+// Bool returns the command reply converted to a bool
+func (pc *PipelineCmd) Bool() (bool, error) {
+	return redis.Bool(pc.Reply, pc.Err)
+}
+
+// Values returns the command reply converted to an []interface{}
+func (pc *PipelineCmd) Values() ([]interface{}, error) {
+	return redis.Values(pc.Reply, pc.Err)
+}
+
+// Error returns a summary of the failed commands
+func (e *PipelineError) Error() string {
+	first := e.Failed[0]
+	return fmt.Sprintf("redis: %d of %d pipeline commands failed, first: %s: %s", len(e.Failed), e.Total, first.Name, first.Err)
+}
+
+// MGet returns values of the keys splitting them into
+// MGET commands of chunkSize keys sent within a single pipeline.
+// Values of missing keys are nil.
+// DefaultChunkSize is used if chunkSize is not positive.
+//
+// The chunks mix keys of different hash slots, so MGet
+// is meant for single node connections: on a cluster one
+// the keys have to share a {hash tag}, otherwise the
+// commands fail with CROSSSLOT error
+func MGet(conn redis.Conn, keys []string, chunkSize int) ([][]byte, error) {
+	p := NewPipeline(conn)
+	for _, chunk := range chunks(len(keys), chunkSize) {
+		p.Send("MGET", redis.Args{}.AddFlat(keys[chunk[0]:chunk[1]])...)
+	}
+
+	cmds := p.cmds
+	if err := p.Exec(); err != nil {
+		return nil, err
+	}
+
+	values := make([][]byte, 0, len(keys))
+	for _, pc := range cmds {
+		chunkValues, err := redis.ByteSlices(pc.Reply, pc.Err)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, chunkValues...)
+	}
+
+	return values, nil
+}
+
+// MSet sets the keys to the values splitting them into
+// MSET commands of chunkSize keys sent within a single pipeline.
+// DefaultChunkSize is used if chunkSize is not positive.
+// Like MGet, MSet is meant for single node connections,
+// the keys of a cluster one have to share a {hash tag}
+func MSet(conn redis.Conn, values map[string]interface{}, chunkSize int) error {
+	args := make(redis.Args, 0, 2*len(values))
+	for key, value := range values {
+		args = append(args, key, value)
+	}
+
+	p := NewPipeline(conn)
+	for _, chunk := range chunks(len(values), chunkSize) {
+		p.Send("MSET", args[2*chunk[0]:2*chunk[1]]...)
+	}
+
+	return p.Exec()
+}
+
+// chunks splits n items into [start, end) ranges of size items
+func chunks(n, size int) [][2]int {
+	if size <= 0 {
+		size = DefaultChunkSize
+	}
+
+	ranges := make([][2]int, 0, (n+size-1)/size)
+	for start := 0; start < n; start += size {
+		end := start + size
+		if end > n {
+			end = n
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+	return ranges
+}
+
+// failAll sets err for every command and returns it
+func failAll(cmds []*PipelineCmd, err error) error {
+	for _, pc := range cmds {
+		pc.Err = err
+	}
+	return err
+}
@@ -0,0 +1,83 @@
+package redis
+
+import (
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type PipelineTestSuite struct{}
+
+var _ = gc.Suite(&PipelineTestSuite{})
+
+// newKVConn returns a stubConn serving
+// GET/MGET/MSET commands from the data map
+func newKVConn(data map[string][]byte) *stubConn {
+	return &stubConn{DoFunc: func(cmd string, args ...interface{}) (interface{}, error) {
+		switch cmd {
+		case "GET":
+			if b, ok := data[args[0].(string)]; ok {
+				return b, nil
+			}
+			return nil, nil
+		case "MGET":
+			values := make([]interface{}, 0, len(args))
+			for _, key := range args {
+				if b, ok := data[key.(string)]; ok {
+					values = append(values, b)
+				} else {
+					values = append(values, nil)
+				}
+			}
+			return values, nil
+		case "MSET":
+			for i := 0; i < len(args); i += 2 {
+				data[args[i].(string)] = []byte(args[i+1].(string))
+			}
+			return "OK", nil
+		}
+		return nil, redis.Error("ERR unknown command '" + cmd + "'")
+	}}
+}
+
+func (s *PipelineTestSuite) TestPipelineExec(c *gc.C) {
+	conn := newKVConn(map[string][]byte{"a": []byte("1")})
+	p := NewPipeline(conn)
+
+	get := p.Send("GET", "a")
+	missing := p.Send("GET", "b")
+	bad := p.Send("BAD")
+	c.Check(p.Len(), gc.Equals, 3)
+
+	err := p.Exec()
+	c.Assert(err, gc.FitsTypeOf, &PipelineError{})
+	c.Check(err, gc.ErrorMatches, "redis: 1 of 3 pipeline commands failed, first: BAD: ERR unknown command 'BAD'")
+	c.Check(err.(*PipelineError).Failed, gc.DeepEquals, []*PipelineCmd{bad})
+	c.Check(p.Len(), gc.Equals, 0)
+
+	value, err := get.String()
+	c.Check(err, gc.IsNil)
+	c.Check(value, gc.Equals, "1")
+
+	_, err = missing.Bytes()
+	c.Check(err, gc.Equals, redis.ErrNil)
+}
+
+func (s *PipelineTestSuite) TestMSetMGetChunks(c *gc.C) {
+	data := map[string][]byte{}
+	conn := newKVConn(data)
+
+	c.Assert(MSet(conn, map[string]interface{}{"a": "1", "b": "2", "c": "3"}, 2), gc.IsNil)
+	c.Check(data, gc.HasLen, 3)
+
+	conn.cmds = nil
+	values, err := MGet(conn, []string{"a", "b", "x", "c"}, 3)
+	c.Assert(err, gc.IsNil)
+	c.Check(values, gc.DeepEquals, [][]byte{[]byte("1"), []byte("2"), nil, []byte("3")})
+	c.Check(conn.cmds, gc.DeepEquals, []string{"MGET a b x", "MGET c"})
+}
+
+func (s *PipelineTestSuite) TestChunks(c *gc.C) {
+	c.Check(chunks(5, 2), gc.DeepEquals, [][2]int{{0, 2}, {2, 4}, {4, 5}})
+	c.Check(chunks(0, 2), gc.HasLen, 0)
+	c.Check(chunks(DefaultChunkSize+1, 0), gc.HasLen, 2)
+}
@@ -0,0 +1,275 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// defaultReconnectInterval holds the default interval
+	// between subscriber reconnection attempts
+	defaultReconnectInterval = time.Second
+
+	// defaultHealthCheckInterval holds the default interval
+	// between subscriber connection PINGs
+	defaultHealthCheckInterval = 30 * time.Second
+)
+
+type (
+	// Message is a struct type
+	// which holds a pub/sub message
+	Message struct {
+		// Channel holds the channel the message was published to
+		Channel string
+		// Pattern holds the matched pattern for
+		// messages received with PSubscribe
+		Pattern string
+		// Data holds the message payload
+		Data []byte
+	}
+
+	// MessageHandler describes logic for
+	// processing a pub/sub message
+	MessageHandler interface {
+		HandleMessage(*Message) error
+	}
+
+	// SubscribeHandler is implemented by the message handlers
+	// which are notified every time their channel or pattern is
+	// (re)subscribed, e.g. to drop the state the messages lost
+	// while reconnecting were supposed to update
+	SubscribeHandler interface {
+		HandleSubscribe(channel string)
+	}
+
+	// MessageHandlerFunc implements MessageHandler interface
+	MessageHandlerFunc func(*Message) error
+
+	// Subscriber is a struct type
+	// which receives pub/sub messages on a dedicated
+	// connection and dispatches them to the handlers.
+	//
+	// Subscriber reconnects and re-subscribes after connection
+	// drops; as the connection is retrieved from the Connector,
+	// the new connection follows a failover handled by the connector.
+	// Messages published while the subscriber is reconnecting are lost.
+	//
+	// ClusterConnector connections do not support pub/sub, use
+	// a RedisConnector pointed to any of the cluster nodes instead
+	Subscriber struct {
+		connector Connector
+		logger    *log.Logger
+
+		mu       sync.RWMutex
+		channels map[string]MessageHandler
+		patterns map[string]MessageHandler
+
+		// ReconnectInterval holds the interval between
+		// reconnection attempts, 1s is used if zero
+		ReconnectInterval time.Duration
+		// HealthCheckInterval holds the interval between PINGs
+		// sent to detect dead connections, 30s is used if zero
+		HealthCheckInterval time.Duration
+	}
+)
+
+// HandleMessage calls f(m)
+func (f MessageHandlerFunc) HandleMessage(m *Message) error {
+	return f(m)
+}
+
+// NewSubscriber inits and returns a pointer to Subscriber instance.
+// The logs are discarded if logger is nil
+func NewSubscriber(c Connector, logger *log.Logger) *Subscriber {
+	return &Subscriber{
+		connector: c,
+		logger:    LoggerOrDiscard(logger),
+		channels:  make(map[string]MessageHandler),
+		patterns:  make(map[string]MessageHandler),
+	}
+}
+
+// Subscribe registers the handler for the channel messages.
+// Channels are subscribed on Run
+func (s *Subscriber) Subscribe(channel string, h MessageHandler) {
+	s.mu.Lock()
+	s.channels[channel] = h
+	s.mu.Unlock()
+}
+
+// PSubscribe registers the handler for messages of the channels
+// matching the pattern. Patterns are subscribed on Run
+func (s *Subscriber) PSubscribe(pattern string, h MessageHandler) {
+	s.mu.Lock()
+	s.patterns[pattern] = h
+	s.mu.Unlock()
+}
+
+// Run subscribes to the registered channels and patterns
+// and dispatches received messages until ctx is done.
+// Connection errors are logged and followed by reconnection
+func (s *Subscriber) Run(ctx context.Context) error {
+	s.mu.RLock()
+	empty := len(s.channels) == 0 && len(s.patterns) == 0
+	s.mu.RUnlock()
+
+	if empty {
+		return errors.New("redis subscriber: no channels to subscribe")
+	}
+
+	interval := s.ReconnectInterval
+	if interval <= 0 {
+		interval = defaultReconnectInterval
+	}
+
+	for {
+		if err := s.receive(ctx); err != nil && ctx.Err() == nil {
+			s.logger.Printf("redis subscriber: %s; reconnecting", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
+
+// receive subscribes on a new connection and dispatches
+// messages until the connection fails or ctx is done
+func (s *Subscriber) receive(ctx context.Context) error {
+	redisConn, err := ConnectContext(ctx, s.connector)
+	if err != nil {
+		return err
+	}
+
+	psc := redis.PubSubConn{Conn: redisConn}
+	defer psc.Close()
+
+	s.mu.RLock()
+	channels, patterns := keysOf(s.channels), keysOf(s.patterns)
+	s.mu.RUnlock()
+
+	if len(channels) > 0 {
+		if err := psc.Subscribe(channels...); err != nil {
+			return err
+		}
+	}
+
+	if len(patterns) > 0 {
+		if err := psc.PSubscribe(patterns...); err != nil {
+			return err
+		}
+	}
+
+	healthCheck := s.HealthCheckInterval
+	if healthCheck <= 0 {
+		healthCheck = defaultHealthCheckInterval
+	}
+
+	// keepAlive writes to the connection,
+	// it must be stopped before the connection is closed
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	defer wg.Wait()
+	defer close(done)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		keepAlive(ctx, psc, healthCheck, done)
+	}()
+
+	for {
+		switch v := psc.ReceiveWithTimeout(2 * healthCheck).(type) {
+		case redis.Message:
+			// pattern messages hold the matched pattern
+			h := s.handler(s.channels, v.Channel)
+			if v.Pattern != "" {
+				h = s.handler(s.patterns, v.Pattern)
+			}
+			s.dispatch(h, &Message{Channel: v.Channel, Pattern: v.Pattern, Data: v.Data})
+		case redis.Subscription:
+			if v.Count == 0 {
+				return nil
+			}
+
+			switch v.Kind {
+			case "subscribe":
+				s.notify(s.handler(s.channels, v.Channel), v.Channel)
+			case "psubscribe":
+				s.notify(s.handler(s.patterns, v.Channel), v.Channel)
+			}
+		case error:
+			return v
+		}
+	}
+}
+
+// handler returns the handler registered in the handlers
+// under the name
+func (s *Subscriber) handler(handlers map[string]MessageHandler, name string) MessageHandler {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return handlers[name]
+}
+
+// dispatch calls the handler and logs handler errors
+func (s *Subscriber) dispatch(h MessageHandler, m *Message) {
+	if h == nil {
+		return
+	}
+
+	if err := tryCatch(func() error { return h.HandleMessage(m) }); err != nil {
+		s.logger.Printf("redis subscriber: channel %s: %s", m.Channel, err)
+	}
+}
+
+// notify calls the handler HandleSubscribe
+// if it implements SubscribeHandler
+func (s *Subscriber) notify(h MessageHandler, channel string) {
+	sh, ok := h.(SubscribeHandler)
+	if !ok {
+		return
+	}
+
+	if err := tryCatch(func() error { sh.HandleSubscribe(channel); return nil }); err != nil {
+		s.logger.Printf("redis subscriber: channel %s: %s", channel, err)
+	}
+}
+
+// keepAlive pings the subscribed connection every interval
+// and unsubscribes from all the channels once ctx is done
+func keepAlive(ctx context.Context, psc redis.PubSubConn, interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ctx.Done():
+			psc.Unsubscribe()
+			psc.PUnsubscribe()
+			return
+		case <-ticker.C:
+			if err := psc.Ping(""); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// keysOf returns the handlers map keys
+func keysOf(handlers map[string]MessageHandler) []interface{} {
+	keys := make([]interface{}, 0, len(handlers))
+	for key := range handlers {
+		keys = append(keys, key)
+	}
+	return keys
+}
@@ -0,0 +1,180 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"sync/atomic"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type PubSubTestSuite struct{}
+
+var _ = gc.Suite(&PubSubTestSuite{})
+
+type pubsubReply struct {
+	reply interface{}
+	err   error
+}
+
+// pubsubConn is a redis.ConnWithTimeout implementation
+// which emulates server pub/sub replies
+type pubsubConn struct {
+	replies    chan pubsubReply
+	subscribes *int32
+}
+
+func newPubSubConn(subscribes *int32) *pubsubConn {
+	return &pubsubConn{replies: make(chan pubsubReply, 100), subscribes: subscribes}
+}
+
+func (c *pubsubConn) push(reply ...interface{}) {
+	c.replies <- pubsubReply{reply: reply}
+}
+
+func (c *pubsubConn) Send(cmd string, args ...interface{}) error {
+	switch cmd {
+	case "SUBSCRIBE":
+		atomic.AddInt32(c.subscribes, 1)
+		for i, arg := range args {
+			c.push([]byte("subscribe"), []byte(arg.(string)), int64(i+1))
+		}
+	case "UNSUBSCRIBE":
+		c.push([]byte("unsubscribe"), nil, int64(0))
+	case "PING":
+		c.push([]byte("pong"), []byte(""))
+	case "ECHO":
+		c.replies <- pubsubReply{reply: args[0]}
+	}
+	return nil
+}
+
+func (c *pubsubConn) Receive() (interface{}, error) {
+	select {
+	case r := <-c.replies:
+		return r.reply, r.err
+	default:
+		return nil, errors.New("no pending replies")
+	}
+}
+
+func (c *pubsubConn) ReceiveWithTimeout(timeout time.Duration) (interface{}, error) {
+	select {
+	case r := <-c.replies:
+		return r.reply, r.err
+	case <-time.After(timeout):
+		return nil, errors.New("i/o timeout")
+	}
+}
+
+func (c *pubsubConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		return nil, nil
+	}
+	return c.DoWithTimeout(0, cmd, args...)
+}
+
+func (c *pubsubConn) DoWithTimeout(time.Duration, string, ...interface{}) (interface{}, error) {
+	return "OK", nil
+}
+
+func (c *pubsubConn) Close() error { return nil }
+func (c *pubsubConn) Err() error   { return nil }
+func (c *pubsubConn) Flush() error { return nil }
+
+func (s *PubSubTestSuite) TestSubscriberDispatchesAndReconnects(c *gc.C) {
+	var subscribes int32
+	conns := make(chan *pubsubConn, 10)
+	connector := &RedisConnector{pools: []*connPool{{Pool: &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			conn := newPubSubConn(&subscribes)
+			conns <- conn
+			return conn, nil
+		},
+	}}}}
+
+	var logs bytes.Buffer
+	subscriber := NewSubscriber(connector, log.New(&logs, "", 0))
+	subscriber.ReconnectInterval = time.Millisecond
+
+	received := make(chan string, 10)
+	subscriber.Subscribe("invalidate", MessageHandlerFunc(func(m *Message) error {
+		if string(m.Data) == "panic" {
+			panic("test panic")
+		}
+		received <- m.Channel + ":" + string(m.Data)
+		return nil
+	}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- subscriber.Run(ctx) }()
+
+	conn := <-conns
+	conn.push([]byte("message"), []byte("invalidate"), []byte("panic"))
+	conn.push([]byte("message"), []byte("invalidate"), []byte("match:1"))
+	c.Check(<-received, gc.Equals, "invalidate:match:1")
+
+	// connection drop: the subscriber re-subscribes
+	conn.replies <- pubsubReply{err: errors.New("connection reset by peer")}
+	for atomic.LoadInt32(&subscribes) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	c.Check(<-stopped, gc.IsNil)
+	c.Check(logs.String(), gc.Matches, "(?s)redis subscriber: channel invalidate: panic: test panic\n.*connection reset by peer; reconnecting\n")
+}
+
+// subscribeHandler records the subscribe notifications
+type subscribeHandler struct {
+	MessageHandlerFunc
+	subscribed chan string
+}
+
+func (h *subscribeHandler) HandleSubscribe(channel string) {
+	h.subscribed <- channel
+}
+
+func (s *PubSubTestSuite) TestSubscriberNotifiesSubscribeHandlers(c *gc.C) {
+	var subscribes int32
+	conns := make(chan *pubsubConn, 10)
+	connector := &RedisConnector{pools: []*connPool{{Pool: &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			conn := newPubSubConn(&subscribes)
+			conns <- conn
+			return conn, nil
+		},
+	}}}}
+
+	subscriber := NewSubscriber(connector, nil)
+	subscriber.ReconnectInterval = time.Millisecond
+
+	h := &subscribeHandler{
+		MessageHandlerFunc: func(*Message) error { return nil },
+		subscribed:         make(chan string, 10),
+	}
+	subscriber.Subscribe("invalidate", h)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() { stopped <- subscriber.Run(ctx) }()
+
+	c.Check(<-h.subscribed, gc.Equals, "invalidate")
+
+	// connection drop: the handler is notified on re-subscribe
+	(<-conns).replies <- pubsubReply{err: errors.New("connection reset by peer")}
+	c.Check(<-h.subscribed, gc.Equals, "invalidate")
+
+	cancel()
+	c.Check(<-stopped, gc.IsNil)
+}
+
+func (s *PubSubTestSuite) TestSubscriberRunWithoutChannels(c *gc.C) {
+	subscriber := NewSubscriber(&RedisConnector{}, nil)
+	c.Check(subscriber.Run(context.Background()), gc.ErrorMatches, "redis subscriber: no channels to subscribe")
+}
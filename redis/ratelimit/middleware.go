@@ -0,0 +1,139 @@
+package ratelimit
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/onefootball/samodelkin/redis"
+)
+
+type (
+	// KeyFunc is a func type which returns
+	// the rate limit key of the request
+	KeyFunc func(r *http.Request) string
+
+	// Middleware is a struct type
+	// which implements http.Handler interface
+	// and rejects requests over the limit with
+	// 429 Too Many Requests status.
+	//
+	// Limit state is reported with X-RateLimit-Limit,
+	// X-RateLimit-Remaining, X-RateLimit-Reset (seconds)
+	// and Retry-After (seconds) headers.
+	// Limiter errors are logged and the requests are
+	// passed through, or rejected with 503 Service
+	// Unavailable status if FailClosed is set
+	Middleware struct {
+		Limiter Limiter
+		Limit   int
+		Window  time.Duration
+		KeyFunc KeyFunc
+		Next    http.Handler
+		// Logger logs the limiter errors,
+		// the logs are discarded if nil
+		Logger     *log.Logger
+		FailClosed bool
+	}
+)
+
+// NewMiddleware inits and returns a pointer to Middleware instance.
+// Requests are limited per client IP if keyFunc is nil.
+// ErrInvalidLimit or ErrInvalidWindow is returned
+// if limit is not positive or window is shorter than 1ms
+func NewMiddleware(l Limiter, limit int, window time.Duration, keyFunc KeyFunc, next http.Handler) (*Middleware, error) {
+	if err := validate(limit, window); err != nil {
+		return nil, err
+	}
+
+	if keyFunc == nil {
+		keyFunc = KeyByIP
+	}
+
+	return &Middleware{
+		Limiter: l,
+		Limit:   limit,
+		Window:  window,
+		KeyFunc: keyFunc,
+		Next:    next,
+	}, nil
+}
+
+// ServeHTTP checks the request against the limit
+// and calls the next handler for allowed requests
+func (m *Middleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key := m.KeyFunc(r)
+	res, err := m.Limiter.Allow(r.Context(), key, m.Limit, m.Window)
+	if err != nil {
+		redis.LoggerOrDiscard(m.Logger).Printf("ratelimit: allow %s: %s", key, err)
+		if m.FailClosed {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		m.Next.ServeHTTP(w, r)
+		return
+	}
+
+	h := w.Header()
+	h.Set("X-RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("X-RateLimit-Reset", seconds(res.Reset))
+
+	if !res.Allowed {
+		h.Set("Retry-After", seconds(res.RetryAfter))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	m.Next.ServeHTTP(w, r)
+}
+
+// KeyByIP returns the request remote IP
+// prefixed with "ratelimit:". Clients behind
+// a load balancer or a proxy share its IP and
+// the limit, use KeyByForwardedFor in that case
+func KeyByIP(r *http.Request) string {
+	return "ratelimit:" + remoteIP(r)
+}
+
+// KeyByForwardedFor returns a KeyFunc which limits
+// the requests per client IP passed by the trusted
+// proxies in X-Forwarded-For header. proxies holds the
+// number of the trusted proxies in front of the service:
+// the IP appended by the outermost one is used, as the
+// header entries before it can be forged by the client.
+// The remote IP is used if the header has fewer entries
+func KeyByForwardedFor(proxies int) KeyFunc {
+	return func(r *http.Request) string {
+		var ips []string
+		for _, h := range r.Header.Values("X-Forwarded-For") {
+			for _, ip := range strings.Split(h, ",") {
+				ips = append(ips, strings.TrimSpace(ip))
+			}
+		}
+
+		if proxies <= 0 || len(ips) < proxies {
+			return KeyByIP(r)
+		}
+
+		return "ratelimit:" + ips[len(ips)-proxies]
+	}
+}
+
+// remoteIP returns the request remote address IP
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// seconds returns d rounded up to whole seconds
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
@@ -0,0 +1,289 @@
+// Package ratelimit provides redis backed
+// rate limiters shared by all the service instances.
+//
+// Limiters are implemented with Lua scripts, so every
+// Allow call is a single atomic round-trip:
+//   - FixedWindow counts requests per fixed time window
+//   - SlidingWindow keeps a log of requests within the last window
+//   - TokenBucket refills limit tokens evenly over the window
+//
+// Time is taken from the caller clock, service instances
+// are expected to have their clocks synchronized
+package ratelimit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/onefootball/samodelkin/redis"
+)
+
+var (
+	// ErrInvalidLimit is returned if the limit is not positive
+	ErrInvalidLimit = errors.New("ratelimit: limit must be positive")
+
+	// ErrInvalidWindow is returned if the window
+	// is shorter than a millisecond
+	ErrInvalidWindow = errors.New("ratelimit: window must be at least 1ms")
+)
+
+type (
+	// Result is a struct type
+	// which holds a rate limit decision
+	Result struct {
+		// Allowed is true if the request fits the limit
+		Allowed bool
+		// Limit holds the max number of requests per window
+		Limit int
+		// Remaining holds the number of requests
+		// which are still allowed within the window
+		Remaining int
+		// Reset holds the time left until the limit is fully restored
+		Reset time.Duration
+		// RetryAfter holds the time left until the next
+		// request is allowed, zero for allowed requests
+		RetryAfter time.Duration
+	}
+
+	// Limiter is an interface type
+	// which describes rate limiting logic
+	Limiter interface {
+		Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error)
+	}
+
+	// FixedWindow implements Limiter interface
+	// with a counter per fixed time window
+	FixedWindow struct {
+		connector redis.Connector
+	}
+
+	// SlidingWindow implements Limiter interface
+	// with a sorted set log of requests within the last window
+	SlidingWindow struct {
+		connector redis.Connector
+	}
+
+	// TokenBucket implements Limiter interface
+	// with a bucket of limit tokens refilled evenly
+	// over the window
+	TokenBucket struct {
+		connector redis.Connector
+	}
+)
+
+var (
+	// fixedWindowScript increments the window counter
+	// and returns {count, pttl}
+	fixedWindowScript = redis.DefaultScripts.Register(1, `
+local count = redis.call("INCR", KEYS[1])
+local pttl = redis.call("PTTL", KEYS[1])
+if pttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	pttl = tonumber(ARGV[1])
+end
+return {count, pttl}
+`)
+
+	// slidingWindowScript drops expired requests from the log,
+	// logs the request if it fits the limit and
+	// returns {allowed, count, reset}
+	slidingWindowScript = redis.DefaultScripts.Register(1, `
+local now, window, limit = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+redis.call("ZREMRANGEBYSCORE", KEYS[1], "-inf", now - window)
+local count = redis.call("ZCARD", KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[4])
+	count = count + 1
+	allowed = 1
+end
+redis.call("PEXPIRE", KEYS[1], window)
+local reset = 0
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+if oldest[2] then
+	reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, count, reset}
+`)
+
+	// tokenBucketScript refills the bucket, takes a token
+	// if available and returns {allowed, tokens, retry, reset}
+	tokenBucketScript = redis.DefaultScripts.Register(1, `
+local now, window, capacity = tonumber(ARGV[1]), tonumber(ARGV[2]), tonumber(ARGV[3])
+local rate = capacity / window
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1]) or capacity
+local ts = tonumber(state[2]) or now
+tokens = math.min(capacity, tokens + math.max(0, now - ts) * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], window)
+return {allowed, math.floor(tokens), retry, math.ceil((capacity - tokens) / rate)}
+`)
+)
+
+// NewFixedWindow inits and returns a pointer to FixedWindow instance
+func NewFixedWindow(c redis.Connector) *FixedWindow {
+	return &FixedWindow{connector: c}
+}
+
+// Allow counts the request within the current window of the key
+func (l *FixedWindow) Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	if err := validate(limit, window); err != nil {
+		return Result{}, err
+	}
+
+	values, err := eval(ctx, l.connector, fixedWindowScript, key, millis(window))
+	if err != nil {
+		return Result{}, err
+	}
+
+	var count, pttl int64
+	if _, err := redigo.Scan(values, &count, &pttl); err != nil {
+		return Result{}, err
+	}
+
+	res := Result{
+		Allowed:   count <= int64(limit),
+		Limit:     limit,
+		Remaining: remaining(limit, count),
+		Reset:     time.Duration(max(pttl, 0)) * time.Millisecond,
+	}
+
+	if !res.Allowed {
+		res.RetryAfter = res.Reset
+	}
+
+	return res, nil
+}
+
+// NewSlidingWindow inits and returns a pointer to SlidingWindow instance
+func NewSlidingWindow(c redis.Connector) *SlidingWindow {
+	return &SlidingWindow{connector: c}
+}
+
+// Allow logs the request if less than limit
+// requests were made within the last window
+func (l *SlidingWindow) Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	if err := validate(limit, window); err != nil {
+		return Result{}, err
+	}
+
+	member, err := requestID()
+	if err != nil {
+		return Result{}, err
+	}
+
+	values, err := eval(ctx, l.connector, slidingWindowScript, key, nowMillis(), millis(window), limit, member)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var allowed, count, reset int64
+	if _, err := redigo.Scan(values, &allowed, &count, &reset); err != nil {
+		return Result{}, err
+	}
+
+	res := Result{
+		Allowed:   allowed == 1,
+		Limit:     limit,
+		Remaining: remaining(limit, count),
+		Reset:     time.Duration(reset) * time.Millisecond,
+	}
+
+	if !res.Allowed {
+		// the oldest logged request leaves the window first
+		res.RetryAfter = res.Reset
+	}
+
+	return res, nil
+}
+
+// NewTokenBucket inits and returns a pointer to TokenBucket instance
+func NewTokenBucket(c redis.Connector) *TokenBucket {
+	return &TokenBucket{connector: c}
+}
+
+// Allow takes a token from the key bucket.
+// The bucket holds up to limit tokens and is refilled
+// at the limit/window rate
+func (l *TokenBucket) Allow(ctx context.Context, key string, limit int, window time.Duration) (Result, error) {
+	if err := validate(limit, window); err != nil {
+		return Result{}, err
+	}
+
+	values, err := eval(ctx, l.connector, tokenBucketScript, key, nowMillis(), millis(window), limit)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var allowed, tokens, retry, reset int64
+	if _, err := redigo.Scan(values, &allowed, &tokens, &retry, &reset); err != nil {
+		return Result{}, err
+	}
+
+	return Result{
+		Allowed:    allowed == 1,
+		Limit:      limit,
+		Remaining:  int(tokens),
+		Reset:      time.Duration(reset) * time.Millisecond,
+		RetryAfter: time.Duration(retry) * time.Millisecond,
+	}, nil
+}
+
+// validate returns an error if the limit
+// or window can not be enforced by the scripts
+func validate(limit int, window time.Duration) error {
+	if limit <= 0 {
+		return ErrInvalidLimit
+	}
+	if window < time.Millisecond {
+		return ErrInvalidWindow
+	}
+	return nil
+}
+
+// eval runs the script for the key
+// and returns multi-bulk reply values
+func eval(ctx context.Context, c redis.Connector, script *redis.Script, key string, args ...interface{}) ([]interface{}, error) {
+	return redigo.Values(script.Run(ctx, c, append([]interface{}{key}, args...)...))
+}
+
+// remaining returns the number of requests
+// left within the limit
+func remaining(limit int, count int64) int {
+	if left := int64(limit) - count; left > 0 {
+		return int(left)
+	}
+	return 0
+}
+
+// millis converts d into milliseconds
+func millis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+// nowMillis returns the current unix time in milliseconds
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// requestID returns a random sliding window log member
+func requestID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,175 @@
+package ratelimit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gc "github.com/go-check/check"
+	redigo "github.com/gomodule/redigo/redis"
+)
+
+type RateLimitTestSuite struct{}
+
+var _ = gc.Suite(&RateLimitTestSuite{})
+
+func TestRateLimit(t *testing.T) { gc.TestingT(t) }
+
+// replyConn is a redigo.Conn implementation
+// which replies to every command with reply
+type replyConn struct {
+	redigo.Conn
+	reply interface{}
+}
+
+func (c *replyConn) Do(cmd string, args ...interface{}) (interface{}, error) { return c.reply, nil }
+func (c *replyConn) Close() error                                            { return nil }
+
+// replyConnector is a redis.Connector implementation
+// which returns replyConn connections
+type replyConnector struct {
+	reply interface{}
+}
+
+func (c *replyConnector) Connect() redigo.Conn { return &replyConn{reply: c.reply} }
+
+func (c *replyConnector) ConnectContext(context.Context) (redigo.Conn, error) {
+	return c.Connect(), nil
+}
+
+func (c *replyConnector) PingConnect() (redigo.Conn, error) { return c.Connect(), nil }
+
+// limiterFunc implements Limiter interface
+type limiterFunc func(key string) (Result, error)
+
+func (f limiterFunc) Allow(_ context.Context, key string, _ int, _ time.Duration) (Result, error) {
+	return f(key)
+}
+
+func (s *RateLimitTestSuite) TestFixedWindowAllow(c *gc.C) {
+	l := NewFixedWindow(&replyConnector{reply: []interface{}{int64(3), int64(1500)}})
+
+	res, err := l.Allow(context.Background(), "key", 5, 2*time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(res, gc.Equals, Result{Allowed: true, Limit: 5, Remaining: 2, Reset: 1500 * time.Millisecond})
+
+	l = NewFixedWindow(&replyConnector{reply: []interface{}{int64(6), int64(1500)}})
+	res, err = l.Allow(context.Background(), "key", 5, 2*time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(res.Allowed, gc.Equals, false)
+	c.Check(res.Remaining, gc.Equals, 0)
+	c.Check(res.RetryAfter, gc.Equals, 1500*time.Millisecond)
+
+	// a key without ttl does not report a negative reset
+	l = NewFixedWindow(&replyConnector{reply: []interface{}{int64(2), int64(-1)}})
+	res, err = l.Allow(context.Background(), "key", 5, 2*time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(res.Reset, gc.Equals, time.Duration(0))
+}
+
+func (s *RateLimitTestSuite) TestSlidingWindowAllow(c *gc.C) {
+	l := NewSlidingWindow(&replyConnector{reply: []interface{}{int64(0), int64(5), int64(700)}})
+
+	res, err := l.Allow(context.Background(), "key", 5, time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(res, gc.Equals, Result{Limit: 5, Reset: 700 * time.Millisecond, RetryAfter: 700 * time.Millisecond})
+}
+
+func (s *RateLimitTestSuite) TestTokenBucketAllow(c *gc.C) {
+	l := NewTokenBucket(&replyConnector{reply: []interface{}{int64(1), int64(4), int64(0), int64(200)}})
+
+	res, err := l.Allow(context.Background(), "key", 5, time.Second)
+	c.Assert(err, gc.IsNil)
+	c.Check(res, gc.Equals, Result{Allowed: true, Limit: 5, Remaining: 4, Reset: 200 * time.Millisecond})
+}
+
+func (s *RateLimitTestSuite) TestInvalidLimits(c *gc.C) {
+	connector := &replyConnector{reply: []interface{}{int64(1), int64(1)}}
+	for _, l := range []Limiter{NewFixedWindow(connector), NewSlidingWindow(connector), NewTokenBucket(connector)} {
+		_, err := l.Allow(context.Background(), "key", 0, time.Second)
+		c.Check(err, gc.Equals, ErrInvalidLimit)
+
+		_, err = l.Allow(context.Background(), "key", 5, time.Microsecond)
+		c.Check(err, gc.Equals, ErrInvalidWindow)
+	}
+
+	_, err := NewMiddleware(NewTokenBucket(connector), -1, time.Second, nil, http.NotFoundHandler())
+	c.Check(err, gc.Equals, ErrInvalidLimit)
+
+	_, err = NewMiddleware(NewTokenBucket(connector), 5, 0, nil, http.NotFoundHandler())
+	c.Check(err, gc.Equals, ErrInvalidWindow)
+}
+
+func (s *RateLimitTestSuite) TestKeyByForwardedFor(c *gc.C) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Add("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+	r.Header.Add("X-Forwarded-For", "3.3.3.3")
+
+	c.Check(KeyByForwardedFor(1)(r), gc.Equals, "ratelimit:3.3.3.3")
+	c.Check(KeyByForwardedFor(2)(r), gc.Equals, "ratelimit:2.2.2.2")
+	c.Check(KeyByForwardedFor(4)(r), gc.Equals, "ratelimit:10.0.0.1")
+	c.Check(KeyByForwardedFor(0)(r), gc.Equals, "ratelimit:10.0.0.1")
+}
+
+func (s *RateLimitTestSuite) TestMiddlewareRejects(c *gc.C) {
+	var obtainedKey string
+	l := limiterFunc(func(key string) (Result, error) {
+		obtainedKey = key
+		return Result{Limit: 10, Reset: 1200 * time.Millisecond, RetryAfter: 300 * time.Millisecond}, nil
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Error("next handler must not be called")
+	})
+
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	w := httptest.NewRecorder()
+	m, err := NewMiddleware(l, 10, time.Minute, nil, next)
+	c.Assert(err, gc.IsNil)
+	m.ServeHTTP(w, r)
+
+	c.Check(obtainedKey, gc.Equals, "ratelimit:10.0.0.1")
+	c.Check(w.Code, gc.Equals, http.StatusTooManyRequests)
+	c.Check(w.Header().Get("X-RateLimit-Limit"), gc.Equals, "10")
+	c.Check(w.Header().Get("X-RateLimit-Remaining"), gc.Equals, "0")
+	c.Check(w.Header().Get("X-RateLimit-Reset"), gc.Equals, "2")
+	c.Check(w.Header().Get("Retry-After"), gc.Equals, "1")
+}
+
+func (s *RateLimitTestSuite) TestMiddlewarePassesOnLimiterError(c *gc.C) {
+	l := limiterFunc(func(string) (Result, error) {
+		return Result{}, errors.New("test error")
+	})
+
+	called := false
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+	})
+
+	var logs bytes.Buffer
+	w := httptest.NewRecorder()
+	m, err := NewMiddleware(l, 10, time.Minute, nil, next)
+	c.Assert(err, gc.IsNil)
+	m.Logger = log.New(&logs, "", 0)
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	c.Check(called, gc.Equals, true)
+	c.Check(w.Code, gc.Equals, http.StatusOK)
+	c.Check(logs.String(), gc.Equals, "ratelimit: allow ratelimit:192.0.2.1: test error\n")
+
+	// fail closed
+	called = false
+	w = httptest.NewRecorder()
+	m.FailClosed = true
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	c.Check(called, gc.Equals, false)
+	c.Check(w.Code, gc.Equals, http.StatusServiceUnavailable)
+}
@@ -3,28 +3,74 @@
 package redis
 
 import (
+	"context"
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
+	"log"
+	"sync/atomic"
 	"time"
 
-	"github.com/garyburd/redigo/redis"
+	"github.com/gomodule/redigo/redis"
 )
 
 type (
 	// RedisConfig is a struct type
 	// which holds redis connector
 	// pool configurations
+	//
+	// Durations are (un)marshaled from/to JSON as
+	// time.ParseDuration strings, e.g. "500ms"
 	RedisConfig struct {
-		Host           string
-		Password       string
-		Port           int
-		MaxIdleConns   int
-		MaxActiveConns int
-		IdleTimeout    time.Duration
-		ConnectTimeout time.Duration
-		ReadTimeout    time.Duration
-		WriteTimeout   time.Duration
+		Host           string        `json:"host"`
+		User           string        `json:"user"`
+		Password       string        `json:"password"`
+		Port           int           `json:"port"`
+		DB             int           `json:"db"`
+		MaxIdleConns   int           `json:"max_idle_conns"`
+		MaxActiveConns int           `json:"max_active_conns"`
+		IdleTimeout    time.Duration `json:"idle_timeout"`
+		ConnectTimeout time.Duration `json:"connect_timeout"`
+		ReadTimeout    time.Duration `json:"read_timeout"`
+		WriteTimeout   time.Duration `json:"write_timeout"`
+
+		// TLS enables TLS connections, TLSConfig
+		// is used if provided
+		TLS       bool        `json:"tls"`
+		TLSConfig *tls.Config `json:"-"`
+
+		// Wait makes the pool wait for a connection to be
+		// returned when MaxActiveConns limit is reached
+		// instead of failing with ErrPoolExhausted.
+		// Use ConnectContext to bound the waiting time
+		Wait bool `json:"wait"`
+
+		// BorrowCheckAfter defines the borrow-time health check
+		// (PING) policy for idle connections taken from the pool:
+		// zero value checks every borrowed connection, a positive
+		// value checks only connections idle longer than the value,
+		// DisableBorrowCheck turns the check off
+		BorrowCheckAfter time.Duration `json:"borrow_check_after"`
+
+		// Collector is an optional pool metrics collector
+		Collector MetricsCollector `json:"-"`
+
+		// Hooks holds a list of hooks which are called
+		// (in order) after every command executed on
+		// the pool connections, see SlowLog
+		Hooks []CommandHook `json:"-"`
+
+		// OnConnect holds a list of hooks which are executed
+		// (in order) on every newly established connection
+		// right after AUTH and SELECT commands
+		OnConnect []OnConnectFunc `json:"-"`
 	}
+
+	// OnConnectFunc is a func type which is
+	// called on a newly dialed redis connection.
+	// Returned error discards the connection
+	OnConnectFunc func(redis.Conn) error
 )
 
 // Address returns redis connection string
@@ -32,6 +78,30 @@ func (rc *RedisConfig) Address() string {
 	return fmt.Sprintf("%s:%d", rc.Host, rc.Port)
 }
 
+// ClientSetName returns an OnConnectFunc which sets
+// the connection name with CLIENT SETNAME command, so
+// the client can be distinguished in CLIENT LIST output
+func ClientSetName(name string) OnConnectFunc {
+	return func(c redis.Conn) error {
+		_, err := redis.String(c.Do("CLIENT", "SETNAME", name))
+		return err
+	}
+}
+
+// DisableBorrowCheck is a RedisConfig.BorrowCheckAfter value
+// which disables PING of idle connections on borrow
+const DisableBorrowCheck time.Duration = -1
+
+var (
+	// ErrPoolExhausted is returned when all the connector
+	// pools reached MaxActiveConns limit
+	ErrPoolExhausted = redis.ErrPoolExhausted
+
+	// ErrNoHealthyPool is returned when none of the connector
+	// pools was able to provide a healthy connection
+	ErrNoHealthyPool = errors.New("redis: no healthy pool available")
+)
+
 type (
 	// Connector is an interface type
 	// which describes methods for retrieving
@@ -41,6 +111,14 @@ type (
 		PingConnect() (redis.Conn, error)
 	}
 
+	// ContextConnector is an interface type
+	// which describes connectors retrieving
+	// a redis connection with a context
+	ContextConnector interface {
+		Connector
+		ConnectContext(ctx context.Context) (redis.Conn, error)
+	}
+
 	// RedisConnector is a struct type
 	// which implements a Connector interface
 	//
@@ -48,8 +126,8 @@ type (
 	// this provides a possibility to operate with multiple
 	// redis slave from one connector
 	RedisConnector struct {
-		pools         []*redis.Pool
-		activePoolIdx uint
+		pools         []*connPool
+		activePoolIdx uint32
 	}
 )
 
@@ -59,7 +137,7 @@ func NewRedisConnector(cfgs []RedisConfig) (*RedisConnector, error) {
 		return nil, errors.New("invalid argument: cfgs")
 	}
 
-	pools := make([]*redis.Pool, 0, len(cfgs))
+	pools := make([]*connPool, 0, len(cfgs))
 	for _, cfg := range cfgs {
 		pools = append(pools, newPool(cfg))
 	}
@@ -74,7 +152,57 @@ func NewRedisConnector(cfgs []RedisConfig) (*RedisConnector, error) {
 // In cases when it's needed to check if the connection
 // is alive, use PingConnect method
 func (c *RedisConnector) Connect() redis.Conn {
-	return c.pickPool().Get()
+	redisConn, _ := c.pickPool().borrow(context.Background())
+	return redisConn
+}
+
+// ConnectContext returns an available redis connection.
+// Pools are tried one by one starting from the next
+// round-robin pool until a healthy connection is retrieved.
+//
+// ctx bounds the time spent waiting for a connection
+// in case a pool is configured with Wait option; ctx.Err()
+// is returned when ctx is done.
+// ErrPoolExhausted is returned when all pools reached
+// MaxActiveConns limit, ErrNoHealthyPool (wrapping the last
+// pool error) is returned when no pool could provide
+// a healthy connection
+func (c *RedisConnector) ConnectContext(ctx context.Context) (redis.Conn, error) {
+	var (
+		exhausted int
+		lastErr   error
+	)
+
+	start := c.nextPoolIdx()
+	for i := 0; i < len(c.pools); i++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		redisConn, err := c.pools[(start+i)%len(c.pools)].borrow(ctx)
+		if err == nil {
+			return redisConn, nil
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+
+		if err == ErrConnectorClosed {
+			return nil, err
+		}
+
+		if err == redis.ErrPoolExhausted {
+			exhausted++
+		}
+		lastErr = err
+	}
+
+	if exhausted == len(c.pools) {
+		return nil, ErrPoolExhausted
+	}
+
+	return nil, fmt.Errorf("%w: %v", ErrNoHealthyPool, lastErr)
 }
 
 // PingConnect returns an awailable redis connection
@@ -82,28 +210,51 @@ func (c *RedisConnector) Connect() redis.Conn {
 // Second arguments is an error generated by a "PING"
 // method sent to a retrieved connection
 func (c *RedisConnector) PingConnect() (redis.Conn, error) {
-	redisConn := c.pickPool().Get()
+	redisConn, _ := c.pickPool().borrow(context.Background())
 	return redisConn, pingConn(redisConn, time.Now())
 }
 
+// EachPool calls fn with a connection retrieved
+// from every connector pool. All the pools are visited,
+// the first error is returned
+func (c *RedisConnector) EachPool(ctx context.Context, fn func(redis.Conn) error) error {
+	return eachPool(ctx, c.pools, fn)
+}
+
 // pickPool returns the next available pool from a Connector
 // pools are picked up based on round-robin pattern - one by one.
 // There is NO logic involved for picking up a pool based on balancing
 // awailable connections in the pool
-func (c *RedisConnector) pickPool() *redis.Pool {
+func (c *RedisConnector) pickPool() *connPool {
+	return c.pools[c.nextPoolIdx()]
+}
+
+// nextPoolIdx returns the index of the next round-robin pool.
+// The index is safe to be retrieved concurrently
+func (c *RedisConnector) nextPoolIdx() int {
 	if len(c.pools) == 1 {
-		return c.pools[0]
+		return 0
 	}
 
-	defer func() {
-		if uint(len(c.pools)-1) > c.activePoolIdx {
-			c.activePoolIdx++
-		} else {
-			c.activePoolIdx = 0
+	idx := atomic.AddUint32(&c.activePoolIdx, 1) - 1
+	return int(idx % uint32(len(c.pools)))
+}
+
+// eachPool calls fn with a connection retrieved
+// from every pool and returns the first error
+func eachPool(ctx context.Context, pools []*connPool, fn func(redis.Conn) error) (err error) {
+	for _, p := range pools {
+		redisConn, borrowErr := p.borrow(ctx)
+		if borrowErr == nil {
+			borrowErr = fn(redisConn)
+			redisConn.Close()
 		}
-	}()
 
-	return c.pools[c.activePoolIdx]
+		if borrowErr != nil && err == nil {
+			err = borrowErr
+		}
+	}
+	return err
 }
 
 // PingConn pings redis connection and returns an error
@@ -113,35 +264,100 @@ func pingConn(c redis.Conn, _ time.Time) error {
 	return err
 }
 
-// newPool returns a pointer to redis pool instance
-func newPool(cfg RedisConfig) *redis.Pool {
-	return &redis.Pool{
-		Dial:         dialFunc(cfg),
-		TestOnBorrow: pingConn,
-		MaxIdle:      cfg.MaxIdleConns,
-		MaxActive:    cfg.MaxActiveConns,
-		IdleTimeout:  cfg.IdleTimeout,
-	}
-}
-
 // dialFunc returns a func which handles establishing a
 // redis connection
 func dialFunc(cfg RedisConfig) func() (redis.Conn, error) {
 	return func() (redis.Conn, error) {
-		redisConn, err := redis.DialTimeout(
+		redisConn, err := redis.Dial(
 			"tcp",
 			cfg.Address(),
-			cfg.ConnectTimeout,
-			cfg.ReadTimeout,
-			cfg.WriteTimeout,
+			redis.DialConnectTimeout(cfg.ConnectTimeout),
+			redis.DialReadTimeout(cfg.ReadTimeout),
+			redis.DialWriteTimeout(cfg.WriteTimeout),
+			redis.DialUseTLS(cfg.TLS),
+			redis.DialTLSConfig(cfg.TLSConfig),
 		)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := initConn(redisConn, cfg); err != nil {
+			redisConn.Close()
+			return nil, err
+		}
+
+		return redisConn, nil
+	}
+}
+
+// initConn authenticates a newly dialed connection,
+// selects the configured database and runs OnConnect hooks
+func initConn(c redis.Conn, cfg RedisConfig) error {
+	if len(cfg.Password) > 0 {
+		args := redis.Args{}.Add(cfg.Password)
+		if len(cfg.User) > 0 {
+			// ACL authentication, redis >= 6.0
+			args = redis.Args{}.Add(cfg.User, cfg.Password)
+		}
+
+		if _, err := redis.String(c.Do("AUTH", args...)); err != nil {
+			return err
+		}
+	}
+
+	if cfg.DB > 0 {
+		if _, err := redis.String(c.Do("SELECT", cfg.DB)); err != nil {
+			return err
+		}
+	}
+
+	for _, onConnect := range cfg.OnConnect {
+		if err := onConnect(c); err != nil {
+			return err
+		}
+	}
 
-		if len(cfg.Password) > 0 && err == nil {
-			if _, err := redis.String(redisConn.Do("AUTH", cfg.Password)); err != nil {
-				return redisConn, err
-			}
+	return nil
+}
+
+// tryCatch calls f, catches a panic
+// if any is thrown and returns it as an error
+func tryCatch(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %s", r)
 		}
+	}()
+	return f()
+}
+
+// ConnectContext returns a connection retrieved from c
+// with ctx if c implements ContextConnector interface.
+// Otherwise ctx.Err() is returned if ctx is done and
+// the connection is retrieved with Connect, its error is
+// returned if the connection is not usable
+func ConnectContext(ctx context.Context, c Connector) (redis.Conn, error) {
+	if cc, ok := c.(ContextConnector); ok {
+		return cc.ConnectContext(ctx)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	redisConn := c.Connect()
+	if err := redisConn.Err(); err != nil {
+		redisConn.Close()
+		return nil, err
+	}
+	return redisConn, nil
+}
 
-		return redisConn, err
+// LoggerOrDiscard returns the logger,
+// a logger discarding the output if it is nil
+func LoggerOrDiscard(logger *log.Logger) *log.Logger {
+	if logger == nil {
+		return log.New(io.Discard, "", 0)
 	}
+	return logger
 }
@@ -0,0 +1,311 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type RedisTestSuite struct{}
+
+var _ = gc.Suite(&RedisTestSuite{})
+
+func TestRedis(t *testing.T) { gc.TestingT(t) }
+
+// stubConn is a redis.ConnWithTimeout implementation
+// which records issued commands and replies
+// with DoFunc results
+type stubConn struct {
+	cmds    []string
+	pending [][]interface{}
+	DoFunc  func(cmd string, args ...interface{}) (interface{}, error)
+}
+
+func (c *stubConn) Close() error { return nil }
+func (c *stubConn) Err() error   { return nil }
+func (c *stubConn) Flush() error { return nil }
+
+func (c *stubConn) Send(cmd string, args ...interface{}) error {
+	c.pending = append(c.pending, append([]interface{}{cmd}, args...))
+	return nil
+}
+
+func (c *stubConn) Receive() (interface{}, error) {
+	if len(c.pending) == 0 {
+		return nil, errors.New("no pending replies")
+	}
+
+	p := c.pending[0]
+	c.pending = c.pending[1:]
+	return c.Do(p[0].(string), p[1:]...)
+}
+
+func (c *stubConn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cmd == "" {
+		// pooled connections flush pending commands on close
+		return nil, nil
+	}
+
+	parts := []string{cmd}
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprintf("%v", arg))
+	}
+	c.cmds = append(c.cmds, strings.Join(parts, " "))
+
+	if c.DoFunc != nil {
+		return c.DoFunc(cmd, args...)
+	}
+	return "OK", nil
+}
+
+func (c *stubConn) DoWithTimeout(_ time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return c.Do(cmd, args...)
+}
+
+func (c *stubConn) ReceiveWithTimeout(time.Duration) (interface{}, error) {
+	return c.Receive()
+}
+
+func (s *RedisTestSuite) TestInitConnCommandsOrder(c *gc.C) {
+	conn := &stubConn{}
+	cfg := RedisConfig{
+		Password:  "secret",
+		DB:        3,
+		OnConnect: []OnConnectFunc{ClientSetName("scores@123456")},
+	}
+
+	c.Check(initConn(conn, cfg), gc.IsNil)
+	c.Check(conn.cmds, gc.DeepEquals, []string{
+		"AUTH secret",
+		"SELECT 3",
+		"CLIENT SETNAME scores@123456",
+	})
+}
+
+func (s *RedisTestSuite) TestInitConnACLAuth(c *gc.C) {
+	conn := &stubConn{}
+	c.Check(initConn(conn, RedisConfig{User: "scores", Password: "secret"}), gc.IsNil)
+	c.Check(conn.cmds, gc.DeepEquals, []string{"AUTH scores secret"})
+}
+
+func (s *RedisTestSuite) TestInitConnDefaults(c *gc.C) {
+	conn := &stubConn{}
+	c.Check(initConn(conn, RedisConfig{}), gc.IsNil)
+	c.Check(conn.cmds, gc.HasLen, 0)
+}
+
+func (s *RedisTestSuite) TestInitConnHookError(c *gc.C) {
+	expectedErr := errors.New("test error")
+
+	conn := &stubConn{}
+	cfg := RedisConfig{
+		OnConnect: []OnConnectFunc{
+			func(redis.Conn) error { return expectedErr },
+			ClientSetName("never"),
+		},
+	}
+
+	c.Check(initConn(conn, cfg), gc.Equals, expectedErr)
+	c.Check(conn.cmds, gc.HasLen, 0)
+}
+
+// newStubPool returns a pool which dials
+// stubConn instances replying with do results,
+// "OK" is replied to every command if do is nil
+func newStubPool(do func(cmd string, args ...interface{}) (interface{}, error)) *connPool {
+	return &connPool{Pool: &redis.Pool{
+		Dial: func() (redis.Conn, error) { return &stubConn{DoFunc: do}, nil },
+	}}
+}
+
+// newStubConnector returns a connector with a single
+// pool dialing stubConn instances replying with do results
+func newStubConnector(do func(cmd string, args ...interface{}) (interface{}, error)) *RedisConnector {
+	return &RedisConnector{pools: []*connPool{newStubPool(do)}}
+}
+
+// limitPool limits the pool active connections
+func limitPool(p *connPool, maxActive int, wait bool) *connPool {
+	p.MaxActive, p.Wait = maxActive, wait
+	return p
+}
+
+// recordStub records the sent commands
+// and replies with the reply func results
+type recordStub struct {
+	mu    sync.Mutex
+	cmds  []string
+	reply func(cmd string, args ...interface{}) (interface{}, error)
+}
+
+func (s *recordStub) do(cmd string, args ...interface{}) (interface{}, error) {
+	parts := []string{cmd}
+	for _, arg := range args {
+		parts = append(parts, fmt.Sprintf("%v", arg))
+	}
+
+	s.mu.Lock()
+	s.cmds = append(s.cmds, strings.Join(parts, " "))
+	s.mu.Unlock()
+
+	return s.reply(cmd, args...)
+}
+
+// commands returns the recorded commands with the prefix
+func (s *recordStub) commands(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var cmds []string
+	for _, cmd := range s.cmds {
+		if strings.HasPrefix(cmd, prefix) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+func (s *RedisTestSuite) TestNextPoolIdxRoundRobin(c *gc.C) {
+	connector := &RedisConnector{pools: []*connPool{newStubPool(nil), newStubPool(nil)}}
+
+	obtained := []int{connector.nextPoolIdx(), connector.nextPoolIdx(), connector.nextPoolIdx()}
+	c.Check(obtained, gc.DeepEquals, []int{0, 1, 0})
+}
+
+func (s *RedisTestSuite) TestConnectContextPoolExhausted(c *gc.C) {
+	connector := &RedisConnector{pools: []*connPool{limitPool(newStubPool(nil), 1, false), limitPool(newStubPool(nil), 1, false)}}
+
+	for i := 0; i < 2; i++ {
+		conn, err := connector.ConnectContext(context.Background())
+		c.Assert(err, gc.IsNil)
+		defer conn.Close()
+	}
+
+	conn, err := connector.ConnectContext(context.Background())
+	c.Check(conn, gc.IsNil)
+	c.Check(err, gc.Equals, ErrPoolExhausted)
+}
+
+func (s *RedisTestSuite) TestConnectContextNoHealthyPool(c *gc.C) {
+	expectedErr := errors.New("test error")
+	pool := &connPool{Pool: &redis.Pool{Dial: func() (redis.Conn, error) { return nil, expectedErr }}}
+	connector := &RedisConnector{pools: []*connPool{pool}}
+
+	conn, err := connector.ConnectContext(context.Background())
+	c.Check(conn, gc.IsNil)
+	c.Check(errors.Is(err, ErrNoHealthyPool), gc.Equals, true)
+	c.Check(err, gc.ErrorMatches, ".*test error")
+}
+
+func (s *RedisTestSuite) TestConnectContextDeadline(c *gc.C) {
+	connector := &RedisConnector{pools: []*connPool{limitPool(newStubPool(nil), 1, true)}}
+
+	conn, err := connector.ConnectContext(context.Background())
+	c.Assert(err, gc.IsNil)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	conn, err = connector.ConnectContext(ctx)
+	c.Check(conn, gc.IsNil)
+	c.Check(err, gc.Equals, context.DeadlineExceeded)
+}
+
+// legacyConnector implements only Connector interface
+type legacyConnector struct {
+	conn redis.Conn
+}
+
+func (l legacyConnector) Connect() redis.Conn              { return l.conn }
+func (l legacyConnector) PingConnect() (redis.Conn, error) { return l.conn, nil }
+
+func (s *RedisTestSuite) TestConnectContextFallsBackToConnect(c *gc.C) {
+	conn, err := ConnectContext(context.Background(), legacyConnector{&stubConn{}})
+	c.Check(err, gc.IsNil)
+	c.Check(conn, gc.FitsTypeOf, &stubConn{})
+
+	conn, err = ConnectContext(context.Background(), legacyConnector{errorConn{ErrConnectorClosed}})
+	c.Check(conn, gc.IsNil)
+	c.Check(err, gc.Equals, ErrConnectorClosed)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ConnectContext(ctx, legacyConnector{&stubConn{}})
+	c.Check(err, gc.Equals, context.Canceled)
+
+	// context connectors are used as is
+	connector := &RedisConnector{pools: []*connPool{limitPool(newStubPool(nil), 1, true)}}
+	conn, err = ConnectContext(context.Background(), connector)
+	c.Assert(err, gc.IsNil)
+	defer conn.Close()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = ConnectContext(ctx, connector)
+	c.Check(err, gc.Equals, context.DeadlineExceeded)
+}
+
+// newBenchPool returns a pool built with newPool
+// which dials stubConn instances replying to PING
+// with the rtt latency and counting the pings
+func newBenchPool(borrowCheckAfter, rtt time.Duration, pings *int64) *connPool {
+	pool := newPool(RedisConfig{MaxIdleConns: 1, BorrowCheckAfter: borrowCheckAfter})
+	pool.Dial = func() (redis.Conn, error) {
+		return &stubConn{DoFunc: func(cmd string, _ ...interface{}) (interface{}, error) {
+			if cmd == "PING" {
+				atomic.AddInt64(pings, 1)
+				time.Sleep(rtt)
+			}
+			return "OK", nil
+		}}, nil
+	}
+	return pool
+}
+
+func (s *RedisTestSuite) TestBorrowCheckPolicy(c *gc.C) {
+	cases := []struct {
+		after         time.Duration
+		expectedPings int64
+	}{
+		{0, 2},
+		{time.Hour, 0},
+		{DisableBorrowCheck, 0},
+	}
+
+	for _, tc := range cases {
+		var pings int64
+		connector := &RedisConnector{pools: []*connPool{newBenchPool(tc.after, 0, &pings)}}
+
+		for i := 0; i < 3; i++ {
+			connector.Connect().Close()
+		}
+
+		c.Check(atomic.LoadInt64(&pings), gc.Equals, tc.expectedPings, gc.Commentf("after: %s", tc.after))
+	}
+}
+
+func benchmarkConnect(b *testing.B, borrowCheckAfter time.Duration) {
+	var pings int64
+	connector := &RedisConnector{pools: []*connPool{newBenchPool(borrowCheckAfter, 50*time.Microsecond, &pings)}}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		connector.Connect().Close()
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&pings))/float64(b.N), "pings/op")
+}
+
+func BenchmarkConnectBorrowCheckAlways(b *testing.B) { benchmarkConnect(b, 0) }
+
+func BenchmarkConnectBorrowCheckIdle(b *testing.B) { benchmarkConnect(b, time.Second) }
+
+func BenchmarkConnectBorrowCheckDisabled(b *testing.B) { benchmarkConnect(b, DisableBorrowCheck) }
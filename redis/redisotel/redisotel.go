@@ -0,0 +1,70 @@
+// Package redisotel provides OpenTelemetry tracing
+// of the commands executed on the redis package connections:
+//
+//	cfg.Hooks = append(cfg.Hooks, redisotel.Hook(nil))
+//
+// Every command gets a client span, a child of the span held by
+// the context the connection was retrieved with (ConnectContext).
+// Command arguments are not recorded as they may hold sensitive data
+package redisotel
+
+import (
+	"context"
+	"net"
+	"strconv"
+
+	"github.com/onefootball/samodelkin/redis"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// TracerName holds the name of the tracer
+// used if Hook is called with nil tracer
+const TracerName = "github.com/onefootball/samodelkin/redis"
+
+// Hook returns a redis.CommandHook which records
+// a span for every executed command.
+// The global tracer provider is used if tracer is nil
+func Hook(tracer trace.Tracer) redis.CommandHook {
+	if tracer == nil {
+		tracer = otel.Tracer(TracerName)
+	}
+
+	return func(ctx context.Context, info redis.CommandInfo) {
+		_, span := tracer.Start(ctx, info.Name,
+			trace.WithSpanKind(trace.SpanKindClient),
+			trace.WithTimestamp(info.Start),
+			trace.WithAttributes(attributes(info)...),
+		)
+
+		if info.Err != nil {
+			span.RecordError(info.Err)
+			span.SetStatus(codes.Error, info.Err.Error())
+		}
+
+		span.End(trace.WithTimestamp(info.Start.Add(info.Duration)))
+	}
+}
+
+// attributes returns the span attributes
+// following the database semantic conventions
+func attributes(info redis.CommandInfo) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", info.Name),
+	}
+
+	host, port, err := net.SplitHostPort(info.Address)
+	if err != nil {
+		return append(attrs, attribute.String("server.address", info.Address))
+	}
+
+	attrs = append(attrs, attribute.String("server.address", host))
+	if p, err := strconv.Atoi(port); err == nil {
+		attrs = append(attrs, attribute.Int("server.port", p))
+	}
+
+	return attrs
+}
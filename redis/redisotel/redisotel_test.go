@@ -0,0 +1,55 @@
+package redisotel
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/onefootball/samodelkin/redis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type RedisOTelTestSuite struct{}
+
+var _ = gc.Suite(&RedisOTelTestSuite{})
+
+func TestRedisOTel(t *testing.T) { gc.TestingT(t) }
+
+func (s *RedisOTelTestSuite) TestHookRecordsSpans(c *gc.C) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("test")
+	hook := Hook(tracer)
+
+	ctx, parent := tracer.Start(context.Background(), "handler")
+	start := time.Now()
+	hook(ctx, redis.CommandInfo{Name: "GET", Address: "redis.local:6379", Start: start, Duration: 3 * time.Millisecond})
+	hook(ctx, redis.CommandInfo{Name: "SET", Address: "redis.local", Start: start, Err: errors.New("READONLY")})
+	parent.End()
+
+	spans := recorder.Ended()
+	c.Assert(spans, gc.HasLen, 3)
+
+	get := spans[0]
+	c.Check(get.Name(), gc.Equals, "GET")
+	c.Check(get.SpanKind(), gc.Equals, trace.SpanKindClient)
+	c.Check(get.Parent().SpanID(), gc.Equals, parent.SpanContext().SpanID())
+	c.Check(get.EndTime().Sub(get.StartTime()), gc.Equals, 3*time.Millisecond)
+	c.Check(get.Attributes(), gc.DeepEquals, []attribute.KeyValue{
+		attribute.String("db.system", "redis"),
+		attribute.String("db.operation", "GET"),
+		attribute.String("server.address", "redis.local"),
+		attribute.Int("server.port", 6379),
+	})
+	c.Check(get.Status().Code, gc.Equals, codes.Unset)
+
+	set := spans[1]
+	c.Check(set.Status(), gc.Equals, sdktrace.Status{Code: codes.Error, Description: "READONLY"})
+	c.Check(set.Events(), gc.HasLen, 1)
+	c.Check(set.Attributes()[2], gc.Equals, attribute.String("server.address", "redis.local"))
+}
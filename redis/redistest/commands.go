@@ -0,0 +1,1346 @@
+package redistest
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	kindString = "string"
+	kindHash   = "hash"
+	kindList   = "list"
+	kindSet    = "set"
+	kindZSet   = "zset"
+)
+
+// cmdSpec describes a command arity and implementation,
+// maxArgs is negative for variadic commands
+type cmdSpec struct {
+	minArgs int
+	maxArgs int
+	fn      func(c *Connector, args [][]byte) reply
+}
+
+var (
+	okReply        = reply{value: "OK"}
+	nilReply       = reply{}
+	wrongTypeReply = errReply("WRONGTYPE Operation against a key holding the wrong kind of value")
+	notIntReply    = errReply("ERR value is not an integer or out of range")
+	notFloatReply  = errReply("ERR value is not a valid float")
+	minMaxReply    = errReply("ERR min or max is not a float")
+	syntaxReply    = errReply("ERR syntax error")
+)
+
+// commands holds the supported commands
+var commands = map[string]cmdSpec{
+	// connection
+	"PING":   {0, 1, cmdPing},
+	"ECHO":   {1, 1, cmdEcho},
+	"AUTH":   {1, 2, cmdOK},
+	"SELECT": {1, 1, cmdOK},
+	"CLIENT": {1, -1, cmdOK},
+
+	// pub/sub, the fake has no subscribers
+	"PUBLISH": {2, 2, cmdPublish},
+
+	// keys
+	"DEL":      {1, -1, cmdDel},
+	"UNLINK":   {1, -1, cmdDel},
+	"EXISTS":   {1, -1, cmdExists},
+	"EXPIRE":   {2, 2, cmdExpire(time.Second)},
+	"PEXPIRE":  {2, 2, cmdExpire(time.Millisecond)},
+	"TTL":      {1, 1, cmdTTL(time.Second)},
+	"PTTL":     {1, 1, cmdTTL(time.Millisecond)},
+	"PERSIST":  {1, 1, cmdPersist},
+	"TYPE":     {1, 1, cmdType},
+	"KEYS":     {1, 1, cmdKeys},
+	"DBSIZE":   {0, 0, cmdDBSize},
+	"FLUSHDB":  {0, 1, cmdFlush},
+	"FLUSHALL": {0, 1, cmdFlush},
+
+	// strings
+	"GET":    {1, 1, cmdGet},
+	"SET":    {2, -1, cmdSet},
+	"SETNX":  {2, 2, cmdSetNX},
+	"SETEX":  {3, 3, cmdSetEX(time.Second)},
+	"PSETEX": {3, 3, cmdSetEX(time.Millisecond)},
+	"GETSET": {2, 2, cmdGetSet},
+	"GETDEL": {1, 1, cmdGetDel},
+	"MGET":   {1, -1, cmdMGet},
+	"MSET":   {2, -1, cmdMSet},
+	"INCR":   {1, 1, cmdIncr(1)},
+	"DECR":   {1, 1, cmdIncr(-1)},
+	"INCRBY": {2, 2, cmdIncrBy(1)},
+	"DECRBY": {2, 2, cmdIncrBy(-1)},
+	"APPEND": {2, 2, cmdAppend},
+	"STRLEN": {1, 1, cmdStrlen},
+
+	// hashes
+	"HSET":    {3, -1, cmdHSet},
+	"HMSET":   {3, -1, cmdHMSet},
+	"HSETNX":  {3, 3, cmdHSetNX},
+	"HGET":    {2, 2, cmdHGet},
+	"HMGET":   {2, -1, cmdHMGet},
+	"HGETALL": {1, 1, cmdHGetAll},
+	"HDEL":    {2, -1, cmdHDel},
+	"HEXISTS": {2, 2, cmdHExists},
+	"HLEN":    {1, 1, cmdHLen},
+	"HKEYS":   {1, 1, cmdHKeys},
+	"HVALS":   {1, 1, cmdHVals},
+	"HINCRBY": {3, 3, cmdHIncrBy},
+
+	// lists
+	"LPUSH":  {2, -1, cmdPush(true)},
+	"RPUSH":  {2, -1, cmdPush(false)},
+	"LPOP":   {1, 1, cmdPop(true)},
+	"RPOP":   {1, 1, cmdPop(false)},
+	"LLEN":   {1, 1, cmdLLen},
+	"LRANGE": {3, 3, cmdLRange},
+	"LINDEX": {2, 2, cmdLIndex},
+	"LTRIM":  {3, 3, cmdLTrim},
+	"LREM":   {3, 3, cmdLRem},
+
+	// sets
+	"SADD":      {2, -1, cmdSAdd},
+	"SREM":      {2, -1, cmdSRem},
+	"SMEMBERS":  {1, 1, cmdSMembers},
+	"SISMEMBER": {2, 2, cmdSIsMember},
+	"SCARD":     {1, 1, cmdSCard},
+
+	// sorted sets
+	"ZADD":             {3, -1, cmdZAdd},
+	"ZINCRBY":          {3, 3, cmdZIncrBy},
+	"ZREM":             {2, -1, cmdZRem},
+	"ZSCORE":           {2, 2, cmdZScore},
+	"ZCARD":            {1, 1, cmdZCard},
+	"ZRANGE":           {3, 4, cmdZRange},
+	"ZRANGEBYSCORE":    {3, -1, cmdZRangeByScore},
+	"ZREMRANGEBYSCORE": {3, 3, cmdZRemRangeByScore},
+}
+
+// lookup returns the key entry, expired keys are removed
+func (c *Connector) lookup(key string) *entry {
+	e, ok := c.keys[key]
+	if !ok {
+		return nil
+	}
+
+	if !e.expireAt.IsZero() && !c.now().Before(e.expireAt) {
+		delete(c.keys, key)
+		return nil
+	}
+
+	return e
+}
+
+// typed returns the key entry of the kind, ok is false
+// if the key holds a value of another kind
+func (c *Connector) typed(key, kind string) (e *entry, ok bool) {
+	e = c.lookup(key)
+	return e, e == nil || e.kind == kind
+}
+
+// create returns the key entry of the kind
+// creating it if the key does not exist
+func (c *Connector) create(key, kind string) (*entry, bool) {
+	e, ok := c.typed(key, kind)
+	if !ok || e != nil {
+		return e, ok
+	}
+
+	e = &entry{kind: kind}
+	switch kind {
+	case kindHash:
+		e.hash = make(map[string][]byte)
+	case kindSet:
+		e.set = make(map[string]struct{})
+	case kindZSet:
+		e.zset = make(map[string]float64)
+	}
+
+	c.keys[key] = e
+	return e, true
+}
+
+// cleanup removes the key if its collection is empty
+func (c *Connector) cleanup(key string, e *entry) {
+	if len(e.hash) == 0 && len(e.list) == 0 && len(e.set) == 0 && len(e.zset) == 0 && e.kind != kindString {
+		delete(c.keys, key)
+	}
+}
+
+// setString sets the key to the string value resetting its ttl
+func (c *Connector) setString(key string, value []byte) *entry {
+	e := &entry{kind: kindString, str: value}
+	c.keys[key] = e
+	return e
+}
+
+func cmdOK(*Connector, [][]byte) reply {
+	return okReply
+}
+
+func cmdPing(_ *Connector, args [][]byte) reply {
+	if len(args) == 1 {
+		return reply{value: args[0]}
+	}
+	return reply{value: "PONG"}
+}
+
+func cmdEcho(_ *Connector, args [][]byte) reply {
+	return reply{value: args[0]}
+}
+
+func cmdPublish(*Connector, [][]byte) reply {
+	return reply{value: int64(0)}
+}
+
+func cmdDel(c *Connector, args [][]byte) reply {
+	var n int64
+	for _, key := range args {
+		if c.lookup(string(key)) != nil {
+			delete(c.keys, string(key))
+			n++
+		}
+	}
+	return reply{value: n}
+}
+
+func cmdExists(c *Connector, args [][]byte) reply {
+	var n int64
+	for _, key := range args {
+		if c.lookup(string(key)) != nil {
+			n++
+		}
+	}
+	return reply{value: n}
+}
+
+func cmdExpire(unit time.Duration) func(*Connector, [][]byte) reply {
+	return func(c *Connector, args [][]byte) reply {
+		ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return notIntReply
+		}
+
+		e := c.lookup(string(args[0]))
+		if e == nil {
+			return reply{value: int64(0)}
+		}
+
+		if ttl <= 0 {
+			delete(c.keys, string(args[0]))
+		} else {
+			e.expireAt = c.now().Add(time.Duration(ttl) * unit)
+		}
+
+		return reply{value: int64(1)}
+	}
+}
+
+func cmdTTL(unit time.Duration) func(*Connector, [][]byte) reply {
+	return func(c *Connector, args [][]byte) reply {
+		e := c.lookup(string(args[0]))
+		if e == nil {
+			return reply{value: int64(-2)}
+		}
+
+		if e.expireAt.IsZero() {
+			return reply{value: int64(-1)}
+		}
+
+		// redis rounds the remaining time to the closest unit
+		ttl := e.expireAt.Sub(c.now())
+		return reply{value: int64((ttl + unit/2) / unit)}
+	}
+}
+
+func cmdPersist(c *Connector, args [][]byte) reply {
+	e := c.lookup(string(args[0]))
+	if e == nil || e.expireAt.IsZero() {
+		return reply{value: int64(0)}
+	}
+
+	e.expireAt = time.Time{}
+	return reply{value: int64(1)}
+}
+
+func cmdType(c *Connector, args [][]byte) reply {
+	e := c.lookup(string(args[0]))
+	if e == nil {
+		return reply{value: "none"}
+	}
+	return reply{value: e.kind}
+}
+
+func cmdKeys(c *Connector, args [][]byte) reply {
+	var keys []string
+	for key := range c.keys {
+		if c.lookup(key) != nil && match(string(args[0]), key) {
+			keys = append(keys, key)
+		}
+	}
+	return reply{value: sortedBulks(keys)}
+}
+
+func cmdDBSize(c *Connector, _ [][]byte) reply {
+	var n int64
+	for key := range c.keys {
+		if c.lookup(key) != nil {
+			n++
+		}
+	}
+	return reply{value: n}
+}
+
+func cmdFlush(c *Connector, _ [][]byte) reply {
+	c.keys = make(map[string]*entry)
+	return okReply
+}
+
+func cmdGet(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindString)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return nilReply
+	}
+
+	return reply{value: e.str}
+}
+
+// cmdSet supports EX, PX, NX, XX and KEEPTTL options
+func cmdSet(c *Connector, args [][]byte) reply {
+	key := string(args[0])
+
+	var ttl time.Duration
+	var nx, xx, keepTTL bool
+	for i := 2; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX":
+			if i+1 == len(args) {
+				return syntaxReply
+			}
+
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+			if err != nil {
+				return notIntReply
+			}
+
+			if n <= 0 {
+				return errReply("ERR invalid expire time in 'set' command")
+			}
+
+			ttl = time.Duration(n) * time.Millisecond
+			if strings.ToUpper(string(args[i])) == "EX" {
+				ttl = time.Duration(n) * time.Second
+			}
+			i++
+		default:
+			return syntaxReply
+		}
+	}
+
+	if (nx && xx) || (keepTTL && ttl > 0) {
+		return syntaxReply
+	}
+
+	prev := c.lookup(key)
+	if (nx && prev != nil) || (xx && prev == nil) {
+		return nilReply
+	}
+
+	e := c.setString(key, args[1])
+	if ttl > 0 {
+		e.expireAt = c.now().Add(ttl)
+	} else if keepTTL && prev != nil {
+		e.expireAt = prev.expireAt
+	}
+
+	return okReply
+}
+
+func cmdSetNX(c *Connector, args [][]byte) reply {
+	if c.lookup(string(args[0])) != nil {
+		return reply{value: int64(0)}
+	}
+
+	c.setString(string(args[0]), args[1])
+	return reply{value: int64(1)}
+}
+
+func cmdSetEX(unit time.Duration) func(*Connector, [][]byte) reply {
+	return func(c *Connector, args [][]byte) reply {
+		ttl, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return notIntReply
+		}
+
+		if ttl <= 0 {
+			return errReply("ERR invalid expire time")
+		}
+
+		e := c.setString(string(args[0]), args[2])
+		e.expireAt = c.now().Add(time.Duration(ttl) * unit)
+		return okReply
+	}
+}
+
+func cmdGetSet(c *Connector, args [][]byte) reply {
+	r := cmdGet(c, args[:1])
+	if r.err != nil {
+		return r
+	}
+
+	c.setString(string(args[0]), args[1])
+	return r
+}
+
+func cmdGetDel(c *Connector, args [][]byte) reply {
+	r := cmdGet(c, args)
+	if r.err == nil && r.value != nil {
+		delete(c.keys, string(args[0]))
+	}
+	return r
+}
+
+func cmdMGet(c *Connector, args [][]byte) reply {
+	values := make([]interface{}, len(args))
+	for i, key := range args {
+		if e, ok := c.typed(string(key), kindString); ok && e != nil {
+			values[i] = e.str
+		}
+	}
+	return reply{value: values}
+}
+
+func cmdMSet(c *Connector, args [][]byte) reply {
+	if len(args)%2 != 0 {
+		return errReply("ERR wrong number of arguments for 'mset' command")
+	}
+
+	for i := 0; i < len(args); i += 2 {
+		c.setString(string(args[i]), args[i+1])
+	}
+	return okReply
+}
+
+func cmdIncr(sign int64) func(*Connector, [][]byte) reply {
+	return func(c *Connector, args [][]byte) reply {
+		return incrBy(c, string(args[0]), sign)
+	}
+}
+
+func cmdIncrBy(sign int64) func(*Connector, [][]byte) reply {
+	return func(c *Connector, args [][]byte) reply {
+		n, err := strconv.ParseInt(string(args[1]), 10, 64)
+		if err != nil {
+			return notIntReply
+		}
+		return incrBy(c, string(args[0]), sign*n)
+	}
+}
+
+// incrBy increments the key integer value by n keeping its ttl
+func incrBy(c *Connector, key string, n int64) reply {
+	e, ok := c.create(key, kindString)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	var value int64
+	if e.str != nil {
+		v, err := strconv.ParseInt(string(e.str), 10, 64)
+		if err != nil {
+			return notIntReply
+		}
+		value = v
+	}
+
+	value += n
+	e.str = []byte(strconv.FormatInt(value, 10))
+	return reply{value: value}
+}
+
+func cmdAppend(c *Connector, args [][]byte) reply {
+	e, ok := c.create(string(args[0]), kindString)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	e.str = append(append([]byte{}, e.str...), args[1]...)
+	return reply{value: int64(len(e.str))}
+}
+
+func cmdStrlen(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindString)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return reply{value: int64(0)}
+	}
+
+	return reply{value: int64(len(e.str))}
+}
+
+func cmdHSet(c *Connector, args [][]byte) reply {
+	if len(args)%2 != 1 {
+		return errReply("ERR wrong number of arguments for 'hset' command")
+	}
+
+	e, ok := c.create(string(args[0]), kindHash)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	var n int64
+	for i := 1; i < len(args); i += 2 {
+		if _, ok := e.hash[string(args[i])]; !ok {
+			n++
+		}
+		e.hash[string(args[i])] = args[i+1]
+	}
+	return reply{value: n}
+}
+
+func cmdHMSet(c *Connector, args [][]byte) reply {
+	if r := cmdHSet(c, args); r.err != nil {
+		return r
+	}
+	return okReply
+}
+
+func cmdHSetNX(c *Connector, args [][]byte) reply {
+	e, ok := c.create(string(args[0]), kindHash)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if _, ok := e.hash[string(args[1])]; ok {
+		return reply{value: int64(0)}
+	}
+
+	e.hash[string(args[1])] = args[2]
+	return reply{value: int64(1)}
+}
+
+func cmdHGet(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindHash)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return nilReply
+	}
+
+	if value, ok := e.hash[string(args[1])]; ok {
+		return reply{value: value}
+	}
+	return nilReply
+}
+
+func cmdHMGet(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindHash)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	values := make([]interface{}, len(args)-1)
+	for i, field := range args[1:] {
+		if e == nil {
+			continue
+		}
+
+		if value, ok := e.hash[string(field)]; ok {
+			values[i] = value
+		}
+	}
+	return reply{value: values}
+}
+
+// cmdHGetAll replies with the fields sorted by name
+func cmdHGetAll(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindHash)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	values := []interface{}{}
+	if e == nil {
+		return reply{value: values}
+	}
+
+	for _, field := range sortedKeys(e.hash) {
+		values = append(values, []byte(field), e.hash[field])
+	}
+	return reply{value: values}
+}
+
+func cmdHDel(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindHash)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	var n int64
+	if e == nil {
+		return reply{value: n}
+	}
+
+	for _, field := range args[1:] {
+		if _, ok := e.hash[string(field)]; ok {
+			delete(e.hash, string(field))
+			n++
+		}
+	}
+
+	c.cleanup(string(args[0]), e)
+	return reply{value: n}
+}
+
+func cmdHExists(c *Connector, args [][]byte) reply {
+	r := cmdHGet(c, args)
+	if r.err != nil {
+		return r
+	}
+
+	if r.value == nil {
+		return reply{value: int64(0)}
+	}
+	return reply{value: int64(1)}
+}
+
+func cmdHLen(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindHash)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return reply{value: int64(0)}
+	}
+	return reply{value: int64(len(e.hash))}
+}
+
+// cmdHKeys replies with the fields sorted by name
+func cmdHKeys(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindHash)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return reply{value: []interface{}{}}
+	}
+	return reply{value: sortedBulks(sortedKeys(e.hash))}
+}
+
+// cmdHVals replies with the values sorted by field name
+func cmdHVals(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindHash)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	values := []interface{}{}
+	if e == nil {
+		return reply{value: values}
+	}
+
+	for _, field := range sortedKeys(e.hash) {
+		values = append(values, e.hash[field])
+	}
+	return reply{value: values}
+}
+
+func cmdHIncrBy(c *Connector, args [][]byte) reply {
+	n, err := strconv.ParseInt(string(args[2]), 10, 64)
+	if err != nil {
+		return notIntReply
+	}
+
+	e, ok := c.create(string(args[0]), kindHash)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	var value int64
+	if current, ok := e.hash[string(args[1])]; ok {
+		if value, err = strconv.ParseInt(string(current), 10, 64); err != nil {
+			return errReply("ERR hash value is not an integer")
+		}
+	}
+
+	value += n
+	e.hash[string(args[1])] = []byte(strconv.FormatInt(value, 10))
+	return reply{value: value}
+}
+
+func cmdPush(left bool) func(*Connector, [][]byte) reply {
+	return func(c *Connector, args [][]byte) reply {
+		e, ok := c.create(string(args[0]), kindList)
+		if !ok {
+			return wrongTypeReply
+		}
+
+		for _, value := range args[1:] {
+			if left {
+				e.list = append([][]byte{value}, e.list...)
+			} else {
+				e.list = append(e.list, value)
+			}
+		}
+		return reply{value: int64(len(e.list))}
+	}
+}
+
+func cmdPop(left bool) func(*Connector, [][]byte) reply {
+	return func(c *Connector, args [][]byte) reply {
+		e, ok := c.typed(string(args[0]), kindList)
+		if !ok {
+			return wrongTypeReply
+		}
+
+		if e == nil {
+			return nilReply
+		}
+
+		var value []byte
+		if left {
+			value, e.list = e.list[0], e.list[1:]
+		} else {
+			value, e.list = e.list[len(e.list)-1], e.list[:len(e.list)-1]
+		}
+
+		c.cleanup(string(args[0]), e)
+		return reply{value: value}
+	}
+}
+
+func cmdLLen(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindList)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return reply{value: int64(0)}
+	}
+	return reply{value: int64(len(e.list))}
+}
+
+func cmdLRange(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindList)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	start, stop, ok := listRange(args[1], args[2], e)
+	if !ok {
+		return notIntReply
+	}
+
+	values := []interface{}{}
+	for i := start; i <= stop; i++ {
+		values = append(values, e.list[i])
+	}
+	return reply{value: values}
+}
+
+func cmdLIndex(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindList)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	i, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return notIntReply
+	}
+
+	if e == nil {
+		return nilReply
+	}
+
+	if i < 0 {
+		i += len(e.list)
+	}
+
+	if i < 0 || i >= len(e.list) {
+		return nilReply
+	}
+	return reply{value: e.list[i]}
+}
+
+func cmdLTrim(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindList)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	start, stop, ok := listRange(args[1], args[2], e)
+	if !ok {
+		return notIntReply
+	}
+
+	if e == nil {
+		return okReply
+	}
+
+	e.list = e.list[start : stop+1]
+	c.cleanup(string(args[0]), e)
+	return okReply
+}
+
+// cmdLRem removes count occurrences of the value from the head,
+// from the tail if count is negative, all of them if zero
+func cmdLRem(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindList)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	count, err := strconv.Atoi(string(args[1]))
+	if err != nil {
+		return notIntReply
+	}
+
+	var n int64
+	if e == nil {
+		return reply{value: n}
+	}
+
+	limit := count
+	if limit < 0 {
+		limit = -limit
+		reverse(e.list)
+	}
+
+	list := e.list[:0]
+	for _, value := range e.list {
+		if string(value) == string(args[2]) && (limit == 0 || n < int64(limit)) {
+			n++
+			continue
+		}
+		list = append(list, value)
+	}
+
+	if count < 0 {
+		reverse(list)
+	}
+
+	e.list = list
+	c.cleanup(string(args[0]), e)
+	return reply{value: n}
+}
+
+func cmdSAdd(c *Connector, args [][]byte) reply {
+	e, ok := c.create(string(args[0]), kindSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	var n int64
+	for _, member := range args[1:] {
+		if _, ok := e.set[string(member)]; !ok {
+			e.set[string(member)] = struct{}{}
+			n++
+		}
+	}
+	return reply{value: n}
+}
+
+func cmdSRem(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	var n int64
+	if e == nil {
+		return reply{value: n}
+	}
+
+	for _, member := range args[1:] {
+		if _, ok := e.set[string(member)]; ok {
+			delete(e.set, string(member))
+			n++
+		}
+	}
+
+	c.cleanup(string(args[0]), e)
+	return reply{value: n}
+}
+
+// cmdSMembers replies with the members sorted
+func cmdSMembers(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return reply{value: []interface{}{}}
+	}
+
+	members := make([]string, 0, len(e.set))
+	for member := range e.set {
+		members = append(members, member)
+	}
+	return reply{value: sortedBulks(members)}
+}
+
+func cmdSIsMember(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return reply{value: int64(0)}
+	}
+
+	if _, ok := e.set[string(args[1])]; ok {
+		return reply{value: int64(1)}
+	}
+	return reply{value: int64(0)}
+}
+
+func cmdSCard(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return reply{value: int64(0)}
+	}
+	return reply{value: int64(len(e.set))}
+}
+
+// cmdZAdd supports NX and XX options
+func cmdZAdd(c *Connector, args [][]byte) reply {
+	var nx, xx bool
+	i := 1
+	for ; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "NX":
+			nx = true
+			continue
+		case "XX":
+			xx = true
+			continue
+		}
+		break
+	}
+
+	pairs := args[i:]
+	if len(pairs) == 0 || len(pairs)%2 != 0 || (nx && xx) {
+		return syntaxReply
+	}
+
+	scores := make([]float64, len(pairs)/2)
+	for j := range scores {
+		score, err := strconv.ParseFloat(string(pairs[2*j]), 64)
+		if err != nil || math.IsNaN(score) {
+			return notFloatReply
+		}
+		scores[j] = score
+	}
+
+	e, ok := c.create(string(args[0]), kindZSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	var n int64
+	for j, score := range scores {
+		member := string(pairs[2*j+1])
+		_, exists := e.zset[member]
+		if (nx && exists) || (xx && !exists) {
+			continue
+		}
+
+		if !exists {
+			n++
+		}
+		e.zset[member] = score
+	}
+
+	c.cleanup(string(args[0]), e)
+	return reply{value: n}
+}
+
+func cmdZIncrBy(c *Connector, args [][]byte) reply {
+	n, err := strconv.ParseFloat(string(args[1]), 64)
+	if err != nil || math.IsNaN(n) {
+		return notFloatReply
+	}
+
+	e, ok := c.create(string(args[0]), kindZSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	e.zset[string(args[2])] += n
+	return reply{value: formatScore(e.zset[string(args[2])])}
+}
+
+func cmdZRem(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindZSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	var n int64
+	if e == nil {
+		return reply{value: n}
+	}
+
+	for _, member := range args[1:] {
+		if _, ok := e.zset[string(member)]; ok {
+			delete(e.zset, string(member))
+			n++
+		}
+	}
+
+	c.cleanup(string(args[0]), e)
+	return reply{value: n}
+}
+
+func cmdZScore(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindZSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return nilReply
+	}
+
+	if score, ok := e.zset[string(args[1])]; ok {
+		return reply{value: formatScore(score)}
+	}
+	return nilReply
+}
+
+func cmdZCard(c *Connector, args [][]byte) reply {
+	e, ok := c.typed(string(args[0]), kindZSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	if e == nil {
+		return reply{value: int64(0)}
+	}
+	return reply{value: int64(len(e.zset))}
+}
+
+// cmdZRange supports the index ranges and WITHSCORES option
+func cmdZRange(c *Connector, args [][]byte) reply {
+	withScores := len(args) == 4
+	if withScores && strings.ToUpper(string(args[3])) != "WITHSCORES" {
+		return syntaxReply
+	}
+
+	e, ok := c.typed(string(args[0]), kindZSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	members := sortedMembers(e)
+	start, stop, ok := indexRange(args[1], args[2], len(members))
+	if !ok {
+		return notIntReply
+	}
+
+	return zsetReply(e, members[start:stop+1], withScores)
+}
+
+// cmdZRangeByScore supports WITHSCORES and LIMIT options
+func cmdZRangeByScore(c *Connector, args [][]byte) reply {
+	min, minExcl, ok := parseScoreBound(args[1])
+	if !ok {
+		return minMaxReply
+	}
+
+	max, maxExcl, ok := parseScoreBound(args[2])
+	if !ok {
+		return minMaxReply
+	}
+
+	var withScores bool
+	offset, count := 0, -1
+	for i := 3; i < len(args); i++ {
+		switch strings.ToUpper(string(args[i])) {
+		case "WITHSCORES":
+			withScores = true
+		case "LIMIT":
+			if i+2 >= len(args) {
+				return syntaxReply
+			}
+
+			var err error
+			if offset, err = strconv.Atoi(string(args[i+1])); err != nil {
+				return notIntReply
+			}
+			if count, err = strconv.Atoi(string(args[i+2])); err != nil {
+				return notIntReply
+			}
+			i += 2
+		default:
+			return syntaxReply
+		}
+	}
+
+	e, ok := c.typed(string(args[0]), kindZSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	var members []string
+	for _, member := range sortedMembers(e) {
+		if inScoreRange(e.zset[member], min, max, minExcl, maxExcl) {
+			members = append(members, member)
+		}
+	}
+
+	if offset < 0 || offset >= len(members) {
+		members = nil
+	} else {
+		members = members[offset:]
+	}
+
+	if count >= 0 && count < len(members) {
+		members = members[:count]
+	}
+
+	return zsetReply(e, members, withScores)
+}
+
+func cmdZRemRangeByScore(c *Connector, args [][]byte) reply {
+	min, minExcl, ok := parseScoreBound(args[1])
+	if !ok {
+		return minMaxReply
+	}
+
+	max, maxExcl, ok := parseScoreBound(args[2])
+	if !ok {
+		return minMaxReply
+	}
+
+	e, ok := c.typed(string(args[0]), kindZSet)
+	if !ok {
+		return wrongTypeReply
+	}
+
+	var n int64
+	if e == nil {
+		return reply{value: n}
+	}
+
+	for member, score := range e.zset {
+		if inScoreRange(score, min, max, minExcl, maxExcl) {
+			delete(e.zset, member)
+			n++
+		}
+	}
+
+	c.cleanup(string(args[0]), e)
+	return reply{value: n}
+}
+
+// listRange converts redis start/stop indexes into the
+// list bounds, stop is less than start for empty ranges
+func listRange(startArg, stopArg []byte, e *entry) (start, stop int, ok bool) {
+	var n int
+	if e != nil {
+		n = len(e.list)
+	}
+
+	return indexRange(startArg, stopArg, n)
+}
+
+// indexRange converts redis start/stop indexes into the
+// bounds of a collection of n items
+func indexRange(startArg, stopArg []byte, n int) (start, stop int, ok bool) {
+	start, err := strconv.Atoi(string(startArg))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	stop, err = strconv.Atoi(string(stopArg))
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return clampRange(start, stop, n)
+}
+
+// clampRange resolves the negative indexes and clamps
+// them to the n items bounds, stop is less than start
+// for empty ranges
+func clampRange(start, stop, n int) (int, int, bool) {
+	if start < 0 {
+		start += n
+	}
+
+	if stop < 0 {
+		stop += n
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if stop >= n {
+		stop = n - 1
+	}
+
+	if start > stop {
+		return 0, -1, true
+	}
+
+	return start, stop, true
+}
+
+// match reports whether key matches the glob pattern,
+// "*", "?" and "\" escapes are supported
+func match(pattern, key string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(key); i >= 0; i-- {
+				if match(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(key) == 0 {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(key) == 0 || key[0] != pattern[0] {
+				return false
+			}
+		}
+
+		pattern, key = pattern[1:], key[1:]
+	}
+
+	return len(key) == 0
+}
+
+// sortedKeys returns the hash fields sorted
+func sortedKeys(hash map[string][]byte) []string {
+	keys := make([]string, 0, len(hash))
+	for key := range hash {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sortedBulks returns the strings sorted as an array reply
+func sortedBulks(values []string) []interface{} {
+	sort.Strings(values)
+
+	bulks := make([]interface{}, len(values))
+	for i, v := range values {
+		bulks[i] = []byte(v)
+	}
+	return bulks
+}
+
+// reverse reverses the list in place
+func reverse(list [][]byte) {
+	for i, j := 0, len(list)-1; i < j; i, j = i+1, j-1 {
+		list[i], list[j] = list[j], list[i]
+	}
+}
+
+// sortedMembers returns the sorted set members
+// ordered by score, then lexicographically
+func sortedMembers(e *entry) []string {
+	if e == nil {
+		return nil
+	}
+
+	members := make([]string, 0, len(e.zset))
+	for member := range e.zset {
+		members = append(members, member)
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		si, sj := e.zset[members[i]], e.zset[members[j]]
+		if si != sj {
+			return si < sj
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
+
+// zsetReply returns the members as an array reply,
+// followed by their scores if withScores is set
+func zsetReply(e *entry, members []string, withScores bool) reply {
+	values := []interface{}{}
+	for _, member := range members {
+		values = append(values, []byte(member))
+		if withScores {
+			values = append(values, formatScore(e.zset[member]))
+		}
+	}
+	return reply{value: values}
+}
+
+// parseScoreBound parses a ZRANGEBYSCORE min or max,
+// "-inf", "+inf" and "(" exclusive bounds are supported
+func parseScoreBound(b []byte) (score float64, exclusive, ok bool) {
+	s := string(b)
+	if strings.HasPrefix(s, "(") {
+		s, exclusive = s[1:], true
+	}
+
+	score, err := strconv.ParseFloat(s, 64)
+	return score, exclusive, err == nil && !math.IsNaN(score)
+}
+
+// inScoreRange reports whether the score is within the bounds
+func inScoreRange(score, min, max float64, minExcl, maxExcl bool) bool {
+	if score < min || (minExcl && score == min) {
+		return false
+	}
+	return score < max || (!maxExcl && score == max)
+}
+
+// formatScore formats the score the way redis replies it
+func formatScore(score float64) []byte {
+	switch {
+	case math.IsInf(score, 1):
+		return []byte("inf")
+	case math.IsInf(score, -1):
+		return []byte("-inf")
+	}
+	return []byte(strconv.FormatFloat(score, 'f', -1, 64))
+}
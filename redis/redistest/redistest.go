@@ -0,0 +1,325 @@
+// Package redistest provides an in-process fake redis server
+// for unit testing code which depends on redis.Connector.
+//
+// The fake supports the most used strings, hashes, lists,
+// sets, sorted sets and keys commands, keys expiry and MULTI/EXEC
+// transactions. PUBLISH is accepted and reaches no subscribers.
+// Unsupported commands reply with an error, among them
+// EVAL/EVALSHA and SUBSCRIBE, so code relying on scripts
+// (Locker, Elector, JobQueue, package ratelimit) or on pub/sub delivery
+// can not be tested against the fake.
+// Keys expiry follows the fake clock, which can be moved
+// forward with FastForward
+package redistest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/onefootball/samodelkin/redis"
+)
+
+// errClosed is returned on use of a closed connection
+var errClosed = errors.New("redistest: connection closed")
+
+// compile time check of the interface implementation
+var _ redis.Connector = (*Connector)(nil)
+
+type (
+	// Connector is a struct type
+	// which implements redis.Connector interface
+	// on top of an in-memory fake redis server.
+	// Connections retrieved from the same Connector
+	// share the data
+	Connector struct {
+		mu     sync.Mutex
+		keys   map[string]*entry
+		offset time.Duration
+	}
+
+	// entry holds a key value of one of the kinds
+	entry struct {
+		kind     string
+		str      []byte
+		hash     map[string][]byte
+		list     [][]byte
+		set      map[string]struct{}
+		zset     map[string]float64
+		expireAt time.Time
+	}
+
+	// conn is a redigo.ConnWithTimeout implementation
+	// which runs the commands against the Connector data
+	conn struct {
+		c       *Connector
+		replies []reply
+		multi   bool
+		queued  [][][]byte
+		closed  bool
+	}
+
+	// reply holds a pipelined command reply
+	reply struct {
+		value interface{}
+		err   error
+	}
+)
+
+// NewConnector inits and returns a pointer to Connector instance
+// holding an empty data set
+func NewConnector() *Connector {
+	return &Connector{keys: make(map[string]*entry)}
+}
+
+// Connect returns a new connection to the fake server
+func (c *Connector) Connect() redigo.Conn {
+	return &conn{c: c}
+}
+
+// ConnectContext returns a new connection to the fake server
+// or ctx error if ctx is done
+func (c *Connector) ConnectContext(ctx context.Context) (redigo.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return c.Connect(), nil
+}
+
+// PingConnect returns a new connection to the fake server
+// checked with PING command
+func (c *Connector) PingConnect() (redigo.Conn, error) {
+	redisConn := c.Connect()
+	_, err := redisConn.Do("PING")
+	return redisConn, err
+}
+
+// FastForward moves the fake server clock forward by d,
+// keys with ttl shorter than d expire
+func (c *Connector) FastForward(d time.Duration) {
+	c.mu.Lock()
+	c.offset += d
+	c.mu.Unlock()
+}
+
+// FlushAll removes all the keys
+func (c *Connector) FlushAll() {
+	c.mu.Lock()
+	c.keys = make(map[string]*entry)
+	c.mu.Unlock()
+}
+
+// now returns the fake server clock time
+func (c *Connector) now() time.Time {
+	return time.Now().Add(c.offset)
+}
+
+// run executes the commands atomically and returns their replies
+func (c *Connector) run(cmds ...[][]byte) []reply {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	replies := make([]reply, len(cmds))
+	for i, cmd := range cmds {
+		replies[i] = c.exec(cmd)
+	}
+	return replies
+}
+
+// exec executes the command, the caller holds the lock
+func (c *Connector) exec(cmd [][]byte) reply {
+	name := strings.ToUpper(string(cmd[0]))
+
+	spec, ok := commands[name]
+	if !ok {
+		return errReply("ERR unknown command '%s'", cmd[0])
+	}
+
+	args := cmd[1:]
+	if len(args) < spec.minArgs || (spec.maxArgs >= 0 && len(args) > spec.maxArgs) {
+		return errReply("ERR wrong number of arguments for '%s' command", strings.ToLower(name))
+	}
+
+	return spec.fn(c, args)
+}
+
+// Do sends the command and returns its reply.
+// Empty cmd returns the pending replies of the sent commands
+func (cn *conn) Do(cmd string, args ...interface{}) (interface{}, error) {
+	if cn.closed {
+		return nil, errClosed
+	}
+
+	pending := cn.replies
+	cn.replies = nil
+
+	if cmd == "" {
+		return replyValues(pending), nil
+	}
+
+	if err := cn.Send(cmd, args...); err != nil {
+		return nil, err
+	}
+
+	r := cn.replies[0]
+	cn.replies = nil
+
+	// redigo returns the first pending error reply
+	for _, p := range pending {
+		if p.err != nil {
+			return r.value, p.err
+		}
+	}
+
+	return r.value, r.err
+}
+
+// DoWithTimeout calls cn.Do, the fake never blocks
+func (cn *conn) DoWithTimeout(_ time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	return cn.Do(cmd, args...)
+}
+
+// Send executes the command and buffers its reply.
+// Commands sent after MULTI are queued until EXEC
+func (cn *conn) Send(cmd string, args ...interface{}) error {
+	if cn.closed {
+		return errClosed
+	}
+
+	command := make([][]byte, 1+len(args))
+	command[0] = []byte(cmd)
+	for i, arg := range args {
+		command[i+1] = argBytes(arg)
+	}
+
+	cn.replies = append(cn.replies, cn.transact(command))
+	return nil
+}
+
+// transact handles MULTI/EXEC/DISCARD commands
+// and runs or queues the other ones
+func (cn *conn) transact(cmd [][]byte) reply {
+	switch strings.ToUpper(string(cmd[0])) {
+	case "MULTI":
+		if cn.multi {
+			return errReply("ERR MULTI calls can not be nested")
+		}
+		cn.multi = true
+		return reply{value: "OK"}
+	case "EXEC":
+		if !cn.multi {
+			return errReply("ERR EXEC without MULTI")
+		}
+
+		queued := cn.queued
+		cn.multi, cn.queued = false, nil
+
+		return reply{value: replyValues(cn.c.run(queued...))}
+	case "DISCARD":
+		if !cn.multi {
+			return errReply("ERR DISCARD without MULTI")
+		}
+		cn.multi, cn.queued = false, nil
+		return reply{value: "OK"}
+	}
+
+	if cn.multi {
+		cn.queued = append(cn.queued, cmd)
+		return reply{value: "QUEUED"}
+	}
+
+	return cn.c.run(cmd)[0]
+}
+
+// Flush is a no-op, commands are executed on Send
+func (cn *conn) Flush() error {
+	if cn.closed {
+		return errClosed
+	}
+	return nil
+}
+
+// Receive returns the next pending reply
+func (cn *conn) Receive() (interface{}, error) {
+	if cn.closed {
+		return nil, errClosed
+	}
+
+	if len(cn.replies) == 0 {
+		return nil, errors.New("redistest: no pending replies")
+	}
+
+	r := cn.replies[0]
+	cn.replies = cn.replies[1:]
+	return r.value, r.err
+}
+
+// ReceiveWithTimeout calls cn.Receive, the fake never blocks
+func (cn *conn) ReceiveWithTimeout(time.Duration) (interface{}, error) {
+	return cn.Receive()
+}
+
+// Err returns errClosed if the connection is closed
+func (cn *conn) Err() error {
+	if cn.closed {
+		return errClosed
+	}
+	return nil
+}
+
+// Close closes the connection discarding an open transaction
+func (cn *conn) Close() error {
+	cn.closed = true
+	cn.multi, cn.queued, cn.replies = false, nil, nil
+	return nil
+}
+
+// argBytes converts a command argument the way redigo does
+func argBytes(arg interface{}) []byte {
+	switch a := arg.(type) {
+	case string:
+		return []byte(a)
+	case []byte:
+		return append([]byte(nil), a...)
+	case int:
+		return []byte(strconv.Itoa(a))
+	case int64:
+		return []byte(strconv.FormatInt(a, 10))
+	case float64:
+		return []byte(strconv.FormatFloat(a, 'g', -1, 64))
+	case bool:
+		if a {
+			return []byte("1")
+		}
+		return []byte("0")
+	case nil:
+		return []byte("")
+	case redigo.Argument:
+		return argBytes(a.RedisArg())
+	default:
+		return []byte(fmt.Sprint(a))
+	}
+}
+
+// errReply returns an error reply
+func errReply(format string, args ...interface{}) reply {
+	return reply{err: redigo.Error(fmt.Sprintf(format, args...))}
+}
+
+// replyValues returns the replies as an array reply,
+// error replies are kept as redigo.Error values
+func replyValues(replies []reply) []interface{} {
+	values := make([]interface{}, len(replies))
+	for i, r := range replies {
+		values[i] = r.value
+		if r.err != nil {
+			values[i] = r.err
+		}
+	}
+	return values
+}
@@ -0,0 +1,163 @@
+package redistest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	gc "github.com/go-check/check"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/onefootball/samodelkin/redis"
+)
+
+type RedisTestTestSuite struct{}
+
+var _ = gc.Suite(&RedisTestTestSuite{})
+
+func TestRedisTest(t *testing.T) { gc.TestingT(t) }
+
+// str, num, strs and strMap convert replies
+// for the checks, conversion errors give zero values
+func str(reply interface{}, err error) string {
+	v, _ := redigo.String(reply, err)
+	return v
+}
+
+func num(reply interface{}, err error) int64 {
+	v, _ := redigo.Int64(reply, err)
+	return v
+}
+
+func strs(reply interface{}, err error) []string {
+	v, _ := redigo.Strings(reply, err)
+	return v
+}
+
+func strMap(reply interface{}, err error) map[string]string {
+	v, _ := redigo.StringMap(reply, err)
+	return v
+}
+
+func (s *RedisTestTestSuite) TestStringsAndExpiry(c *gc.C) {
+	connector := NewConnector()
+	conn := connector.Connect()
+	defer conn.Close()
+
+	c.Check(str(conn.Do("SET", "match:1", "2:1", "EX", 10)), gc.Equals, "OK")
+	c.Check(str(conn.Do("GET", "match:1")), gc.Equals, "2:1")
+	c.Check(num(conn.Do("TTL", "match:1")), gc.Equals, int64(10))
+
+	_, err := conn.Do("SET", "match:1", "3:1", "NX")
+	c.Check(err, gc.IsNil)
+	c.Check(str(conn.Do("GET", "match:1")), gc.Equals, "2:1")
+
+	c.Check(num(conn.Do("INCRBY", "goals", 3)), gc.Equals, int64(3))
+	c.Check(num(conn.Do("DECR", "goals")), gc.Equals, int64(2))
+
+	_, err = conn.Do("INCR", "match:1")
+	c.Check(err, gc.ErrorMatches, "ERR value is not an integer or out of range")
+
+	connector.FastForward(10 * time.Second)
+	_, err = redigo.String(conn.Do("GET", "match:1"))
+	c.Check(err, gc.Equals, redigo.ErrNil)
+	c.Check(num(conn.Do("TTL", "match:1")), gc.Equals, int64(-2))
+	c.Check(num(conn.Do("TTL", "goals")), gc.Equals, int64(-1))
+}
+
+func (s *RedisTestTestSuite) TestHashesListsAndSets(c *gc.C) {
+	conn := NewConnector().Connect()
+	defer conn.Close()
+
+	c.Check(num(conn.Do("HSET", "team:1", "name", "Arsenal", "city", "London")), gc.Equals, int64(2))
+	c.Check(strMap(conn.Do("HGETALL", "team:1")), gc.DeepEquals, map[string]string{"name": "Arsenal", "city": "London"})
+	c.Check(num(conn.Do("HDEL", "team:1", "city", "stadium")), gc.Equals, int64(1))
+
+	c.Check(num(conn.Do("RPUSH", "events", "kickoff", "goal", "goal")), gc.Equals, int64(3))
+	c.Check(num(conn.Do("LPUSH", "events", "lineups")), gc.Equals, int64(4))
+	c.Check(strs(conn.Do("LRANGE", "events", 1, -1)), gc.DeepEquals, []string{"kickoff", "goal", "goal"})
+	c.Check(num(conn.Do("LREM", "events", -1, "goal")), gc.Equals, int64(1))
+	c.Check(str(conn.Do("RPOP", "events")), gc.Equals, "goal")
+
+	c.Check(num(conn.Do("SADD", "fans", "a", "b", "a")), gc.Equals, int64(2))
+	c.Check(strs(conn.Do("SMEMBERS", "fans")), gc.DeepEquals, []string{"a", "b"})
+	c.Check(num(conn.Do("SISMEMBER", "fans", "c")), gc.Equals, int64(0))
+
+	c.Check(strs(conn.Do("KEYS", "*s")), gc.DeepEquals, []string{"events", "fans"})
+
+	_, err := conn.Do("LPUSH", "team:1", "x")
+	c.Check(err, gc.ErrorMatches, "WRONGTYPE .*")
+}
+
+func (s *RedisTestTestSuite) TestSortedSetsAndPublish(c *gc.C) {
+	conn := NewConnector().Connect()
+	defer conn.Close()
+
+	c.Check(num(conn.Do("ZADD", "table", 3, "arsenal", 1, "chelsea", 3, "everton")), gc.Equals, int64(3))
+	c.Check(num(conn.Do("ZADD", "table", "NX", 0, "arsenal", 0, "fulham")), gc.Equals, int64(1))
+	c.Check(str(conn.Do("ZINCRBY", "table", 1.5, "chelsea")), gc.Equals, "2.5")
+	c.Check(str(conn.Do("ZSCORE", "table", "arsenal")), gc.Equals, "3")
+	c.Check(num(conn.Do("ZCARD", "table")), gc.Equals, int64(4))
+
+	c.Check(strs(conn.Do("ZRANGE", "table", 0, -1)), gc.DeepEquals, []string{"fulham", "chelsea", "arsenal", "everton"})
+	c.Check(strs(conn.Do("ZRANGE", "table", -2, -1, "WITHSCORES")), gc.DeepEquals, []string{"arsenal", "3", "everton", "3"})
+	c.Check(strs(conn.Do("ZRANGEBYSCORE", "table", "(0", "+inf", "LIMIT", 1, 1)), gc.DeepEquals, []string{"arsenal"})
+	c.Check(strs(conn.Do("ZRANGEBYSCORE", "table", "-inf", 2.5)), gc.DeepEquals, []string{"fulham", "chelsea"})
+
+	c.Check(num(conn.Do("ZREMRANGEBYSCORE", "table", "-inf", "(3")), gc.Equals, int64(2))
+	c.Check(num(conn.Do("ZREM", "table", "arsenal", "everton", "spurs")), gc.Equals, int64(2))
+	c.Check(num(conn.Do("EXISTS", "table")), gc.Equals, int64(0))
+
+	c.Check(num(conn.Do("PUBLISH", "invalidate", "table")), gc.Equals, int64(0))
+
+	_, err := conn.Do("EVAL", "return 1", 0)
+	c.Check(err, gc.ErrorMatches, "ERR unknown command 'EVAL'")
+}
+
+func (s *RedisTestTestSuite) TestMultiExec(c *gc.C) {
+	connector := NewConnector()
+	conn := connector.Connect()
+	defer conn.Close()
+
+	conn.Send("MULTI")
+	conn.Send("INCR", "counter")
+	conn.Send("HGET", "counter", "field")
+	conn.Send("PEXPIRE", "counter", 100)
+	values, err := redigo.Values(conn.Do("EXEC"))
+	c.Assert(err, gc.IsNil)
+
+	c.Check(values, gc.HasLen, 3)
+	c.Check(values[0], gc.Equals, int64(1))
+	c.Check(values[1], gc.FitsTypeOf, redigo.Error(""))
+	c.Check(values[2], gc.Equals, int64(1))
+
+	conn.Send("MULTI")
+	conn.Send("DEL", "counter")
+	_, err = conn.Do("DISCARD")
+	c.Check(err, gc.IsNil)
+	c.Check(num(connector.Connect().Do("EXISTS", "counter")), gc.Equals, int64(1))
+
+	_, err = conn.Do("EXEC")
+	c.Check(err, gc.ErrorMatches, "ERR EXEC without MULTI")
+}
+
+func (s *RedisTestTestSuite) TestConnectorWithPackageHelpers(c *gc.C) {
+	connector := NewConnector()
+	cache := redis.NewCache(connector, nil)
+
+	c.Assert(cache.Set(context.Background(), "team:1", map[string]string{"name": "Arsenal"}, time.Minute), gc.IsNil)
+
+	var team map[string]string
+	c.Check(cache.Get(context.Background(), "team:1", &team), gc.IsNil)
+	c.Check(team, gc.DeepEquals, map[string]string{"name": "Arsenal"})
+
+	conn := connector.Connect()
+	defer conn.Close()
+
+	c.Check(redis.MSet(conn, map[string]interface{}{"a": 1, "b": 2}, 1), gc.IsNil)
+	values, err := redis.MGet(conn, []string{"a", "b", "c"}, 2)
+	c.Check(err, gc.IsNil)
+	c.Check(values, gc.DeepEquals, [][]byte{[]byte("1"), []byte("2"), nil})
+
+	_, err = conn.Do("EVAL", "return 1", 0)
+	c.Check(err, gc.ErrorMatches, "ERR unknown command 'EVAL'")
+}
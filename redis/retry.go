@@ -0,0 +1,184 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// RetryPolicy is a struct type
+// which describes retries of transient
+// command failures
+type RetryPolicy struct {
+	// Attempts holds the max number of command attempts,
+	// values lower than 1 are treated as a single attempt
+	Attempts int
+	// MinBackoff holds the base backoff duration,
+	// which is doubled on every next attempt
+	MinBackoff time.Duration
+	// MaxBackoff caps the backoff duration, the backoff
+	// stops doubling before it overflows if zero
+	MaxBackoff time.Duration
+	// Idempotent reports whether the command is safe
+	// to be retried; IsIdempotent is used if nil
+	Idempotent func(cmd string, args ...interface{}) bool
+}
+
+// DefaultRetryPolicy holds a retry policy
+// suitable for most of the services
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts:   3,
+	MinBackoff: 10 * time.Millisecond,
+	MaxBackoff: 500 * time.Millisecond,
+}
+
+// idempotentCmds holds the commands
+// which are safe to be retried
+var idempotentCmds = map[string]bool{
+	"PING": true, "ECHO": true, "EXISTS": true, "TYPE": true, "TTL": true, "PTTL": true,
+	"GET": true, "MGET": true, "STRLEN": true, "GETRANGE": true,
+	"HGET": true, "HMGET": true, "HGETALL": true, "HEXISTS": true, "HKEYS": true, "HVALS": true, "HLEN": true,
+	"LRANGE": true, "LINDEX": true, "LLEN": true,
+	"SMEMBERS": true, "SISMEMBER": true, "SCARD": true,
+	"ZRANGE": true, "ZREVRANGE": true, "ZRANGEBYSCORE": true, "ZREVRANGEBYSCORE": true,
+	"ZSCORE": true, "ZRANK": true, "ZREVRANK": true, "ZCARD": true, "ZCOUNT": true,
+	"SCAN": true, "HSCAN": true, "SSCAN": true, "ZSCAN": true,
+	"SET": true, "MSET": true, "SETEX": true, "PSETEX": true, "HSET": true, "HMSET": true,
+	"DEL": true, "UNLINK": true, "HDEL": true, "SADD": true, "SREM": true, "ZADD": true, "ZREM": true,
+	"EXPIRE": true, "PEXPIRE": true, "EXPIREAT": true, "PEXPIREAT": true, "PERSIST": true,
+}
+
+// IsIdempotent reports whether the command gives the same
+// result when applied multiple times.
+// Commands like INCR, LPUSH or EVAL are not idempotent,
+// neither is SET with NX, XX or GET options as a retry
+// of an applied SET replies differently, nor ZADD with INCR,
+// GT, LT or CH options. SET with EX, PX, EXAT, PXAT or KEEPTTL
+// options is idempotent.
+//
+// The count replies of DEL, HDEL, HSET, SADD, SREM, ZADD and ZREM
+// may differ on a retry of an applied command, e.g. DEL replies 0,
+// while the data ends up the same. Use a custom Idempotent func
+// if the caller relies on these replies
+func IsIdempotent(cmd string, args ...interface{}) bool {
+	name := strings.ToUpper(cmd)
+	switch name {
+	case "SET":
+		// options follow the key and the value
+		for i := 2; i < len(args); i++ {
+			switch argString(args[i]) {
+			case "NX", "XX", "GET":
+				return false
+			}
+		}
+	case "ZADD":
+		// options follow the key and precede the scores
+		for i := 1; i < len(args); i++ {
+			switch argString(args[i]) {
+			case "INCR", "GT", "LT", "CH":
+				return false
+			case "NX", "XX":
+			default:
+				return idempotentCmds[name]
+			}
+		}
+	}
+
+	return idempotentCmds[name]
+}
+
+// argString returns the upper-cased string
+// or []byte argument, an empty string otherwise
+func argString(arg interface{}) string {
+	switch a := arg.(type) {
+	case string:
+		return strings.ToUpper(a)
+	case []byte:
+		return strings.ToUpper(string(a))
+	}
+	return ""
+}
+
+// IsRetryable reports whether err is a transient
+// error: a network error, a connection pool error
+// or LOADING/READONLY replies sent by a server
+// which is loading the dataset or became a replica
+// after a failover
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	for _, target := range []error{context.Canceled, context.DeadlineExceeded, ErrConnectorClosed, redis.ErrNil} {
+		if errors.Is(err, target) {
+			return false
+		}
+	}
+
+	var redisErr redis.Error
+	if errors.As(err, &redisErr) {
+		msg := string(redisErr)
+		return strings.HasPrefix(msg, "LOADING") || strings.HasPrefix(msg, "READONLY")
+	}
+
+	return true
+}
+
+// Do executes the command on a connection retrieved from c
+// and retries idempotent commands on transient errors.
+// Every attempt retrieves a new connection, so connectors
+// with multiple pools send the retry to the next pool
+func (p RetryPolicy) Do(ctx context.Context, c Connector, cmd string, args ...interface{}) (reply interface{}, err error) {
+	idempotent := p.Idempotent
+	if idempotent == nil {
+		idempotent = IsIdempotent
+	}
+
+	for attempt := 0; ; attempt++ {
+		reply, err = doOnce(ctx, c, cmd, args...)
+		if !IsRetryable(err) || !idempotent(cmd, args...) || attempt+1 >= p.Attempts {
+			return reply, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(p.backoff(attempt)):
+		}
+	}
+}
+
+// backoff returns an exponential backoff duration
+// with full jitter for the attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.MinBackoff
+	for i := 0; i < attempt && d <= math.MaxInt64/2 && (p.MaxBackoff <= 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+
+	if d <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// doOnce executes the command on a new connection
+func doOnce(ctx context.Context, c Connector, cmd string, args ...interface{}) (interface{}, error) {
+	redisConn, err := ConnectContext(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer redisConn.Close()
+
+	return redisConn.Do(cmd, args...)
+}
@@ -0,0 +1,106 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type RetryTestSuite struct{}
+
+var _ = gc.Suite(&RetryTestSuite{})
+
+// replyWith returns a do func
+// replying with the reply and err
+func replyWith(reply interface{}, err error) func(string, ...interface{}) (interface{}, error) {
+	return func(string, ...interface{}) (interface{}, error) {
+		return reply, err
+	}
+}
+
+func (s *RetryTestSuite) TestDoRetriesOnNextPool(c *gc.C) {
+	connector := &RedisConnector{pools: []*connPool{
+		newStubPool(replyWith(nil, redis.Error("READONLY You can't write against a read only replica."))),
+		newStubPool(replyWith("OK", nil)),
+	}}
+
+	policy := RetryPolicy{Attempts: 2, MinBackoff: time.Millisecond}
+	reply, err := policy.Do(context.Background(), connector, "SET", "key", "value")
+	c.Check(err, gc.IsNil)
+	c.Check(reply, gc.Equals, "OK")
+}
+
+func (s *RetryTestSuite) TestDoNonIdempotentIsNotRetried(c *gc.C) {
+	expectedErr := redis.Error("LOADING Redis is loading the dataset in memory")
+	connector := &RedisConnector{pools: []*connPool{
+		newStubPool(replyWith(nil, expectedErr)),
+		newStubPool(replyWith(int64(1), nil)),
+	}}
+
+	_, err := DefaultRetryPolicy.Do(context.Background(), connector, "INCR", "key")
+	c.Check(err, gc.Equals, expectedErr)
+}
+
+func (s *RetryTestSuite) TestDoAttemptsExceeded(c *gc.C) {
+	expectedErr := errors.New("connection reset by peer")
+	connector := &RedisConnector{pools: []*connPool{newStubPool(replyWith(nil, expectedErr))}}
+
+	policy := RetryPolicy{Attempts: 3, MinBackoff: time.Millisecond}
+	_, err := policy.Do(context.Background(), connector, "GET", "key")
+	c.Check(err, gc.Equals, expectedErr)
+	c.Check(connector.Stats()[0].Borrows, gc.Equals, uint64(3))
+}
+
+func (s *RetryTestSuite) TestIsRetryable(c *gc.C) {
+	c.Check(IsRetryable(nil), gc.Equals, false)
+	c.Check(IsRetryable(context.Canceled), gc.Equals, false)
+	c.Check(IsRetryable(redis.Error("ERR wrong number of arguments")), gc.Equals, false)
+	c.Check(IsRetryable(redis.Error("LOADING Redis is loading the dataset in memory")), gc.Equals, true)
+	c.Check(IsRetryable(ErrPoolExhausted), gc.Equals, true)
+	c.Check(IsRetryable(errors.New("i/o timeout")), gc.Equals, true)
+	c.Check(IsRetryable(fmt.Errorf("%w: i/o timeout", ErrNoHealthyPool)), gc.Equals, true)
+	c.Check(IsRetryable(fmt.Errorf("dial: %w", context.DeadlineExceeded)), gc.Equals, false)
+	c.Check(IsRetryable(fmt.Errorf("script: %w", redis.Error("READONLY You can't write against a read only replica"))), gc.Equals, true)
+	c.Check(IsRetryable(fmt.Errorf("script: %w", redis.Error("ERR syntax error"))), gc.Equals, false)
+}
+
+func (s *RetryTestSuite) TestIsIdempotent(c *gc.C) {
+	c.Check(IsIdempotent("get", "key"), gc.Equals, true)
+	c.Check(IsIdempotent("INCR", "key"), gc.Equals, false)
+	c.Check(IsIdempotent("SET", "key", "value"), gc.Equals, true)
+	c.Check(IsIdempotent("SET", "key", "value", "PX", 100, "KEEPTTL"), gc.Equals, true)
+	c.Check(IsIdempotent("SET", "key", "nx"), gc.Equals, true)
+	c.Check(IsIdempotent("SET", "key", "value", "nx", "PX", 100), gc.Equals, false)
+	c.Check(IsIdempotent("SET", "key", "value", "XX"), gc.Equals, false)
+	c.Check(IsIdempotent("SET", "key", "value", "GET"), gc.Equals, false)
+	c.Check(IsIdempotent("SET", "key", "value", []byte("nx")), gc.Equals, false)
+	c.Check(IsIdempotent("ZADD", "table", 3, "arsenal"), gc.Equals, true)
+	c.Check(IsIdempotent("ZADD", "table", "NX", 3, "ch"), gc.Equals, true)
+	c.Check(IsIdempotent("ZADD", "table", "XX", []byte("incr"), 1, "arsenal"), gc.Equals, false)
+	c.Check(IsIdempotent("zadd", "table", "GT", "CH", 3, "arsenal"), gc.Equals, false)
+}
+
+func (s *RetryTestSuite) TestBackoffBounds(c *gc.C) {
+	policy := RetryPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	for attempt := 0; attempt < 10; attempt++ {
+		d := policy.backoff(attempt)
+		c.Check(d >= 0 && d <= 50*time.Millisecond, gc.Equals, true, gc.Commentf("attempt %d: %s", attempt, d))
+	}
+}
+
+func (s *RetryTestSuite) TestBackoffWithoutMaxDoesNotOverflow(c *gc.C) {
+	policy := RetryPolicy{MinBackoff: 10 * time.Millisecond}
+	for _, attempt := range []int{40, 64, 100} {
+		var longest time.Duration
+		for i := 0; i < 10; i++ {
+			d := policy.backoff(attempt)
+			c.Assert(d >= 0, gc.Equals, true, gc.Commentf("attempt %d: %s", attempt, d))
+			longest = max(longest, d)
+		}
+		c.Check(longest > time.Hour, gc.Equals, true, gc.Commentf("attempt %d: %s", attempt, longest))
+	}
+}
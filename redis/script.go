@@ -0,0 +1,160 @@
+package redis
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// DefaultScripts holds the registry of the package scripts
+// (locks, rate limiters) and can be used for the application
+// scripts as well. Load it on start to avoid NOSCRIPT
+// round-trips on the first scripts calls
+var DefaultScripts = &ScriptRegistry{}
+
+type (
+	// Script is a struct type
+	// which holds a Lua script.
+	//
+	// Script is executed with EVALSHA; on NOSCRIPT error
+	// (server restart, failover, SCRIPT FLUSH) the script
+	// is loaded with SCRIPT LOAD and executed again
+	Script struct {
+		keyCount int
+		src      string
+		hash     string
+	}
+
+	// ScriptRegistry is a struct type
+	// which holds a list of scripts to be
+	// loaded on every connector pool
+	ScriptRegistry struct {
+		mu      sync.RWMutex
+		scripts []*Script
+	}
+
+	// PoolConnector is an interface type
+	// which describes connectors holding
+	// multiple connection pools
+	PoolConnector interface {
+		EachPool(ctx context.Context, fn func(redis.Conn) error) error
+	}
+)
+
+// NewScript inits and returns a pointer to Script instance.
+// keyCount holds the number of the script KEYS arguments
+func NewScript(keyCount int, src string) *Script {
+	h := sha1.Sum([]byte(src))
+	return &Script{
+		keyCount: keyCount,
+		src:      src,
+		hash:     hex.EncodeToString(h[:]),
+	}
+}
+
+// Hash returns the script SHA1 hash
+func (s *Script) Hash() string {
+	return s.hash
+}
+
+// Load loads the script into the server script cache
+func (s *Script) Load(c redis.Conn) error {
+	_, err := c.Do("SCRIPT", "LOAD", s.src)
+	return err
+}
+
+// Do executes the script with EVALSHA and reloads
+// the script on NOSCRIPT error. EVAL is used if the script
+// is still missing after reload (e.g. SCRIPT LOAD was routed
+// to another cluster node)
+func (s *Script) Do(c redis.Conn, keysAndArgs ...interface{}) (interface{}, error) {
+	args := s.args(s.hash, keysAndArgs)
+
+	reply, err := c.Do("EVALSHA", args...)
+	if !isNoScript(err) {
+		return reply, err
+	}
+
+	if err := s.Load(c); err != nil {
+		return nil, err
+	}
+
+	reply, err = c.Do("EVALSHA", args...)
+	if !isNoScript(err) {
+		return reply, err
+	}
+
+	args[0] = s.src
+	return c.Do("EVAL", args...)
+}
+
+// Run executes the script on a connection retrieved from c
+func (s *Script) Run(ctx context.Context, c Connector, keysAndArgs ...interface{}) (interface{}, error) {
+	redisConn, err := ConnectContext(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	defer redisConn.Close()
+
+	return s.Do(redisConn, keysAndArgs...)
+}
+
+// args returns EVAL/EVALSHA command arguments
+func (s *Script) args(spec string, keysAndArgs []interface{}) []interface{} {
+	args := make([]interface{}, 2+len(keysAndArgs))
+	args[0] = spec
+	args[1] = s.keyCount
+	copy(args[2:], keysAndArgs)
+	return args
+}
+
+// Register creates a script and adds it to the registry
+func (r *ScriptRegistry) Register(keyCount int, src string) *Script {
+	s := NewScript(keyCount, src)
+
+	r.mu.Lock()
+	r.scripts = append(r.scripts, s)
+	r.mu.Unlock()
+
+	return s
+}
+
+// Load loads the registry scripts on every pool of the
+// connector if it implements PoolConnector interface,
+// on a single connection otherwise
+func (r *ScriptRegistry) Load(ctx context.Context, c Connector) error {
+	r.mu.RLock()
+	scripts := append([]*Script(nil), r.scripts...)
+	r.mu.RUnlock()
+
+	load := func(redisConn redis.Conn) error {
+		for _, s := range scripts {
+			if err := s.Load(redisConn); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if pc, ok := c.(PoolConnector); ok {
+		return pc.EachPool(ctx, load)
+	}
+
+	redisConn, err := ConnectContext(ctx, c)
+	if err != nil {
+		return err
+	}
+	defer redisConn.Close()
+
+	return load(redisConn)
+}
+
+// isNoScript reports whether err is a NOSCRIPT reply
+func isNoScript(err error) bool {
+	redisErr, ok := err.(redis.Error)
+	return ok && strings.HasPrefix(string(redisErr), "NOSCRIPT")
+}
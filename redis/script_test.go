@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"context"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type ScriptTestSuite struct{}
+
+var _ = gc.Suite(&ScriptTestSuite{})
+
+// scriptStub emulates a server script cache
+type scriptStub struct {
+	loaded map[string]bool
+}
+
+func (s *scriptStub) do(cmd string, args ...interface{}) (interface{}, error) {
+	switch cmd {
+	case "SCRIPT":
+		s.loaded[NewScript(0, args[1].(string)).Hash()] = true
+		return "OK", nil
+	case "EVALSHA":
+		if !s.loaded[args[0].(string)] {
+			return nil, redis.Error("NOSCRIPT No matching script. Please use EVAL.")
+		}
+		return int64(1), nil
+	}
+	return nil, redis.Error("ERR unexpected command")
+}
+
+func (s *ScriptTestSuite) TestScriptHash(c *gc.C) {
+	c.Check(NewScript(0, "return 1").Hash(), gc.Equals, "e0e1f9fabfc9d4800c877a703b823ac0578ff8db")
+}
+
+func (s *ScriptTestSuite) TestScriptReloadsOnNoScript(c *gc.C) {
+	stub := &recordStub{reply: (&scriptStub{loaded: map[string]bool{}}).do}
+	connector := newStubConnector(stub.do)
+	script := NewScript(1, "return 1")
+
+	reply, err := script.Run(context.Background(), connector, "key")
+	c.Check(err, gc.IsNil)
+	c.Check(reply, gc.Equals, int64(1))
+	c.Check(stub.commands(""), gc.DeepEquals, []string{
+		"EVALSHA " + script.Hash() + " 1 key",
+		"SCRIPT LOAD return 1",
+		"EVALSHA " + script.Hash() + " 1 key",
+	})
+
+	_, err = script.Run(context.Background(), connector, "key")
+	c.Check(err, gc.IsNil)
+	c.Check(stub.commands(""), gc.HasLen, 4)
+}
+
+func (s *ScriptTestSuite) TestRegistryLoadsEveryPool(c *gc.C) {
+	stub1, stub2 := &scriptStub{loaded: map[string]bool{}}, &scriptStub{loaded: map[string]bool{}}
+	connector := &RedisConnector{pools: []*connPool{newStubPool(stub1.do), newStubPool(stub2.do)}}
+
+	registry := &ScriptRegistry{}
+	script := registry.Register(0, "return 1")
+
+	c.Assert(registry.Load(context.Background(), connector), gc.IsNil)
+	c.Check(stub1.loaded[script.Hash()], gc.Equals, true)
+	c.Check(stub2.loaded[script.Hash()], gc.Equals, true)
+}
@@ -0,0 +1,309 @@
+// Package session provides redis backed
+// HTTP sessions for net/http services.
+//
+// Session values are kept in a redis hash under
+// "<Prefix><session id>", the client gets the session id
+// in a cookie signed with HMAC-SHA256, so forged or
+// tampered ids are rejected without a redis round-trip.
+//
+// Sessions expire after TTL of inactivity: every Get
+// of a stored session extends its redis key ttl.
+// Call Regenerate on privilege change (login, logout,
+// role switch) to prevent session fixation.
+//
+// Save replaces the session keys in a MULTI/EXEC transaction
+// and a regenerated session is stored under a key in another
+// hash slot, so the store is not supported on redis cluster:
+// NewStore rejects a *redis.ClusterConnector
+package session
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/onefootball/samodelkin/redis"
+)
+
+const (
+	// DefaultCookieName holds the default session cookie name
+	DefaultCookieName = "session"
+
+	// DefaultPrefix holds the default session keys prefix
+	DefaultPrefix = "session:"
+
+	// DefaultTTL holds the default session inactivity timeout
+	DefaultTTL = 30 * time.Minute
+)
+
+var (
+	// ErrEmptySecret is returned by NewStore
+	// if the cookie signing secret is empty
+	ErrEmptySecret = errors.New("session: empty secret")
+
+	// ErrClusterUnsupported is returned by NewStore
+	// if the connector is a redis cluster one
+	ErrClusterUnsupported = errors.New("session: redis cluster is not supported")
+)
+
+type (
+	// Store is a struct type
+	// which loads and saves sessions
+	// on top of a redis.Connector
+	Store struct {
+		connector redis.Connector
+		secret    []byte
+
+		// CookieName holds the session cookie name
+		CookieName string
+		// Prefix holds the session keys prefix
+		Prefix string
+		// TTL holds the session inactivity timeout
+		TTL time.Duration
+
+		// Path, Domain, Secure and SameSite hold the
+		// session cookie attributes, the cookie is always
+		// HttpOnly and expires with the browser session
+		Path     string
+		Domain   string
+		Secure   bool
+		SameSite http.SameSite
+	}
+
+	// Session is a struct type
+	// which holds a session values
+	Session struct {
+		id    string
+		oldID string
+
+		// IsNew is true until the session is saved
+		IsNew bool
+		// Values holds the session values
+		Values map[string]string
+	}
+)
+
+// NewStore inits and returns a pointer to Store instance
+// which signs the session cookies with the secret
+func NewStore(c redis.Connector, secret []byte) (*Store, error) {
+	if len(secret) == 0 {
+		return nil, ErrEmptySecret
+	}
+
+	if _, ok := c.(*redis.ClusterConnector); ok {
+		return nil, ErrClusterUnsupported
+	}
+
+	return &Store{
+		connector:  c,
+		secret:     secret,
+		CookieName: DefaultCookieName,
+		Prefix:     DefaultPrefix,
+		TTL:        DefaultTTL,
+		Path:       "/",
+		Secure:     true,
+		SameSite:   http.SameSiteLaxMode,
+	}, nil
+}
+
+// Get returns the request session extending its ttl.
+// A new session is returned if the request has no
+// valid session cookie or the session has expired
+func (s *Store) Get(r *http.Request) (*Session, error) {
+	cookie, err := r.Cookie(s.CookieName)
+	if err != nil {
+		return s.newSession()
+	}
+
+	id, ok := s.verify(cookie.Value)
+	if !ok {
+		return s.newSession()
+	}
+
+	values, err := s.load(r.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(values) == 0 {
+		return s.newSession()
+	}
+
+	return &Session{id: id, Values: values}, nil
+}
+
+// Save stores the session values and sets the session cookie.
+// Sessions without values are not stored
+func (s *Store) Save(ctx context.Context, w http.ResponseWriter, sess *Session) error {
+	redisConn, err := redis.ConnectContext(ctx, s.connector)
+	if err != nil {
+		return err
+	}
+	defer redisConn.Close()
+
+	key := s.Prefix + sess.id
+
+	cmds := [][]interface{}{{"MULTI"}}
+	if sess.oldID != "" {
+		cmds = append(cmds, []interface{}{"DEL", s.Prefix + sess.oldID})
+	}
+	cmds = append(cmds, []interface{}{"DEL", key})
+	if len(sess.Values) > 0 {
+		cmds = append(cmds,
+			append([]interface{}{"HSET"}, redigo.Args{key}.AddFlat(sess.Values)...),
+			[]interface{}{"PEXPIRE", key, int64(s.TTL / time.Millisecond)},
+		)
+	}
+
+	for _, cmd := range cmds {
+		if err := redisConn.Send(cmd[0].(string), cmd[1:]...); err != nil {
+			return err
+		}
+	}
+
+	replies, err := redigo.Values(redisConn.Do("EXEC"))
+	if err != nil {
+		return err
+	}
+
+	for _, reply := range replies {
+		if err, ok := reply.(redigo.Error); ok {
+			return err
+		}
+	}
+
+	sess.oldID = ""
+	sess.IsNew = false
+	http.SetCookie(w, s.cookie(s.sign(sess.id), 0))
+
+	return nil
+}
+
+// Regenerate assigns a new id to the session keeping its values,
+// the session stored under the old id is removed on Save
+func (s *Store) Regenerate(sess *Session) error {
+	id, err := newID()
+	if err != nil {
+		return err
+	}
+
+	if sess.oldID == "" && !sess.IsNew {
+		sess.oldID = sess.id
+	}
+
+	sess.id = id
+	return nil
+}
+
+// Destroy removes the session and expires the session cookie
+func (s *Store) Destroy(ctx context.Context, w http.ResponseWriter, sess *Session) error {
+	redisConn, err := redis.ConnectContext(ctx, s.connector)
+	if err != nil {
+		return err
+	}
+	defer redisConn.Close()
+
+	keys := redigo.Args{s.Prefix + sess.id}
+	if sess.oldID != "" {
+		keys = keys.Add(s.Prefix + sess.oldID)
+	}
+
+	if _, err := redisConn.Do("DEL", keys...); err != nil {
+		return err
+	}
+
+	sess.Values = make(map[string]string)
+	http.SetCookie(w, s.cookie("", -1))
+
+	return nil
+}
+
+// ID returns the session id
+func (sess *Session) ID() string {
+	return sess.id
+}
+
+// load returns the session values extending the session ttl
+func (s *Store) load(ctx context.Context, id string) (map[string]string, error) {
+	redisConn, err := redis.ConnectContext(ctx, s.connector)
+	if err != nil {
+		return nil, err
+	}
+	defer redisConn.Close()
+
+	key := s.Prefix + id
+
+	p := redis.NewPipeline(redisConn)
+	values := p.Send("HGETALL", key)
+	p.Send("PEXPIRE", key, int64(s.TTL/time.Millisecond))
+
+	if err := p.Exec(); err != nil {
+		return nil, err
+	}
+
+	return redigo.StringMap(values.Reply, values.Err)
+}
+
+// newSession returns a session with a new id
+func (s *Store) newSession() (*Session, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Session{id: id, IsNew: true, Values: make(map[string]string)}, nil
+}
+
+// cookie returns the session cookie
+func (s *Store) cookie(value string, maxAge int) *http.Cookie {
+	return &http.Cookie{
+		Name:     s.CookieName,
+		Value:    value,
+		Path:     s.Path,
+		Domain:   s.Domain,
+		MaxAge:   maxAge,
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: s.SameSite,
+	}
+}
+
+// sign returns "<id>.<signature>" cookie value
+func (s *Store) sign(id string) string {
+	return id + "." + s.signature(id)
+}
+
+// verify returns the id held by the signed cookie value,
+// ok is false if the signature does not match
+func (s *Store) verify(value string) (id string, ok bool) {
+	i := strings.LastIndexByte(value, '.')
+	if i < 0 {
+		return "", false
+	}
+
+	id = value[:i]
+	return id, hmac.Equal([]byte(value[i+1:]), []byte(s.signature(id)))
+}
+
+// signature returns the id HMAC-SHA256 signature
+func (s *Store) signature(id string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// newID returns a random session id
+func newID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
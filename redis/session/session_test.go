@@ -0,0 +1,192 @@
+package session
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	gc "github.com/go-check/check"
+	redigo "github.com/gomodule/redigo/redis"
+	"github.com/onefootball/samodelkin/redis"
+	"github.com/onefootball/samodelkin/redis/redistest"
+)
+
+type SessionTestSuite struct{}
+
+var _ = gc.Suite(&SessionTestSuite{})
+
+func TestSession(t *testing.T) { gc.TestingT(t) }
+
+// saveSession saves the session and returns the session cookie
+func saveSession(c *gc.C, store *Store, sess *Session) *http.Cookie {
+	w := httptest.NewRecorder()
+	c.Assert(store.Save(context.Background(), w, sess), gc.IsNil)
+
+	cookies := w.Result().Cookies()
+	c.Assert(cookies, gc.HasLen, 1)
+	return cookies[0]
+}
+
+// requestWith returns a request holding the cookie
+func requestWith(cookie *http.Cookie) *http.Request {
+	r := httptest.NewRequest("GET", "/dashboard", nil)
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	return r
+}
+
+func (s *SessionTestSuite) TestNewStoreEmptySecret(c *gc.C) {
+	_, err := NewStore(redistest.NewConnector(), nil)
+	c.Check(err, gc.Equals, ErrEmptySecret)
+}
+
+func (s *SessionTestSuite) TestNewStoreCluster(c *gc.C) {
+	_, err := NewStore(&redis.ClusterConnector{}, []byte("secret"))
+	c.Check(err, gc.Equals, ErrClusterUnsupported)
+}
+
+// txRejectingConnector returns connections
+// which reject transactions like cluster ones
+type txRejectingConnector struct {
+	*redistest.Connector
+}
+
+// txRejectingConn rejects MULTI
+type txRejectingConn struct {
+	redigo.Conn
+}
+
+func (t txRejectingConnector) Connect() redigo.Conn {
+	return txRejectingConn{t.Connector.Connect()}
+}
+
+func (t txRejectingConnector) ConnectContext(context.Context) (redigo.Conn, error) {
+	return t.Connect(), nil
+}
+
+func (t txRejectingConn) Send(cmd string, args ...interface{}) error {
+	if cmd == "MULTI" {
+		return redis.ErrClusterTransaction
+	}
+	return t.Conn.Send(cmd, args...)
+}
+
+func (s *SessionTestSuite) TestSaveReturnsSendErrors(c *gc.C) {
+	store, err := NewStore(txRejectingConnector{redistest.NewConnector()}, []byte("secret"))
+	c.Assert(err, gc.IsNil)
+
+	sess, err := store.Get(requestWith(nil))
+	c.Assert(err, gc.IsNil)
+	sess.Values["user"] = "42"
+
+	w := httptest.NewRecorder()
+	c.Check(store.Save(context.Background(), w, sess), gc.Equals, redis.ErrClusterTransaction)
+	c.Check(w.Result().Cookies(), gc.HasLen, 0)
+}
+
+func (s *SessionTestSuite) TestSaveAndGet(c *gc.C) {
+	store, err := NewStore(redistest.NewConnector(), []byte("secret"))
+	c.Assert(err, gc.IsNil)
+
+	sess, err := store.Get(requestWith(nil))
+	c.Assert(err, gc.IsNil)
+	c.Check(sess.IsNew, gc.Equals, true)
+
+	sess.Values["user"] = "42"
+	cookie := saveSession(c, store, sess)
+	c.Check(cookie.Name, gc.Equals, DefaultCookieName)
+	c.Check(cookie.HttpOnly, gc.Equals, true)
+	c.Check(cookie.Secure, gc.Equals, true)
+
+	loaded, err := store.Get(requestWith(cookie))
+	c.Assert(err, gc.IsNil)
+	c.Check(loaded.IsNew, gc.Equals, false)
+	c.Check(loaded.ID(), gc.Equals, sess.ID())
+	c.Check(loaded.Values, gc.DeepEquals, map[string]string{"user": "42"})
+}
+
+func (s *SessionTestSuite) TestGetRejectsTamperedCookie(c *gc.C) {
+	store, _ := NewStore(redistest.NewConnector(), []byte("secret"))
+
+	sess, _ := store.Get(requestWith(nil))
+	sess.Values["user"] = "42"
+	cookie := saveSession(c, store, sess)
+
+	for _, value := range []string{"forged", sess.ID(), sess.ID() + ".invalid", "other" + cookie.Value[len(sess.ID()):]} {
+		loaded, err := store.Get(requestWith(&http.Cookie{Name: DefaultCookieName, Value: value}))
+		c.Assert(err, gc.IsNil)
+		c.Check(loaded.IsNew, gc.Equals, true, gc.Commentf(value))
+		c.Check(loaded.ID(), gc.Not(gc.Equals), sess.ID())
+	}
+
+	otherStore, _ := NewStore(redistest.NewConnector(), []byte("other secret"))
+	loaded, err := otherStore.Get(requestWith(cookie))
+	c.Assert(err, gc.IsNil)
+	c.Check(loaded.IsNew, gc.Equals, true)
+}
+
+func (s *SessionTestSuite) TestSlidingExpiration(c *gc.C) {
+	connector := redistest.NewConnector()
+	store, _ := NewStore(connector, []byte("secret"))
+
+	sess, _ := store.Get(requestWith(nil))
+	sess.Values["user"] = "42"
+	cookie := saveSession(c, store, sess)
+
+	// every request extends the session for TTL
+	for i := 0; i < 3; i++ {
+		connector.FastForward(20 * time.Minute)
+
+		loaded, err := store.Get(requestWith(cookie))
+		c.Assert(err, gc.IsNil)
+		c.Check(loaded.IsNew, gc.Equals, false)
+	}
+
+	connector.FastForward(DefaultTTL)
+	loaded, err := store.Get(requestWith(cookie))
+	c.Assert(err, gc.IsNil)
+	c.Check(loaded.IsNew, gc.Equals, true)
+	c.Check(loaded.Values, gc.HasLen, 0)
+}
+
+func (s *SessionTestSuite) TestRegenerate(c *gc.C) {
+	connector := redistest.NewConnector()
+	store, _ := NewStore(connector, []byte("secret"))
+
+	sess, _ := store.Get(requestWith(nil))
+	sess.Values["role"] = "guest"
+	saveSession(c, store, sess)
+	oldID := sess.ID()
+
+	c.Assert(store.Regenerate(sess), gc.IsNil)
+	sess.Values["role"] = "admin"
+	cookie := saveSession(c, store, sess)
+	c.Check(sess.ID(), gc.Not(gc.Equals), oldID)
+
+	exists, err := redigo.Bool(connector.Connect().Do("EXISTS", DefaultPrefix+oldID))
+	c.Assert(err, gc.IsNil)
+	c.Check(exists, gc.Equals, false)
+
+	loaded, err := store.Get(requestWith(cookie))
+	c.Assert(err, gc.IsNil)
+	c.Check(loaded.Values, gc.DeepEquals, map[string]string{"role": "admin"})
+}
+
+func (s *SessionTestSuite) TestDestroy(c *gc.C) {
+	store, _ := NewStore(redistest.NewConnector(), []byte("secret"))
+
+	sess, _ := store.Get(requestWith(nil))
+	sess.Values["user"] = "42"
+	cookie := saveSession(c, store, sess)
+
+	w := httptest.NewRecorder()
+	c.Assert(store.Destroy(context.Background(), w, sess), gc.IsNil)
+	c.Check(w.Result().Cookies()[0].MaxAge, gc.Equals, -1)
+
+	loaded, err := store.Get(requestWith(cookie))
+	c.Assert(err, gc.IsNil)
+	c.Check(loaded.IsNew, gc.Equals, true)
+}
@@ -0,0 +1,115 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// drainInterval holds the interval between checks
+// for in-flight connections during shutdown
+const drainInterval = 10 * time.Millisecond
+
+// ErrConnectorClosed is returned on attempts to retrieve
+// a connection from a closed or shutting down connector
+var ErrConnectorClosed = errors.New("redis: connector is closed")
+
+// errorConn is a redis.Conn implementation
+// which returns err on every usage
+type errorConn struct{ err error }
+
+func (c errorConn) Do(string, ...interface{}) (interface{}, error) { return nil, c.err }
+func (c errorConn) Send(string, ...interface{}) error              { return c.err }
+func (c errorConn) Err() error                                     { return c.err }
+func (c errorConn) Close() error                                   { return nil }
+func (c errorConn) Flush() error                                   { return c.err }
+func (c errorConn) Receive() (interface{}, error)                  { return nil, c.err }
+
+// Close stops handing out connections
+// and closes all the connector pools immediately.
+// Connections in use are closed when returned
+func (c *RedisConnector) Close() error {
+	return closePools(c.pools)
+}
+
+// Shutdown stops handing out connections, waits for
+// in-flight connections to be returned and closes all the
+// connector pools. Pools are closed when ctx is done
+// even if some connections are still in use, ctx.Err()
+// is returned in that case
+func (c *RedisConnector) Shutdown(ctx context.Context) error {
+	return shutdownPools(ctx, c.pools)
+}
+
+// Close stops handing out connections
+// and closes all the cluster node pools
+func (c *ClusterConnector) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return closePools(c.nodePools())
+}
+
+// Shutdown stops handing out connections, waits for
+// in-flight connections to be returned and closes all the
+// cluster node pools
+func (c *ClusterConnector) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&c.closed, 1)
+	return shutdownPools(ctx, c.nodePools())
+}
+
+// nodePools returns a snapshot of the cluster node pools
+func (c *ClusterConnector) nodePools() []*connPool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	pools := make([]*connPool, 0, len(c.pools))
+	for _, p := range c.pools {
+		pools = append(pools, p)
+	}
+	return pools
+}
+
+// shutdownPools marks pools as closing, waits for
+// in-flight connections and closes the pools
+func shutdownPools(ctx context.Context, pools []*connPool) error {
+	for _, p := range pools {
+		atomic.StoreInt32(&p.closing, 1)
+	}
+
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+
+	for !drained(pools) {
+		select {
+		case <-ctx.Done():
+			closePools(pools)
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+
+	return closePools(pools)
+}
+
+// closePools marks pools as closing and closes them,
+// the first close error is returned
+func closePools(pools []*connPool) (err error) {
+	for _, p := range pools {
+		atomic.StoreInt32(&p.closing, 1)
+		if closeErr := p.Pool.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// drained returns true if none of the pools
+// has connections in use
+func drained(pools []*connPool) bool {
+	for _, p := range pools {
+		if s := p.Pool.Stats(); s.ActiveCount > s.IdleCount {
+			return false
+		}
+	}
+	return true
+}
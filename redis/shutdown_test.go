@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"context"
+	"time"
+
+	gc "github.com/go-check/check"
+)
+
+type ShutdownTestSuite struct{}
+
+var _ = gc.Suite(&ShutdownTestSuite{})
+
+func (s *ShutdownTestSuite) TestCloseStopsHandingOutConnections(c *gc.C) {
+	connector := &RedisConnector{pools: []*connPool{newStubPool(nil)}}
+	c.Assert(connector.Close(), gc.IsNil)
+
+	_, err := connector.ConnectContext(context.Background())
+	c.Check(err, gc.Equals, ErrConnectorClosed)
+
+	_, err = connector.Connect().Do("PING")
+	c.Check(err, gc.Equals, ErrConnectorClosed)
+}
+
+func (s *ShutdownTestSuite) TestShutdownWaitsForInFlightConnections(c *gc.C) {
+	pool := newStubPool(nil)
+	connector := &RedisConnector{pools: []*connPool{pool}}
+
+	conn := connector.Connect()
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		conn.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	c.Check(connector.Shutdown(ctx), gc.IsNil)
+	c.Check(pool.Pool.Stats().ActiveCount, gc.Equals, 0)
+}
+
+func (s *ShutdownTestSuite) TestShutdownDeadline(c *gc.C) {
+	connector := &RedisConnector{pools: []*connPool{newStubPool(nil)}}
+
+	conn := connector.Connect()
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	c.Check(connector.Shutdown(ctx), gc.Equals, context.DeadlineExceeded)
+
+	_, err := connector.ConnectContext(context.Background())
+	c.Check(err, gc.Equals, ErrConnectorClosed)
+}
@@ -0,0 +1,225 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+type (
+	// PoolStats is a struct type
+	// which holds a single pool statistics
+	PoolStats struct {
+		// Address holds the pool redis server address
+		Address string
+		// ActiveCount is the number of connections in the pool
+		// including idle connections and connections in use
+		ActiveCount int
+		// IdleCount is the number of idle connections in the pool
+		IdleCount int
+		// WaitCount is the number of borrows which found
+		// the pool at MaxActiveConns limit
+		WaitCount uint64
+		// DialErrors is the number of failed connection dials
+		DialErrors uint64
+		// PingFailures is the number of idle connections
+		// which failed PING health check on borrow
+		PingFailures uint64
+		// Borrows is the number of connection borrows
+		Borrows uint64
+		// BorrowDuration is the total time spent on borrows
+		BorrowDuration time.Duration
+	}
+
+	// MetricsCollector is an interface type
+	// which describes logic for collecting
+	// pool metrics as they happen
+	MetricsCollector interface {
+		ObserveBorrow(addr string, d time.Duration, err error)
+		ObserveWait(addr string)
+		ObserveDialError(addr string, err error)
+		ObservePingFailure(addr string, err error)
+	}
+
+	// connPool is a redis.Pool wrapper
+	// which keeps track of the pool statistics
+	connPool struct {
+		*redis.Pool
+		addr      string
+		collector MetricsCollector
+		hooks     []CommandHook
+
+		waits        uint64
+		dialErrors   uint64
+		pingFailures uint64
+		borrows      uint64
+		borrowNanos  int64
+
+		// closing is set when the pool stops
+		// handing out connections
+		closing int32
+	}
+)
+
+// newPool returns a pointer to redis pool instance
+func newPool(cfg RedisConfig) *connPool {
+	p := &connPool{
+		addr:      cfg.Address(),
+		collector: cfg.Collector,
+		hooks:     cfg.Hooks,
+	}
+
+	dial := dialFunc(cfg)
+	p.Pool = &redis.Pool{
+		Dial: func() (redis.Conn, error) {
+			redisConn, err := dial()
+			if err != nil {
+				atomic.AddUint64(&p.dialErrors, 1)
+				if p.collector != nil {
+					p.collector.ObserveDialError(p.addr, err)
+				}
+			}
+			return redisConn, err
+		},
+		MaxIdle:     cfg.MaxIdleConns,
+		MaxActive:   cfg.MaxActiveConns,
+		IdleTimeout: cfg.IdleTimeout,
+		Wait:        cfg.Wait,
+	}
+
+	if cfg.BorrowCheckAfter >= 0 {
+		p.Pool.TestOnBorrow = p.borrowCheck(cfg.BorrowCheckAfter)
+	}
+
+	return p
+}
+
+// borrowCheck returns a TestOnBorrow func which pings
+// connections idle for at least "after" duration
+// and records ping failures
+func (p *connPool) borrowCheck(after time.Duration) func(redis.Conn, time.Time) error {
+	return func(redisConn redis.Conn, t time.Time) error {
+		if after > 0 && time.Since(t) < after {
+			return nil
+		}
+
+		err := pingConn(redisConn, t)
+		if err != nil {
+			atomic.AddUint64(&p.pingFailures, 1)
+			if p.collector != nil {
+				p.collector.ObservePingFailure(p.addr, err)
+			}
+		}
+		return err
+	}
+}
+
+// borrow retrieves a connection from the pool, records
+// borrow statistics and wraps the connection for the hooks.
+// On failure the returned connection holds the error
+func (p *connPool) borrow(ctx context.Context) (redis.Conn, error) {
+	if atomic.LoadInt32(&p.closing) == 1 {
+		return errorConn{ErrConnectorClosed}, ErrConnectorClosed
+	}
+
+	if stats := p.Pool.Stats(); p.MaxActive > 0 && stats.IdleCount == 0 && stats.ActiveCount >= p.MaxActive {
+		atomic.AddUint64(&p.waits, 1)
+		if p.collector != nil {
+			p.collector.ObserveWait(p.addr)
+		}
+	}
+
+	start := time.Now()
+	redisConn, err := p.GetContext(ctx)
+	d := time.Since(start)
+
+	atomic.AddUint64(&p.borrows, 1)
+	atomic.AddInt64(&p.borrowNanos, int64(d))
+	if p.collector != nil {
+		p.collector.ObserveBorrow(p.addr, d, err)
+	}
+
+	if err == nil && len(p.hooks) > 0 {
+		redisConn = &hookConn{Conn: redisConn, ctx: ctx, addr: p.addr, hooks: p.hooks}
+	}
+
+	return redisConn, err
+}
+
+// stats returns the pool statistics snapshot
+func (p *connPool) stats() PoolStats {
+	s := p.Pool.Stats()
+	return PoolStats{
+		Address:        p.addr,
+		ActiveCount:    s.ActiveCount,
+		IdleCount:      s.IdleCount,
+		WaitCount:      atomic.LoadUint64(&p.waits),
+		DialErrors:     atomic.LoadUint64(&p.dialErrors),
+		PingFailures:   atomic.LoadUint64(&p.pingFailures),
+		Borrows:        atomic.LoadUint64(&p.borrows),
+		BorrowDuration: time.Duration(atomic.LoadInt64(&p.borrowNanos)),
+	}
+}
+
+// Stats returns statistics of every connector pool
+func (c *RedisConnector) Stats() []PoolStats {
+	stats := make([]PoolStats, 0, len(c.pools))
+	for _, p := range c.pools {
+		stats = append(stats, p.stats())
+	}
+	return stats
+}
+
+// Stats returns statistics of every cluster node pool
+func (c *ClusterConnector) Stats() []PoolStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	stats := make([]PoolStats, 0, len(c.pools))
+	for _, p := range c.pools {
+		stats = append(stats, p.stats())
+	}
+	return stats
+}
+
+// WriteMetrics writes pool statistics to w
+// in the Prometheus text exposition format
+func WriteMetrics(w io.Writer, stats []PoolStats) error {
+	metrics := []struct {
+		name, kind, help string
+		value            func(PoolStats) interface{}
+	}{
+		{"redis_pool_active_connections", "gauge", "Number of connections in the pool.",
+			func(s PoolStats) interface{} { return s.ActiveCount }},
+		{"redis_pool_idle_connections", "gauge", "Number of idle connections in the pool.",
+			func(s PoolStats) interface{} { return s.IdleCount }},
+		{"redis_pool_waits_total", "counter", "Number of borrows which found the pool exhausted.",
+			func(s PoolStats) interface{} { return s.WaitCount }},
+		{"redis_pool_dial_errors_total", "counter", "Number of failed connection dials.",
+			func(s PoolStats) interface{} { return s.DialErrors }},
+		{"redis_pool_ping_failures_total", "counter", "Number of failed PING checks on borrow.",
+			func(s PoolStats) interface{} { return s.PingFailures }},
+		{"redis_pool_borrows_total", "counter", "Number of connection borrows.",
+			func(s PoolStats) interface{} { return s.Borrows }},
+		{"redis_pool_borrow_seconds_total", "counter", "Total time spent on connection borrows.",
+			func(s PoolStats) interface{} { return s.BorrowDuration.Seconds() }},
+	}
+
+	for _, m := range metrics {
+		if _, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind); err != nil {
+			return err
+		}
+
+		for _, s := range stats {
+			if _, err := fmt.Fprintf(w, "%s{address=%q} %v\n", m.name, s.Address, m.value(s)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"time"
+
+	gc "github.com/go-check/check"
+)
+
+type StatsTestSuite struct{}
+
+var _ = gc.Suite(&StatsTestSuite{})
+
+func (s *StatsTestSuite) TestStatsBorrowsAndWaits(c *gc.C) {
+	pool := limitPool(newStubPool(nil), 1, false)
+	pool.addr = "localhost:6379"
+	connector := &RedisConnector{pools: []*connPool{pool}}
+
+	conn := connector.Connect()
+	defer conn.Close()
+
+	_, err := connector.ConnectContext(context.Background())
+	c.Check(err, gc.Equals, ErrPoolExhausted)
+
+	stats := connector.Stats()
+	c.Assert(stats, gc.HasLen, 1)
+	c.Check(stats[0].Address, gc.Equals, "localhost:6379")
+	c.Check(stats[0].ActiveCount, gc.Equals, 1)
+	c.Check(stats[0].IdleCount, gc.Equals, 0)
+	c.Check(stats[0].Borrows, gc.Equals, uint64(2))
+	c.Check(stats[0].WaitCount, gc.Equals, uint64(1))
+}
+
+func (s *StatsTestSuite) TestWriteMetrics(c *gc.C) {
+	stats := []PoolStats{{
+		Address:        "localhost:6379",
+		ActiveCount:    3,
+		IdleCount:      1,
+		DialErrors:     2,
+		Borrows:        10,
+		BorrowDuration: 1500 * time.Millisecond,
+	}}
+
+	var buf bytes.Buffer
+	c.Assert(WriteMetrics(&buf, stats), gc.IsNil)
+
+	lines := strings.Split(buf.String(), "\n")
+	c.Check(lines[0], gc.Equals, "# HELP redis_pool_active_connections Number of connections in the pool.")
+	c.Check(lines[1], gc.Equals, "# TYPE redis_pool_active_connections gauge")
+	c.Check(lines[2], gc.Equals, `redis_pool_active_connections{address="localhost:6379"} 3`)
+	c.Check(buf.String(), gc.Matches, `(?s).*redis_pool_dial_errors_total\{address="localhost:6379"\} 2\n.*`)
+	c.Check(buf.String(), gc.Matches, `(?s).*redis_pool_borrow_seconds_total\{address="localhost:6379"\} 1.5\n`)
+}
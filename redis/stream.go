@@ -0,0 +1,437 @@
+package redis
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+const (
+	// defaultStreamCount holds the default number
+	// of entries read with a single XREADGROUP
+	defaultStreamCount = 10
+
+	// defaultStreamBlock holds the default
+	// XREADGROUP BLOCK timeout
+	defaultStreamBlock = time.Second
+
+	// defaultClaimMinIdle holds the default idle time
+	// of pending entries to be reclaimed
+	defaultClaimMinIdle = time.Minute
+
+	// blockReadMargin is added to the BLOCK timeout
+	// to get the blocking commands read timeout
+	blockReadMargin = time.Second
+)
+
+type (
+	// StreamEntry is a struct type
+	// which holds a stream entry
+	StreamEntry struct {
+		// Stream holds the stream key
+		Stream string
+		// ID holds the entry id
+		ID string
+		// Fields holds the entry field-value pairs
+		Fields map[string]string
+		// Deliveries holds the number of times
+		// the entry has been delivered to the group
+		Deliveries int64
+	}
+
+	// StreamHandler describes logic for
+	// processing a stream entry
+	StreamHandler interface {
+		HandleEntry(*StreamEntry) error
+	}
+
+	// StreamHandlerFunc implements StreamHandler interface
+	StreamHandlerFunc func(*StreamEntry) error
+
+	// StreamConsumer is a struct type
+	// which consumes a stream within a consumer group.
+	//
+	// Entries are acknowledged once the handler succeeds;
+	// failed entries stay pending and are redelivered after
+	// ClaimMinIdle, along with the entries of crashed consumers,
+	// which are reclaimed with XAUTOCLAIM (redis >= 6.2).
+	// Entries delivered more than MaxDeliveries times are moved
+	// to DeadLetterStream without calling the handler.
+	//
+	// With ClusterConnector every command is routed by its
+	// stream key, so the stream and the dead letter stream
+	// may be owned by different nodes
+	StreamConsumer struct {
+		connector Connector
+		logger    *log.Logger
+
+		Handler StreamHandler
+		Stream  string
+		Group   string
+		// Name holds the consumer name, workers are registered
+		// in the group as "<Name>_<worker index>"
+		Name string
+
+		// Workers holds the number of concurrent
+		// group consumers, 1 is used if zero
+		Workers int
+		// Count holds the number of entries read
+		// with a single command, 10 is used if zero
+		Count int
+		// Block holds the XREADGROUP BLOCK timeout,
+		// 1s is used if zero
+		Block time.Duration
+		// ClaimMinIdle holds the idle time after which pending
+		// entries are reclaimed, 1m is used if zero.
+		// It is also the interval between reclaim attempts
+		ClaimMinIdle time.Duration
+		// MaxDeliveries holds the maximum number of entry
+		// deliveries, entries are never dead-lettered if zero.
+		// The handler is called at most MaxDeliveries times,
+		// the entry is dead-lettered when it is reclaimed
+		// once more, ClaimMinIdle after the last failure
+		MaxDeliveries int64
+		// DeadLetterStream holds the stream key the entries
+		// exceeding MaxDeliveries are added to. The entries
+		// are acknowledged and dropped if empty
+		DeadLetterStream string
+	}
+)
+
+// HandleEntry calls f(e)
+func (f StreamHandlerFunc) HandleEntry(e *StreamEntry) error {
+	return f(e)
+}
+
+// NewStreamConsumer inits and returns a pointer
+// to a new StreamConsumer instance.
+// The logs are discarded if logger is nil
+func NewStreamConsumer(c Connector, h StreamHandler, logger *log.Logger, stream, group, name string) *StreamConsumer {
+	return &StreamConsumer{
+		connector: c,
+		logger:    LoggerOrDiscard(logger),
+		Handler:   h,
+		Stream:    stream,
+		Group:     group,
+		Name:      name,
+	}
+}
+
+// Consume creates the consumer group if it does not exist
+// and processes the stream entries with the workers until
+// ctx is done. Errors are logged and followed by a retry
+func (sc *StreamConsumer) Consume(ctx context.Context) error {
+	if err := sc.createGroup(ctx); err != nil {
+		return err
+	}
+
+	workers := sc.Workers
+	if workers <= 0 {
+		workers = 1
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(consumer string) {
+			defer wg.Done()
+			sc.work(ctx, consumer)
+		}(fmt.Sprintf("%s_%d", sc.Name, i))
+	}
+
+	sc.logger.Printf("consuming from stream: %s, group: %s", sc.Stream, sc.Group)
+	wg.Wait()
+
+	return nil
+}
+
+// createGroup creates the consumer group starting
+// from the new entries, existing group is kept as is
+func (sc *StreamConsumer) createGroup(ctx context.Context) error {
+	_, err := sc.do(ctx, "XGROUP", "CREATE", sc.Stream, sc.Group, "$", "MKSTREAM")
+	if redisErr, ok := err.(redis.Error); ok && strings.HasPrefix(string(redisErr), "BUSYGROUP") {
+		return nil
+	}
+	return err
+}
+
+// work reads and reclaims the entries as the consumer
+// until ctx is done
+func (sc *StreamConsumer) work(ctx context.Context, consumer string) {
+	minIdle := sc.ClaimMinIdle
+	if minIdle <= 0 {
+		minIdle = defaultClaimMinIdle
+	}
+
+	var lastClaim time.Time
+	for ctx.Err() == nil {
+		err := sc.read(ctx, consumer)
+
+		if err == nil && time.Since(lastClaim) >= minIdle {
+			lastClaim = time.Now()
+			err = sc.reclaim(ctx, consumer, minIdle)
+		}
+
+		if err == nil || ctx.Err() != nil {
+			continue
+		}
+
+		sc.logger.Printf("redis stream consumer: %s: %s", consumer, err)
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(defaultReconnectInterval):
+		}
+	}
+}
+
+// read reads new entries with XREADGROUP and processes them
+func (sc *StreamConsumer) read(ctx context.Context, consumer string) error {
+	count := sc.Count
+	if count <= 0 {
+		count = defaultStreamCount
+	}
+
+	block := sc.Block
+	if block <= 0 {
+		block = defaultStreamBlock
+	}
+
+	redisConn, err := ConnectContext(ctx, sc.connector)
+	if err != nil {
+		return err
+	}
+
+	reply, err := doBlocking(redisConn, block+blockReadMargin,
+		"XREADGROUP", "GROUP", sc.Group, consumer,
+		"COUNT", count, "BLOCK", int64(block/time.Millisecond),
+		"STREAMS", sc.Stream, ">",
+	)
+	redisConn.Close()
+
+	// nil reply is returned on BLOCK timeout
+	streams, err := redis.Values(reply, err)
+	if err == redis.ErrNil {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	for _, stream := range streams {
+		s, err := redis.Values(stream, nil)
+		if err != nil || len(s) != 2 {
+			return fmt.Errorf("redis: unexpected XREADGROUP reply: %v", stream)
+		}
+
+		entries, err := parseStreamEntries(sc.Stream, s[1])
+		if err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			e.Deliveries = 1
+			sc.process(ctx, e)
+		}
+	}
+
+	return nil
+}
+
+// reclaim claims the entries which have been pending
+// for at least minIdle with XAUTOCLAIM and processes them
+func (sc *StreamConsumer) reclaim(ctx context.Context, consumer string, minIdle time.Duration) error {
+	count := sc.Count
+	if count <= 0 {
+		count = defaultStreamCount
+	}
+
+	cursor := "0-0"
+	for {
+		reply, err := redis.Values(sc.do(ctx, "XAUTOCLAIM", sc.Stream, sc.Group, consumer,
+			int64(minIdle/time.Millisecond), cursor, "COUNT", count))
+		if err != nil {
+			return err
+		}
+
+		if len(reply) < 2 {
+			return fmt.Errorf("redis: unexpected XAUTOCLAIM reply: %v", reply)
+		}
+
+		if cursor, err = redis.String(reply[0], nil); err != nil {
+			return err
+		}
+
+		entries, err := parseStreamEntries(sc.Stream, reply[1])
+		if err != nil {
+			return err
+		}
+
+		if entries, err = sc.deliveries(ctx, entries); err != nil {
+			return err
+		}
+
+		for _, e := range entries {
+			sc.process(ctx, e)
+		}
+
+		if cursor == "0-0" || ctx.Err() != nil {
+			return nil
+		}
+	}
+}
+
+// deliveries sets the entries delivery counters
+// from XPENDING replies sent within a single pipeline
+// and returns the entries which are still pending
+func (sc *StreamConsumer) deliveries(ctx context.Context, entries []*StreamEntry) ([]*StreamEntry, error) {
+	if len(entries) == 0 {
+		return entries, nil
+	}
+
+	redisConn, err := ConnectContext(ctx, sc.connector)
+	if err != nil {
+		return nil, err
+	}
+	defer redisConn.Close()
+
+	p := NewPipeline(redisConn)
+	cmds := make([]*PipelineCmd, len(entries))
+	for i, e := range entries {
+		cmds[i] = p.Send("XPENDING", sc.Stream, sc.Group, e.ID, e.ID, 1)
+	}
+
+	if err := p.Exec(); err != nil {
+		return nil, err
+	}
+
+	kept := entries[:0]
+	for i, pc := range cmds {
+		pending, err := pc.Values()
+		if err != nil {
+			return nil, err
+		}
+
+		// entry has been acknowledged in the meantime
+		if len(pending) == 0 {
+			continue
+		}
+
+		info, err := redis.Values(pending[0], nil)
+		if err != nil || len(info) != 4 {
+			return nil, fmt.Errorf("redis: unexpected XPENDING reply: %v", pending[0])
+		}
+
+		if entries[i].Deliveries, err = redis.Int64(info[3], nil); err != nil {
+			return nil, err
+		}
+
+		kept = append(kept, entries[i])
+	}
+
+	return kept, nil
+}
+
+// process calls the handler and acknowledges the entry on success.
+// Entries deleted from the stream are acknowledged, entries exceeding
+// MaxDeliveries are dead-lettered
+func (sc *StreamConsumer) process(ctx context.Context, e *StreamEntry) {
+	if e.Fields == nil {
+		sc.ack(ctx, e)
+		return
+	}
+
+	if sc.MaxDeliveries > 0 && e.Deliveries > sc.MaxDeliveries {
+		sc.deadLetter(ctx, e)
+		return
+	}
+
+	if err := tryCatch(func() error { return sc.Handler.HandleEntry(e) }); err != nil {
+		sc.logger.Printf("redis stream consumer: stream %s entry %s: %s", e.Stream, e.ID, err)
+		return
+	}
+
+	sc.ack(ctx, e)
+}
+
+// ack acknowledges the entry
+func (sc *StreamConsumer) ack(ctx context.Context, e *StreamEntry) {
+	if _, err := sc.do(ctx, "XACK", sc.Stream, sc.Group, e.ID); err != nil {
+		sc.logger.Printf("redis stream consumer: stream %s entry %s: ack: %s", e.Stream, e.ID, err)
+	}
+}
+
+// deadLetter adds the entry to the dead letter stream
+// and acknowledges it
+func (sc *StreamConsumer) deadLetter(ctx context.Context, e *StreamEntry) {
+	sc.logger.Printf("redis stream consumer: stream %s entry %s: exceeded %d deliveries", e.Stream, e.ID, sc.MaxDeliveries)
+
+	if sc.DeadLetterStream != "" {
+		args := redis.Args{sc.DeadLetterStream, "*"}.AddFlat(e.Fields)
+		if _, err := sc.do(ctx, "XADD", args...); err != nil {
+			sc.logger.Printf("redis stream consumer: stream %s entry %s: dead letter: %s", e.Stream, e.ID, err)
+			return
+		}
+	}
+
+	sc.ack(ctx, e)
+}
+
+// do sends the command on a connection retrieved from the connector
+func (sc *StreamConsumer) do(ctx context.Context, cmd string, args ...interface{}) (interface{}, error) {
+	redisConn, err := ConnectContext(ctx, sc.connector)
+	if err != nil {
+		return nil, err
+	}
+	defer redisConn.Close()
+
+	return redisConn.Do(cmd, args...)
+}
+
+// doBlocking sends a blocking command with the read timeout
+// if the connection supports it, with the connection one otherwise
+func doBlocking(c redis.Conn, timeout time.Duration, cmd string, args ...interface{}) (interface{}, error) {
+	if _, ok := c.(redis.ConnWithTimeout); ok {
+		return redis.DoWithTimeout(c, timeout, cmd, args...)
+	}
+	return c.Do(cmd, args...)
+}
+
+// parseStreamEntries parses a list of [id, [field, value, ...]]
+// replies, fields of the deleted entries are nil
+func parseStreamEntries(stream string, reply interface{}) ([]*StreamEntry, error) {
+	values, err := redis.Values(reply, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*StreamEntry, 0, len(values))
+	for _, v := range values {
+		entry, err := redis.Values(v, nil)
+		if err != nil || len(entry) != 2 {
+			return nil, fmt.Errorf("redis: unexpected stream entry: %v", v)
+		}
+
+		id, err := redis.String(entry[0], nil)
+		if err != nil {
+			return nil, err
+		}
+
+		e := &StreamEntry{Stream: stream, ID: id}
+		if entry[1] != nil {
+			if e.Fields, err = redis.StringMap(entry[1], nil); err != nil {
+				return nil, err
+			}
+		}
+
+		entries = append(entries, e)
+	}
+
+	return entries, nil
+}
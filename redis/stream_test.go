@@ -0,0 +1,161 @@
+package redis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type StreamTestSuite struct{}
+
+var _ = gc.Suite(&StreamTestSuite{})
+
+// streamEntry returns an [id, [field, value, ...]] reply
+func streamEntry(id string, fields ...string) interface{} {
+	if len(fields) == 0 {
+		return []interface{}{[]byte(id), nil}
+	}
+
+	values := make([]interface{}, len(fields))
+	for i, f := range fields {
+		values[i] = []byte(f)
+	}
+	return []interface{}{[]byte(id), values}
+}
+
+func (s *StreamTestSuite) TestReadAcksHandledEntries(c *gc.C) {
+	stub := &recordStub{reply: func(cmd string, args ...interface{}) (interface{}, error) {
+		if cmd == "XREADGROUP" {
+			return []interface{}{[]interface{}{
+				[]byte("events"),
+				[]interface{}{
+					streamEntry("1-0", "type", "panic"),
+					streamEntry("2-0", "type", "goal"),
+				},
+			}}, nil
+		}
+		return int64(1), nil
+	}}
+
+	var logs bytes.Buffer
+	var handled []*StreamEntry
+	consumer := NewStreamConsumer(newStubConnector(stub.do), StreamHandlerFunc(func(e *StreamEntry) error {
+		if e.Fields["type"] == "panic" {
+			panic("test panic")
+		}
+		handled = append(handled, e)
+		return nil
+	}), log.New(&logs, "", 0), "events", "scores", "worker")
+
+	c.Assert(consumer.read(context.Background(), "worker_0"), gc.IsNil)
+	c.Check(handled, gc.DeepEquals, []*StreamEntry{
+		{Stream: "events", ID: "2-0", Fields: map[string]string{"type": "goal"}, Deliveries: 1},
+	})
+	c.Check(stub.commands("XREADGROUP"), gc.DeepEquals, []string{
+		"XREADGROUP GROUP scores worker_0 COUNT 10 BLOCK 1000 STREAMS events >",
+	})
+	c.Check(stub.commands("XACK"), gc.DeepEquals, []string{"XACK events scores 2-0"})
+	c.Check(logs.String(), gc.Equals, "redis stream consumer: stream events entry 1-0: panic: test panic\n")
+}
+
+func (s *StreamTestSuite) TestReadBlockTimeout(c *gc.C) {
+	stub := &recordStub{reply: func(string, ...interface{}) (interface{}, error) {
+		return nil, nil
+	}}
+
+	consumer := NewStreamConsumer(newStubConnector(stub.do), nil, nil, "events", "scores", "worker")
+	c.Check(consumer.read(context.Background(), "worker_0"), gc.IsNil)
+}
+
+func (s *StreamTestSuite) TestReclaimDeadLettersExceededEntries(c *gc.C) {
+	deliveries := map[string]int64{"1-0": 2, "3-0": 4}
+	stub := &recordStub{reply: func(cmd string, args ...interface{}) (interface{}, error) {
+		switch cmd {
+		case "XAUTOCLAIM":
+			return []interface{}{[]byte("0-0"), []interface{}{
+				streamEntry("1-0", "type", "goal"),
+				streamEntry("2-0"),
+				streamEntry("3-0", "type", "card"),
+				streamEntry("4-0", "type", "goal"),
+			}}, nil
+		case "XPENDING":
+			id := args[2].(string)
+			if id == "4-0" {
+				// acknowledged by another consumer after XAUTOCLAIM
+				return []interface{}{}, nil
+			}
+			return []interface{}{[]interface{}{[]byte(id), []byte("worker_1"), int64(60000), deliveries[id]}}, nil
+		case "XADD":
+			return []byte("5-0"), nil
+		}
+		return int64(1), nil
+	}}
+
+	var logs bytes.Buffer
+	var handled []string
+	consumer := NewStreamConsumer(newStubConnector(stub.do), StreamHandlerFunc(func(e *StreamEntry) error {
+		handled = append(handled, fmt.Sprintf("%s:%d", e.ID, e.Deliveries))
+		return errors.New("test error")
+	}), log.New(&logs, "", 0), "events", "scores", "worker")
+	consumer.MaxDeliveries = 3
+	consumer.DeadLetterStream = "events:dead"
+
+	c.Assert(consumer.reclaim(context.Background(), "worker_0", time.Minute), gc.IsNil)
+
+	// failed entry stays pending, deleted entry is acknowledged
+	c.Check(handled, gc.DeepEquals, []string{"1-0:2"})
+	c.Check(stub.commands("XAUTOCLAIM"), gc.DeepEquals, []string{"XAUTOCLAIM events scores worker_0 60000 0-0 COUNT 10"})
+	c.Check(stub.commands("XPENDING"), gc.DeepEquals, []string{
+		"XPENDING events scores 1-0 1-0 1",
+		"XPENDING events scores 2-0 2-0 1",
+		"XPENDING events scores 3-0 3-0 1",
+		"XPENDING events scores 4-0 4-0 1",
+	})
+	c.Check(stub.commands("XADD"), gc.DeepEquals, []string{"XADD events:dead * type card"})
+	c.Check(stub.commands("XACK"), gc.DeepEquals, []string{"XACK events scores 2-0", "XACK events scores 3-0"})
+	c.Check(logs.String(), gc.Equals, "redis stream consumer: stream events entry 1-0: test error\n"+
+		"redis stream consumer: stream events entry 3-0: exceeded 3 deliveries\n")
+}
+
+func (s *StreamTestSuite) TestConsumeKeepsExistingGroup(c *gc.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var claims int32
+	stub := &recordStub{reply: func(cmd string, args ...interface{}) (interface{}, error) {
+		switch cmd {
+		case "XGROUP":
+			return nil, redis.Error("BUSYGROUP Consumer Group name already exists")
+		case "XAUTOCLAIM":
+			// both workers have claimed
+			if atomic.AddInt32(&claims, 1) == 2 {
+				cancel()
+			}
+			return []interface{}{[]byte("0-0"), []interface{}{}}, nil
+		}
+		return nil, nil
+	}}
+
+	consumer := NewStreamConsumer(newStubConnector(stub.do), nil, nil, "events", "scores", "worker")
+	consumer.Workers = 2
+
+	c.Check(consumer.Consume(ctx), gc.IsNil)
+	c.Check(stub.commands("XGROUP"), gc.DeepEquals, []string{"XGROUP CREATE events scores $ MKSTREAM"})
+	c.Check(len(stub.commands("XAUTOCLAIM")), gc.Equals, 2)
+}
+
+func (s *StreamTestSuite) TestConsumeGroupError(c *gc.C) {
+	stub := &recordStub{reply: func(string, ...interface{}) (interface{}, error) {
+		return nil, redis.Error("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}}
+
+	consumer := NewStreamConsumer(newStubConnector(stub.do), nil, nil, "events", "scores", "worker")
+	c.Check(consumer.Consume(context.Background()), gc.ErrorMatches, "WRONGTYPE .*")
+}
@@ -0,0 +1,320 @@
+package redis
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInvalidationChannel holds the default pub/sub
+// channel of the tiered cache invalidation messages
+const DefaultInvalidationChannel = "cache:invalidate"
+
+// invalidationBuckets holds the number of the lruCache
+// invalidation counters the keys are hashed into
+const invalidationBuckets = 256
+
+type (
+	// TieredCache is a struct type
+	// which keeps the hottest keys of a Cache
+	// in a size and ttl bounded in-process LRU.
+	//
+	// Set, Delete and the values loaded or refreshed early
+	// by GetOrLoad publish the keys to the invalidation channel;
+	// every TieredCache instance is expected to be subscribed
+	// to it with a Subscriber, so the local copies of the keys
+	// are evicted on all the other instances:
+	//
+	//  subscriber.Subscribe(tc.Channel, tc)
+	//
+	// The keys are published as "<instance id> <key>" messages,
+	// an instance ignores its own ones. Messages with an empty
+	// instance id (" <key>") evict the key on every instance.
+	//
+	// Invalidation messages published while the subscriber
+	// is reconnecting are lost, TieredCache implements
+	// SubscribeHandler and purges all the local copies
+	// once the subscriber is subscribed again.
+	//
+	// A value read from redis is not kept locally if the key
+	// is invalidated while the value is being read, so a late
+	// reply can not override a newer invalidation
+	TieredCache struct {
+		cache *Cache
+		local *lruCache
+		// id tags the published invalidation messages
+		id string
+
+		// Channel holds the invalidation channel,
+		// DefaultInvalidationChannel is used by default
+		Channel string
+	}
+
+	// lruCache is a struct type
+	// which holds up to size raw values
+	// evicting the least recently used ones
+	lruCache struct {
+		mu    sync.Mutex
+		size  int
+		ttl   time.Duration
+		ll    *list.List
+		items map[string]*list.Element
+
+		// versions counts the invalidations
+		// of the keys hashed into the bucket
+		versions [invalidationBuckets]uint64
+	}
+
+	// lruItem holds a local cache value
+	lruItem struct {
+		key      string
+		b        []byte
+		expireAt time.Time
+	}
+)
+
+// NewTieredCache inits and returns a pointer to TieredCache
+// instance which keeps up to size keys of the cache in-process
+// for ttl at most
+func NewTieredCache(cache *Cache, size int, ttl time.Duration) *TieredCache {
+	// the id is empty if it can not be generated,
+	// so the instance evicts its own keys too
+	id, _ := lockValue()
+
+	return &TieredCache{
+		cache:   cache,
+		local:   newLRUCache(size, ttl),
+		id:      id,
+		Channel: DefaultInvalidationChannel,
+	}
+}
+
+// Get loads the key value into v from the local cache,
+// from redis on local cache miss.
+// ErrCacheMiss is returned if the key is not found
+func (tc *TieredCache) Get(ctx context.Context, key string, v interface{}) error {
+	if b, ok := tc.local.get(key); ok {
+		return tc.cache.codec.Unmarshal(b, v)
+	}
+
+	version := tc.local.version(key)
+	b, err := tc.cache.getBytes(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	tc.local.set(key, b, version)
+	return tc.cache.codec.Unmarshal(b, v)
+}
+
+// Set stores v under the key in redis
+// and invalidates the key local copies
+func (tc *TieredCache) Set(ctx context.Context, key string, v interface{}, ttl time.Duration) error {
+	b, err := tc.cache.codec.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	if err := tc.cache.setBytes(ctx, key, b, ttl); err != nil {
+		return err
+	}
+
+	return tc.invalidate(ctx, key)
+}
+
+// Delete removes the keys from redis
+// and invalidates the keys local copies
+func (tc *TieredCache) Delete(ctx context.Context, keys ...string) error {
+	if err := tc.cache.Delete(ctx, keys...); err != nil {
+		return err
+	}
+
+	return tc.invalidate(ctx, keys...)
+}
+
+// GetOrLoad loads the key value into v from the local cache,
+// falls back to Cache.GetOrLoad on local cache miss
+func (tc *TieredCache) GetOrLoad(ctx context.Context, key string, v interface{}, ttl time.Duration, load LoadFunc) error {
+	if b, ok := tc.local.get(key); ok {
+		return tc.cache.codec.Unmarshal(b, v)
+	}
+
+	version := tc.local.version(key)
+	b, storeErr := tc.cache.getOrLoadBytes(ctx, key, ttl, load, func(ctx context.Context) error {
+		// the loaded value is kept locally, other
+		// instances evict their stale local copies
+		return tc.publish(ctx, key)
+	})
+	if b == nil {
+		return storeErr
+	}
+
+	tc.local.set(key, b, version)
+	if err := tc.cache.codec.Unmarshal(b, v); err != nil {
+		return err
+	}
+
+	return storeErr
+}
+
+// HandleMessage evicts the local copy of the key
+// held by the invalidation message, the messages
+// published by the instance itself are ignored
+func (tc *TieredCache) HandleMessage(m *Message) error {
+	key := string(m.Data)
+	if id, k, ok := strings.Cut(key, " "); ok {
+		if id != "" && id == tc.id {
+			return nil
+		}
+		key = k
+	}
+
+	tc.local.remove(key)
+	return nil
+}
+
+// HandleSubscribe evicts all the local copies as
+// the invalidation messages published while the
+// subscriber was not subscribed are lost
+func (tc *TieredCache) HandleSubscribe(channel string) {
+	tc.Purge()
+}
+
+// Purge evicts all the local copies
+func (tc *TieredCache) Purge() {
+	tc.local.purge()
+}
+
+// invalidate evicts the keys local copies
+// and publishes them to the invalidation channel
+func (tc *TieredCache) invalidate(ctx context.Context, keys ...string) error {
+	for _, key := range keys {
+		tc.local.remove(key)
+	}
+
+	return tc.publish(ctx, keys...)
+}
+
+// publish publishes the keys to the invalidation channel
+func (tc *TieredCache) publish(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+
+	redisConn, err := ConnectContext(ctx, tc.cache.connector)
+	if err != nil {
+		return err
+	}
+	defer redisConn.Close()
+
+	p := NewPipeline(redisConn)
+	for _, key := range keys {
+		p.Send("PUBLISH", tc.Channel, tc.id+" "+key)
+	}
+
+	return p.Exec()
+}
+
+// newLRUCache inits and returns a pointer to lruCache instance
+func newLRUCache(size int, ttl time.Duration) *lruCache {
+	return &lruCache{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+// get returns the key value if it is cached and not expired
+func (c *lruCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+
+	item := el.Value.(*lruItem)
+	if !time.Now().Before(item.expireAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+
+	c.ll.MoveToFront(el)
+	return item.b, true
+}
+
+// version returns the invalidation counter of the key,
+// it is taken before reading the value to be cached
+func (c *lruCache) version(key string) uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.versions[lruBucket(key)]
+}
+
+// set caches the key value evicting
+// the least recently used key if the cache is full.
+// The value is dropped if the key was invalidated
+// since the version was taken
+func (c *lruCache) set(key string, b []byte, version uint64) {
+	if c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.versions[lruBucket(key)] != version {
+		return
+	}
+
+	item := &lruItem{key: key, b: b, expireAt: time.Now().Add(c.ttl)}
+	if el, ok := c.items[key]; ok {
+		el.Value = item
+		c.ll.MoveToFront(el)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(item)
+	if c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// remove evicts the key
+func (c *lruCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.versions[lruBucket(key)]++
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// purge evicts all the keys
+func (c *lruCache) purge() {
+	c.mu.Lock()
+	for i := range c.versions {
+		c.versions[i]++
+	}
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.mu.Unlock()
+}
+
+// removeElement evicts the list element,
+// the caller holds the lock
+func (c *lruCache) removeElement(el *list.Element) {
+	c.ll.Remove(el)
+	delete(c.items, el.Value.(*lruItem).key)
+}
+
+// lruBucket returns the invalidation counter index of the key
+func lruBucket(key string) int {
+	return int(crc16(key)) % invalidationBuckets
+}
@@ -0,0 +1,197 @@
+package redis
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	gc "github.com/go-check/check"
+	"github.com/gomodule/redigo/redis"
+)
+
+type TieredCacheTestSuite struct{}
+
+var _ = gc.Suite(&TieredCacheTestSuite{})
+
+func (s *TieredCacheTestSuite) TestGetKeepsLocalCopyUntilInvalidated(c *gc.C) {
+	ctx := context.Background()
+	connector, store := newMemConnector()
+	tc := NewTieredCache(NewCache(connector, nil), 10, time.Minute)
+
+	store.data["table:1"] = []byte(`{"Home":2,"Away":1}`)
+
+	var score cachedScore
+	c.Assert(tc.Get(ctx, "table:1", &score), gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{2, 1})
+
+	// another instance updates the key, local copy is served
+	store.mu.Lock()
+	store.data["table:1"] = []byte(`{"Home":3,"Away":1}`)
+	store.mu.Unlock()
+
+	c.Assert(tc.Get(ctx, "table:1", &score), gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{2, 1})
+
+	c.Check(tc.HandleMessage(&Message{Channel: tc.Channel, Data: []byte("table:1")}), gc.IsNil)
+	c.Assert(tc.Get(ctx, "table:1", &score), gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{3, 1})
+
+	c.Check(tc.Get(ctx, "table:2", &score), gc.Equals, ErrCacheMiss)
+}
+
+func (s *TieredCacheTestSuite) TestSetAndDeletePublishInvalidations(c *gc.C) {
+	ctx := context.Background()
+	connector, store := newMemConnector()
+	tc := NewTieredCache(NewCache(connector, nil), 10, time.Minute)
+
+	var score cachedScore
+	c.Assert(tc.Set(ctx, "table:1", cachedScore{1, 0}, time.Minute), gc.IsNil)
+	c.Assert(tc.GetOrLoad(ctx, "table:1", &score, time.Minute, func(context.Context) (interface{}, error) {
+		c.Fatal("unexpected load")
+		return nil, nil
+	}), gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{1, 0})
+
+	c.Assert(tc.Delete(ctx, "table:1", "table:2"), gc.IsNil)
+	c.Check(tc.local.ll.Len(), gc.Equals, 0)
+	c.Check(store.published, gc.DeepEquals, []string{
+		"cache:invalidate " + tc.id + " table:1",
+		"cache:invalidate " + tc.id + " table:1",
+		"cache:invalidate " + tc.id + " table:2",
+	})
+}
+
+func (s *TieredCacheTestSuite) TestGetOrLoadStoresLoadedValueLocally(c *gc.C) {
+	ctx := context.Background()
+	connector, store := newMemConnector()
+	tc := NewTieredCache(NewCache(connector, nil), 10, time.Minute)
+
+	loads := 0
+	load := func(context.Context) (interface{}, error) {
+		loads++
+		return cachedScore{4, 4}, nil
+	}
+
+	for i := 0; i < 2; i++ {
+		var score cachedScore
+		c.Assert(tc.GetOrLoad(ctx, "table:1", &score, time.Minute, load), gc.IsNil)
+		c.Check(score, gc.Equals, cachedScore{4, 4})
+	}
+
+	c.Check(loads, gc.Equals, 1)
+	c.Check(string(store.data["table:1"]), gc.Equals, `{"Home":4,"Away":4}`)
+
+	// other instances evict their local copies of the loaded key
+	c.Check(store.published, gc.DeepEquals, []string{"cache:invalidate " + tc.id + " table:1"})
+}
+
+func (s *TieredCacheTestSuite) TestGetOrLoadKeepsLoadedValueWhenSubscribed(c *gc.C) {
+	ctx := context.Background()
+	connector, store := newMemConnector()
+	tc := NewTieredCache(NewCache(connector, nil), 10, time.Minute)
+	other := NewTieredCache(NewCache(connector, nil), 10, time.Minute)
+	other.local.set("table:1", []byte(`{"Home":0,"Away":0}`), other.local.version("table:1"))
+
+	// both instances are subscribed to the invalidation channel
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	handled := make(chan struct{}, 10)
+	var subscribes int32
+	var conns []*pubsubConn
+	for _, instance := range []*TieredCache{tc, other} {
+		conn := newPubSubConn(&subscribes)
+		conns = append(conns, conn)
+
+		subscriber := NewSubscriber(&RedisConnector{pools: []*connPool{{Pool: &redis.Pool{
+			Dial: func() (redis.Conn, error) { return conn, nil },
+		}}}}, nil)
+		subscriber.Subscribe(instance.Channel, MessageHandlerFunc(func(m *Message) error {
+			defer func() { handled <- struct{}{} }()
+			return instance.HandleMessage(m)
+		}))
+		go subscriber.Run(ctx)
+	}
+	for atomic.LoadInt32(&subscribes) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	store.publish = func(channel, message string) {
+		for _, conn := range conns {
+			conn.push([]byte("message"), []byte(channel), []byte(message))
+		}
+	}
+
+	var score cachedScore
+	c.Assert(tc.GetOrLoad(ctx, "table:1", &score, time.Minute, func(context.Context) (interface{}, error) {
+		return cachedScore{4, 4}, nil
+	}), gc.IsNil)
+	<-handled
+	<-handled
+
+	b, ok := tc.local.get("table:1")
+	c.Check(ok, gc.Equals, true)
+	c.Check(string(b), gc.Equals, `{"Home":4,"Away":4}`)
+
+	_, ok = other.local.get("table:1")
+	c.Check(ok, gc.Equals, false)
+}
+
+func (s *TieredCacheTestSuite) TestInvalidationDuringReadIsNotCached(c *gc.C) {
+	ctx := context.Background()
+	connector, _ := newMemConnector()
+	tc := NewTieredCache(NewCache(connector, nil), 10, time.Minute)
+
+	// the key is invalidated while the value is being loaded
+	var score cachedScore
+	c.Assert(tc.GetOrLoad(ctx, "table:1", &score, time.Minute, func(context.Context) (interface{}, error) {
+		tc.HandleMessage(&Message{Channel: tc.Channel, Data: []byte("table:1")})
+		return cachedScore{1, 0}, nil
+	}), gc.IsNil)
+	c.Check(score, gc.Equals, cachedScore{1, 0})
+
+	_, ok := tc.local.get("table:1")
+	c.Check(ok, gc.Equals, false)
+
+	c.Assert(tc.Get(ctx, "table:1", &score), gc.IsNil)
+	_, ok = tc.local.get("table:1")
+	c.Check(ok, gc.Equals, true)
+}
+
+func (s *TieredCacheTestSuite) TestHandleSubscribePurgesLocalCopies(c *gc.C) {
+	tc := NewTieredCache(NewCache(&RedisConnector{}, nil), 10, time.Minute)
+	tc.local.set("table:1", []byte("{}"), tc.local.version("table:1"))
+	version := tc.local.version("table:2")
+
+	tc.HandleSubscribe(tc.Channel)
+	c.Check(tc.local.ll.Len(), gc.Equals, 0)
+
+	// reads started before the purge are not cached
+	tc.local.set("table:2", []byte("{}"), version)
+	c.Check(tc.local.ll.Len(), gc.Equals, 0)
+}
+
+func (s *TieredCacheTestSuite) TestLRUEvictsLeastRecentlyUsed(c *gc.C) {
+	lru := newLRUCache(2, time.Minute)
+	lru.set("a", []byte("1"), 0)
+	lru.set("b", []byte("2"), 0)
+	lru.get("a")
+	lru.set("c", []byte("3"), 0)
+
+	_, ok := lru.get("b")
+	c.Check(ok, gc.Equals, false)
+
+	b, ok := lru.get("a")
+	c.Check(ok, gc.Equals, true)
+	c.Check(string(b), gc.Equals, "1")
+	c.Check(lru.ll.Len(), gc.Equals, 2)
+}
+
+func (s *TieredCacheTestSuite) TestLRUExpiresItems(c *gc.C) {
+	lru := newLRUCache(2, 0)
+	lru.set("a", []byte("1"), 0)
+
+	_, ok := lru.get("a")
+	c.Check(ok, gc.Equals, false)
+	c.Check(lru.items, gc.HasLen, 0)
+}